@@ -30,6 +30,41 @@ import (
 type GpuConfig struct {
 	metav1.TypeMeta `json:",inline"`
 	Sharing         *GpuSharing `json:"sharing,omitempty"`
+	// PowerLimit is the power management limit of the GPU in watts.
+	PowerLimit *int `json:"powerLimit,omitempty"`
+	// LockedGraphicsClocks locks the graphics clock of the GPU to a range.
+	LockedGraphicsClocks *ClockRange `json:"lockedGraphicsClocks,omitempty"`
+	// ApplicationClocks are the clocks the GPU runs applications at.
+	ApplicationClocks *ApplicationClocks `json:"applicationClocks,omitempty"`
+	// Scrub cleans up the GPU when the claim is unprepared, in addition to
+	// any scrubbing required for all claims on the node.
+	Scrub *ScrubPolicy `json:"scrub,omitempty"`
+}
+
+// ClockRange is a range of clock frequencies in MHz.
+type ClockRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// ApplicationClocks holds the memory and graphics clock frequencies in MHz
+// that applications run at.
+type ApplicationClocks struct {
+	Memory   int `json:"memory"`
+	Graphics int `json:"graphics"`
+}
+
+// SupportedGpuSettings holds the ranges of settings supported by a GPU,
+// against which a GpuConfig is validated before it is applied.
+// +k8s:deepcopy-gen=false
+type SupportedGpuSettings struct {
+	// MinPowerLimit and MaxPowerLimit are in watts. Both are 0 if the power
+	// limit of the GPU cannot be changed.
+	MinPowerLimit int
+	MaxPowerLimit int
+	// MaxGraphicsClock and MaxMemoryClock are in MHz.
+	MaxGraphicsClock int
+	MaxMemoryClock   int
 }
 
 // DefaultGpuConfig provides the default GPU configuration.
@@ -71,5 +106,26 @@ func (c *GpuConfig) Validate() error {
 	if c.Sharing == nil {
 		return fmt.Errorf("no sharing strategy set")
 	}
-	return c.Sharing.Validate()
+	if err := c.Sharing.Validate(); err != nil {
+		return err
+	}
+	if c.PowerLimit != nil && *c.PowerLimit <= 0 {
+		return fmt.Errorf("power limit must be greater than 0")
+	}
+	if c.LockedGraphicsClocks != nil {
+		if err := c.LockedGraphicsClocks.Validate(); err != nil {
+			return fmt.Errorf("invalid locked graphics clocks: %w", err)
+		}
+	}
+	if c.ApplicationClocks != nil {
+		if err := c.ApplicationClocks.Validate(); err != nil {
+			return fmt.Errorf("invalid application clocks: %w", err)
+		}
+	}
+	if c.Scrub != nil {
+		if err := c.Scrub.Validate(); err != nil {
+			return fmt.Errorf("invalid scrub policy: %w", err)
+		}
+	}
+	return nil
 }
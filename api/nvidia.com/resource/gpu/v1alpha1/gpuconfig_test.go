@@ -0,0 +1,119 @@
+/**
+# Copyright 2024 NVIDIA CORPORATION
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package v1alpha1_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestGpuConfigPowerAndClocks(t *testing.T) {
+	supported := configapi.SupportedGpuSettings{
+		MinPowerLimit:    100,
+		MaxPowerLimit:    400,
+		MaxGraphicsClock: 1980,
+		MaxMemoryClock:   1593,
+	}
+
+	testCases := []struct {
+		description       string
+		config            configapi.GpuConfig
+		supported         configapi.SupportedGpuSettings
+		expectInvalid     bool
+		expectUnsupported bool
+	}{
+		{
+			description: "no settings",
+			supported:   supported,
+		},
+		{
+			description: "all settings within range",
+			config: configapi.GpuConfig{
+				PowerLimit:           ptr(300),
+				LockedGraphicsClocks: &configapi.ClockRange{Min: 1000, Max: 1980},
+				ApplicationClocks:    &configapi.ApplicationClocks{Memory: 1593, Graphics: 1410},
+			},
+			supported: supported,
+		},
+		{
+			description:   "zero power limit",
+			config:        configapi.GpuConfig{PowerLimit: ptr(0)},
+			supported:     supported,
+			expectInvalid: true,
+		},
+		{
+			description:   "locked clocks range reversed",
+			config:        configapi.GpuConfig{LockedGraphicsClocks: &configapi.ClockRange{Min: 1500, Max: 1000}},
+			supported:     supported,
+			expectInvalid: true,
+		},
+		{
+			description:   "application clocks not set",
+			config:        configapi.GpuConfig{ApplicationClocks: &configapi.ApplicationClocks{}},
+			supported:     supported,
+			expectInvalid: true,
+		},
+		{
+			description:       "power limit above maximum",
+			config:            configapi.GpuConfig{PowerLimit: ptr(500)},
+			supported:         supported,
+			expectUnsupported: true,
+		},
+		{
+			description:       "power limit not changeable",
+			config:            configapi.GpuConfig{PowerLimit: ptr(300)},
+			supported:         configapi.SupportedGpuSettings{MaxGraphicsClock: 1980, MaxMemoryClock: 1593},
+			expectUnsupported: true,
+		},
+		{
+			description:       "locked clocks above maximum",
+			config:            configapi.GpuConfig{LockedGraphicsClocks: &configapi.ClockRange{Min: 1000, Max: 2100}},
+			supported:         supported,
+			expectUnsupported: true,
+		},
+		{
+			description:       "application memory clock above maximum",
+			config:            configapi.GpuConfig{ApplicationClocks: &configapi.ApplicationClocks{Memory: 1600, Graphics: 1410}},
+			supported:         supported,
+			expectUnsupported: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			config := tc.config
+			config.Sharing = configapi.DefaultGpuConfig().Sharing
+
+			err := config.Validate()
+			if tc.expectInvalid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			err = config.ValidateSupported(tc.supported)
+			if tc.expectUnsupported {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+// LeftoverProcessAction is what to do about compute processes still running
+// on a GPU once the claim it was allocated to is unprepared.
+type LeftoverProcessAction string
+
+// These constants represent the actions on leftover processes.
+const (
+	// FailOnLeftoverProcesses fails unpreparing the claim until the
+	// processes have exited.
+	FailOnLeftoverProcesses LeftoverProcessAction = "Fail"
+	// KillLeftoverProcesses kills the processes.
+	KillLeftoverProcesses LeftoverProcessAction = "Kill"
+)
+
+// ScrubPolicy holds the steps taken to clean up a GPU between tenants when
+// the claim it was allocated to is unprepared, before it can be prepared for
+// another claim.
+type ScrubPolicy struct {
+	// LeftoverProcesses is what to do about compute processes still running
+	// on the GPU. No check is made if it is empty.
+	LeftoverProcesses LeftoverProcessAction `json:"leftoverProcesses,omitempty"`
+	// ResetGpu resets the GPU once no processes are left, which also clears
+	// its memory. MIG devices and GPUs shared with other claims are not
+	// reset.
+	ResetGpu bool `json:"resetGpu,omitempty"`
+}
+
+// IsEnabled reports whether the policy has any step to take.
+func (p *ScrubPolicy) IsEnabled() bool {
+	return p != nil && (p.LeftoverProcesses != "" || p.ResetGpu)
+}
+
+// Merge returns the stricter of the steps of two policies. Either may be nil.
+func (p *ScrubPolicy) Merge(other *ScrubPolicy) *ScrubPolicy {
+	merged := &ScrubPolicy{}
+	for _, policy := range []*ScrubPolicy{p, other} {
+		if policy == nil {
+			continue
+		}
+		switch {
+		case policy.LeftoverProcesses == KillLeftoverProcesses:
+			merged.LeftoverProcesses = KillLeftoverProcesses
+		case merged.LeftoverProcesses == "":
+			merged.LeftoverProcesses = policy.LeftoverProcesses
+		}
+		merged.ResetGpu = merged.ResetGpu || policy.ResetGpu
+	}
+	// A GPU is only reset once it has no processes left.
+	if merged.ResetGpu && merged.LeftoverProcesses == "" {
+		merged.LeftoverProcesses = FailOnLeftoverProcesses
+	}
+	return merged
+}
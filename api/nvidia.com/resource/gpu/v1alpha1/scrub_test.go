@@ -0,0 +1,72 @@
+/**
+# Copyright 2024 NVIDIA CORPORATION
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package v1alpha1_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestScrubPolicyMerge(t *testing.T) {
+	testCases := []struct {
+		description string
+		node        *configapi.ScrubPolicy
+		claim       *configapi.ScrubPolicy
+		expected    *configapi.ScrubPolicy
+	}{
+		{
+			description: "no policies",
+			expected:    &configapi.ScrubPolicy{},
+		},
+		{
+			description: "node policy only",
+			node:        &configapi.ScrubPolicy{LeftoverProcesses: configapi.FailOnLeftoverProcesses},
+			expected:    &configapi.ScrubPolicy{LeftoverProcesses: configapi.FailOnLeftoverProcesses},
+		},
+		{
+			description: "claim cannot weaken node policy",
+			node:        &configapi.ScrubPolicy{LeftoverProcesses: configapi.KillLeftoverProcesses, ResetGpu: true},
+			claim:       &configapi.ScrubPolicy{LeftoverProcesses: configapi.FailOnLeftoverProcesses},
+			expected:    &configapi.ScrubPolicy{LeftoverProcesses: configapi.KillLeftoverProcesses, ResetGpu: true},
+		},
+		{
+			description: "claim strengthens node policy",
+			node:        &configapi.ScrubPolicy{LeftoverProcesses: configapi.FailOnLeftoverProcesses},
+			claim:       &configapi.ScrubPolicy{LeftoverProcesses: configapi.KillLeftoverProcesses},
+			expected:    &configapi.ScrubPolicy{LeftoverProcesses: configapi.KillLeftoverProcesses},
+		},
+		{
+			description: "reset implies check for leftover processes",
+			claim:       &configapi.ScrubPolicy{ResetGpu: true},
+			expected:    &configapi.ScrubPolicy{LeftoverProcesses: configapi.FailOnLeftoverProcesses, ResetGpu: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			merged := tc.node.Merge(tc.claim)
+			require.Equal(t, tc.expected, merged)
+			require.NoError(t, merged.Validate())
+		})
+	}
+
+	invalid := &configapi.ScrubPolicy{LeftoverProcesses: "Ignore"}
+	require.Error(t, invalid.Validate())
+}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/api/resource"
 )
@@ -86,6 +87,20 @@ type MpsConfig struct {
 	// DefaultPerDevicePinnedMemoryLimit represents the pinned memory limit per device associated with an MPS daemon.
 	// This is defined as a map of device index or UUI to a memory limit and overrides a setting applied using DefaultPinnedDeviceMemoryLimit.
 	DefaultPerDevicePinnedMemoryLimit MpsPerDevicePinnedMemoryLimit `json:"defaultPerDevicePinnedMemoryLimit,omitempty"`
+	// ClientConfigs holds the limits of the MPS clients using the devices allocated for a request, keyed by the name of the request.
+	// This allows containers sharing a single MPS control daemon to be given different portions of its devices.
+	ClientConfigs map[string]MpsClientConfig `json:"clientConfigs,omitempty"`
+}
+
+// MpsClientConfig provides the limits of the MPS clients using a set of devices.
+// These are passed to the clients as environment variables and override the defaults of the MPS control daemon.
+type MpsClientConfig struct {
+	// ActiveThreadPercentage is the percentage of the threads of each device available to a client.
+	ActiveThreadPercentage *int `json:"activeThreadPercentage,omitempty"`
+	// PinnedDeviceMemoryLimit is the pinned memory limit applied to each device for a client.
+	PinnedDeviceMemoryLimit *resource.Quantity `json:"pinnedDeviceMemoryLimit,omitempty"`
+	// Priority is the scheduling priority of a client, with 0 being normal and 1 being below normal.
+	Priority *int `json:"priority,omitempty"`
 }
 
 // IsTimeSlicing checks if the TimeSlicing strategy is applied.
@@ -208,6 +223,23 @@ func (m MpsPerDevicePinnedMemoryLimit) Normalize(uuids []string, defaultPinnedDe
 	return limits, nil
 }
 
+// PinnedDeviceMemoryLimitString returns the pinned memory limit of a client as a string for the given number of devices.
+// Devices are referred to by their index as enumerated by the client, so the same limit is applied to each of them.
+func (c *MpsClientConfig) PinnedDeviceMemoryLimitString(numDevices int) (string, error) {
+	if c.PinnedDeviceMemoryLimit == nil {
+		return "", nil
+	}
+	megabyte, valid := (limit)(*c.PinnedDeviceMemoryLimit).Megabyte()
+	if !valid {
+		return "", fmt.Errorf("%w: client value set too low: %v", ErrInvalidLimit, c.PinnedDeviceMemoryLimit)
+	}
+	var limits []string
+	for i := 0; i < numDevices; i++ {
+		limits = append(limits, fmt.Sprintf("%d=%s", i, megabyte))
+	}
+	return strings.Join(limits, ","), nil
+}
+
 type limit resource.Quantity
 
 func (d *limit) get(uuids []string) (map[string]string, error) {
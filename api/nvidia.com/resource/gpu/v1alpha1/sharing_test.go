@@ -163,3 +163,49 @@ func TestMpsPerDevicePinnedMemoryLimitNormalize(t *testing.T) {
 func ptr[T any](x T) *T {
 	return &x
 }
+
+func TestMpsClientConfigValidate(t *testing.T) {
+	testCases := []struct {
+		description   string
+		config        configapi.MpsClientConfig
+		expectedError bool
+	}{
+		{
+			description: "empty config",
+		},
+		{
+			description: "valid config",
+			config: configapi.MpsClientConfig{
+				ActiveThreadPercentage:  ptr(50),
+				PinnedDeviceMemoryLimit: ptr(resource.MustParse("1Gi")),
+				Priority:                ptr(1),
+			},
+		},
+		{
+			description:   "active thread percentage too high",
+			config:        configapi.MpsClientConfig{ActiveThreadPercentage: ptr(101)},
+			expectedError: true,
+		},
+		{
+			description:   "pinned device memory limit too low",
+			config:        configapi.MpsClientConfig{PinnedDeviceMemoryLimit: ptr(resource.MustParse("1M"))},
+			expectedError: true,
+		},
+		{
+			description:   "invalid priority",
+			config:        configapi.MpsClientConfig{Priority: ptr(2)},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := tc.config.Validate()
+			if tc.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -62,6 +62,30 @@ func (c *MpsConfig) Validate() error {
 			return fmt.Errorf("active thread percentage must not be greater than 100")
 		}
 	}
+	for request, client := range c.ClientConfigs {
+		if err := client.Validate(); err != nil {
+			return fmt.Errorf("invalid MPS client config for request '%v': %w", request, err)
+		}
+	}
+	return nil
+}
+
+// Validate ensures that MpsClientConfig has a valid set of values.
+func (c *MpsClientConfig) Validate() error {
+	if c.ActiveThreadPercentage != nil {
+		if *c.ActiveThreadPercentage < 0 {
+			return fmt.Errorf("active thread percentage must not be negative")
+		}
+		if *c.ActiveThreadPercentage > 100 {
+			return fmt.Errorf("active thread percentage must not be greater than 100")
+		}
+	}
+	if _, err := c.PinnedDeviceMemoryLimitString(1); err != nil {
+		return err
+	}
+	if c.Priority != nil && *c.Priority != 0 && *c.Priority != 1 {
+		return fmt.Errorf("client priority must be 0 or 1")
+	}
 	return nil
 }
 
@@ -92,3 +116,65 @@ func (s *MigDeviceSharing) Validate() error {
 	}
 	return fmt.Errorf("invalid MIG device sharing settings: %v", s)
 }
+
+// Validate ensures that LeftoverProcessAction has a valid set of values.
+func (a LeftoverProcessAction) Validate() error {
+	switch a {
+	case "", FailOnLeftoverProcesses, KillLeftoverProcesses:
+		return nil
+	}
+	return fmt.Errorf("unknown leftover process action: %v", a)
+}
+
+// Validate ensures that ScrubPolicy has a valid set of values.
+func (p *ScrubPolicy) Validate() error {
+	return p.LeftoverProcesses.Validate()
+}
+
+// Validate ensures that ClockRange has a valid set of values.
+func (r *ClockRange) Validate() error {
+	if r.Min <= 0 {
+		return fmt.Errorf("minimum clock must be greater than 0")
+	}
+	if r.Max < r.Min {
+		return fmt.Errorf("maximum clock must not be less than minimum clock")
+	}
+	return nil
+}
+
+// Validate ensures that ApplicationClocks has a valid set of values.
+func (c *ApplicationClocks) Validate() error {
+	if c.Memory <= 0 {
+		return fmt.Errorf("memory clock must be greater than 0")
+	}
+	if c.Graphics <= 0 {
+		return fmt.Errorf("graphics clock must be greater than 0")
+	}
+	return nil
+}
+
+// ValidateSupported ensures that the power limit and clocks of a GpuConfig
+// are within the ranges supported by a GPU. Whether a pair of application
+// clocks is supported is only known once they are set.
+func (c *GpuConfig) ValidateSupported(supported SupportedGpuSettings) error {
+	if c.PowerLimit != nil {
+		if supported.MaxPowerLimit == 0 {
+			return fmt.Errorf("power limit cannot be changed")
+		}
+		if *c.PowerLimit < supported.MinPowerLimit || *c.PowerLimit > supported.MaxPowerLimit {
+			return fmt.Errorf("power limit of %dW is outside the supported range of %dW to %dW", *c.PowerLimit, supported.MinPowerLimit, supported.MaxPowerLimit)
+		}
+	}
+	if c.LockedGraphicsClocks != nil && c.LockedGraphicsClocks.Max > supported.MaxGraphicsClock {
+		return fmt.Errorf("locked graphics clocks exceed the maximum graphics clock of %dMHz", supported.MaxGraphicsClock)
+	}
+	if c.ApplicationClocks != nil {
+		if c.ApplicationClocks.Graphics > supported.MaxGraphicsClock {
+			return fmt.Errorf("application graphics clock exceeds the maximum graphics clock of %dMHz", supported.MaxGraphicsClock)
+		}
+		if c.ApplicationClocks.Memory > supported.MaxMemoryClock {
+			return fmt.Errorf("application memory clock exceeds the maximum memory clock of %dMHz", supported.MaxMemoryClock)
+		}
+	}
+	return nil
+}
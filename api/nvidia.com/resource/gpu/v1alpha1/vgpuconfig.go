@@ -1,8 +1,26 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
 package v1alpha1
 
 import (
 	"fmt"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 )
 
 type Priority int
@@ -22,18 +40,27 @@ const (
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
-// VGpuConfig holds the set of parameters for configuring a GPU.
+// VGpuConfig holds the set of parameters for configuring a vGPU.
 type VGpuConfig struct {
-	metav1.TypeMeta  `json:",inline"`
-	Core             int64    `json:"core"`
-	Memory           int64    `json:"memory"`
+	metav1.TypeMeta `json:",inline"`
+	// Core is the percentage of the GPU's multiprocessors the vGPU may use.
+	Core int64 `json:"core"`
+	// Memory is the amount of device memory in MiB the vGPU may allocate.
+	Memory int64 `json:"memory"`
+	// MemoryPercentage caps Memory to a percentage of the GPU's total memory.
+	// If unset, Memory is capped to the GPU's total memory.
 	MemoryPercentage int64    `json:"memoryPercentage"`
 	Priority         Priority `json:"priority"`
+	// Sharing optionally configures time-slicing or MPS on the physical GPUs
+	// backing the vGPU.
+	Sharing *GpuSharing `json:"sharing,omitempty"`
 }
 
+// VGpuScalingConfig holds the ratios by which the cores and memory of a
+// physical GPU may be over-subscribed by the vGPUs carved out of it.
 type VGpuScalingConfig struct {
-	CoreScaling   float64
-	MemoryScaling float64
+	CoreScaling   float64 `json:"coreScaling"`
+	MemoryScaling float64 `json:"memoryScaling"`
 }
 
 // DefaultVGpuConfig provides the default VGPU configuration.
@@ -58,20 +85,34 @@ func (c *VGpuConfig) Normalize() error {
 	if c.Memory <= 0 {
 		c.Memory = DefaultMemory
 	}
-	if c.MemoryPercentage < 0 || c.MemoryPercentage > 100 {
+	if c.MemoryPercentage <= 0 || c.MemoryPercentage > 100 {
 		c.MemoryPercentage = DefaultMemoryPercentage
 	}
 	if c.Priority < PriorityLow || c.Priority > PriorityHigh {
 		c.Priority = DefaultPriority
 	}
+	if c.Sharing == nil {
+		return nil
+	}
+	if c.Sharing.Strategy == TimeSlicingStrategy && c.Sharing.TimeSlicingConfig == nil {
+		c.Sharing.TimeSlicingConfig = &TimeSlicingConfig{
+			Interval: ptr.To(DefaultTimeSlice),
+		}
+	}
+	if c.Sharing.Strategy == MpsStrategy && c.Sharing.MpsConfig == nil {
+		c.Sharing.MpsConfig = &MpsConfig{}
+	}
 	return nil
 }
 
-// Validate ensures that GpuConfig has a valid set of values.
+// Validate ensures that VGpuConfig has a valid set of values.
 func (c *VGpuConfig) Validate() error {
 	if c.Core <= 0 {
 		return fmt.Errorf("core must be greater than 0")
 	}
+	if c.Core > 100 {
+		return fmt.Errorf("core must not be greater than 100")
+	}
 	if c.Memory <= 0 {
 		return fmt.Errorf("memory must be greater than 0")
 	}
@@ -81,5 +122,19 @@ func (c *VGpuConfig) Validate() error {
 	if c.Priority < PriorityLow || c.Priority > PriorityHigh {
 		return fmt.Errorf("priority must be between %d and %d", PriorityLow, PriorityHigh)
 	}
+	if c.Sharing != nil {
+		return c.Sharing.Validate()
+	}
+	return nil
+}
+
+// Validate ensures that VGpuScalingConfig has a valid set of values.
+func (c *VGpuScalingConfig) Validate() error {
+	if c.CoreScaling <= 0 {
+		return fmt.Errorf("core scaling must be greater than 0")
+	}
+	if c.MemoryScaling <= 0 {
+		return fmt.Errorf("memory scaling must be greater than 0")
+	}
 	return nil
 }
@@ -0,0 +1,58 @@
+/**
+# Copyright 2024 NVIDIA CORPORATION
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+**/
+
+package v1alpha1_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestVGpuConfigNormalizeMemoryPercentage(t *testing.T) {
+	testCases := []struct {
+		description      string
+		memoryPercentage int64
+		expected         int64
+	}{
+		{
+			description:      "unset",
+			memoryPercentage: 0,
+			expected:         configapi.DefaultMemoryPercentage,
+		},
+		{
+			description:      "within range",
+			memoryPercentage: 50,
+			expected:         50,
+		},
+		{
+			description:      "out of range",
+			memoryPercentage: 150,
+			expected:         configapi.DefaultMemoryPercentage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			config := &configapi.VGpuConfig{Memory: 4096, MemoryPercentage: tc.memoryPercentage}
+			require.NoError(t, config.Normalize())
+			require.NoError(t, config.Validate())
+			require.Equal(t, tc.expected, config.MemoryPercentage)
+		})
+	}
+}
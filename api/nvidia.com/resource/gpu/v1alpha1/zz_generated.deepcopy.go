@@ -24,6 +24,36 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ApplicationClocks) DeepCopyInto(out *ApplicationClocks) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationClocks.
+func (in *ApplicationClocks) DeepCopy() *ApplicationClocks {
+	if in == nil {
+		return nil
+	}
+	out := new(ApplicationClocks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClockRange) DeepCopyInto(out *ClockRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClockRange.
+func (in *ClockRange) DeepCopy() *ClockRange {
+	if in == nil {
+		return nil
+	}
+	out := new(ClockRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GpuConfig) DeepCopyInto(out *GpuConfig) {
 	*out = *in
@@ -33,6 +63,26 @@ func (in *GpuConfig) DeepCopyInto(out *GpuConfig) {
 		*out = new(GpuSharing)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.PowerLimit != nil {
+		in, out := &in.PowerLimit, &out.PowerLimit
+		*out = new(int)
+		**out = **in
+	}
+	if in.LockedGraphicsClocks != nil {
+		in, out := &in.LockedGraphicsClocks, &out.LockedGraphicsClocks
+		*out = new(ClockRange)
+		**out = **in
+	}
+	if in.ApplicationClocks != nil {
+		in, out := &in.ApplicationClocks, &out.ApplicationClocks
+		*out = new(ApplicationClocks)
+		**out = **in
+	}
+	if in.Scrub != nil {
+		in, out := &in.Scrub, &out.Scrub
+		*out = new(ScrubPolicy)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GpuConfig.
@@ -151,6 +201,36 @@ func (in *MigDeviceSharing) DeepCopy() *MigDeviceSharing {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MpsClientConfig) DeepCopyInto(out *MpsClientConfig) {
+	*out = *in
+	if in.ActiveThreadPercentage != nil {
+		in, out := &in.ActiveThreadPercentage, &out.ActiveThreadPercentage
+		*out = new(int)
+		**out = **in
+	}
+	if in.PinnedDeviceMemoryLimit != nil {
+		in, out := &in.PinnedDeviceMemoryLimit, &out.PinnedDeviceMemoryLimit
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Priority != nil {
+		in, out := &in.Priority, &out.Priority
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MpsClientConfig.
+func (in *MpsClientConfig) DeepCopy() *MpsClientConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(MpsClientConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MpsConfig) DeepCopyInto(out *MpsConfig) {
 	*out = *in
@@ -171,6 +251,13 @@ func (in *MpsConfig) DeepCopyInto(out *MpsConfig) {
 			(*out)[key] = val.DeepCopy()
 		}
 	}
+	if in.ClientConfigs != nil {
+		in, out := &in.ClientConfigs, &out.ClientConfigs
+		*out = make(map[string]MpsClientConfig, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MpsConfig.
@@ -204,6 +291,21 @@ func (in MpsPerDevicePinnedMemoryLimit) DeepCopy() MpsPerDevicePinnedMemoryLimit
 	return *out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrubPolicy) DeepCopyInto(out *ScrubPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrubPolicy.
+func (in *ScrubPolicy) DeepCopy() *ScrubPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrubPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TimeSlicingConfig) DeepCopyInto(out *TimeSlicingConfig) {
 	*out = *in
@@ -228,7 +330,11 @@ func (in *TimeSlicingConfig) DeepCopy() *TimeSlicingConfig {
 func (in *VGpuConfig) DeepCopyInto(out *VGpuConfig) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
-	out.Scaling = in.Scaling
+	if in.Sharing != nil {
+		in, out := &in.Sharing, &out.Sharing
+		*out = new(GpuSharing)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VGpuConfig.
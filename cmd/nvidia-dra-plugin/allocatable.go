@@ -29,6 +29,7 @@ type AllocatableDevice struct {
 	Mig         *MigDeviceInfo
 	ImexChannel *ImexChannelInfo
 	VGPU        *VGpuInfo
+	NvlinkGroup *NvlinkGroupInfo
 }
 
 func (d AllocatableDevice) Type() string {
@@ -44,6 +45,9 @@ func (d AllocatableDevice) Type() string {
 	if d.VGPU != nil {
 		return VGpuDeviceType
 	}
+	if d.NvlinkGroup != nil {
+		return NvlinkGroupDeviceType
+	}
 	return UnknownDeviceType
 }
 
@@ -55,6 +59,10 @@ func (d *AllocatableDevice) CanonicalName() string {
 		return d.Mig.CanonicalName()
 	case ImexChannelType:
 		return d.ImexChannel.CanonicalName()
+	case VGpuDeviceType:
+		return d.VGPU.CanonicalName()
+	case NvlinkGroupDeviceType:
+		return d.NvlinkGroup.CanonicalName()
 	}
 	panic("unexpected type for AllocatableDevice")
 }
@@ -67,6 +75,10 @@ func (d *AllocatableDevice) CanonicalIndex() string {
 		return d.Mig.CanonicalIndex()
 	case ImexChannelType:
 		return d.ImexChannel.CanonicalIndex()
+	case VGpuDeviceType:
+		return d.VGPU.CanonicalIndex()
+	case NvlinkGroupDeviceType:
+		return d.NvlinkGroup.CanonicalIndex()
 	}
 	panic("unexpected type for AllocatableDevice")
 }
@@ -79,19 +91,28 @@ func (d *AllocatableDevice) GetDevice() resourceapi.Device {
 		return d.Mig.GetDevice()
 	case ImexChannelType:
 		return d.ImexChannel.GetDevice()
+	case VGpuDeviceType:
+		return d.VGPU.GetDevice()
+	case NvlinkGroupDeviceType:
+		return d.NvlinkGroup.GetDevice()
 	}
 	panic("unexpected type for AllocatableDevice")
 }
 
+// GpuUUIDs returns the UUIDs of the full GPUs in the set, including the
+// members of NVLink groups.
 func (d AllocatableDevices) GpuUUIDs() []string {
 	var uuids []string
 	for _, device := range d {
-		if device.Type() == GpuDeviceType {
+		switch device.Type() {
+		case GpuDeviceType:
 			uuids = append(uuids, device.Gpu.UUID)
+		case NvlinkGroupDeviceType:
+			uuids = append(uuids, device.NvlinkGroup.UUIDs()...)
 		}
 	}
 	slices.Sort(uuids)
-	return uuids
+	return slices.Compact(uuids)
 }
 
 func (d AllocatableDevices) MigDeviceUUIDs() []string {
@@ -110,3 +131,25 @@ func (d AllocatableDevices) UUIDs() []string {
 	slices.Sort(uuids)
 	return uuids
 }
+
+// GpuParentUUIDs returns the UUIDs of the full GPUs the devices in the set
+// are carved out of.
+func (d AllocatableDevices) GpuParentUUIDs() []string {
+	var uuids []string
+	for _, device := range d {
+		uuids = append(uuids, gpuUUIDs(device)...)
+	}
+	slices.Sort(uuids)
+	return slices.Compact(uuids)
+}
+
+// VGpuParents returns the devices backing the vGPUs in the set.
+func (d AllocatableDevices) VGpuParents() VGpuParentDevices {
+	var parents VGpuParentDevices
+	for _, device := range d {
+		if device.Type() == VGpuDeviceType {
+			parents = append(parents, device.VGPU)
+		}
+	}
+	return parents
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 
@@ -53,6 +54,7 @@ type CDIHandler struct {
 	nvdevice         nvdevice.Interface
 	nvcdiDevice      nvcdi.Interface
 	nvcdiClaim       nvcdi.Interface
+	nvcdiWrapper     func(nvcdi.Interface) nvcdi.Interface
 	cache            *cdiapi.Cache
 	driverRoot       string
 	devRoot          string
@@ -127,6 +129,10 @@ func NewCDIHandler(opts ...cdiOption) (*CDIHandler, error) {
 		}
 		h.nvcdiClaim = nvcdilib
 	}
+	if h.nvcdiWrapper != nil {
+		h.nvcdiDevice = h.nvcdiWrapper(h.nvcdiDevice)
+		h.nvcdiClaim = h.nvcdiWrapper(h.nvcdiClaim)
+	}
 	if h.cache == nil {
 		cache, err := cdiapi.NewCache(
 			cdiapi.WithSpecDirs(h.cdiRoot),
@@ -179,18 +185,31 @@ func (cdi *CDIHandler) CreateStandardDeviceSpecFile(allocatable AllocatableDevic
 		commonEdits.ContainerEdits.Env,
 		"NVIDIA_VISIBLE_DEVICES=void")
 
-	// Generate device specs for all full GPUs and MIG devices.
+	// Generate device specs for all full GPUs, MIG devices and NVLink groups.
+	// MIG devices created on demand do not exist yet and are added to claim
+	// specs instead.
 	var deviceSpecs []cdispec.Device
 	for _, device := range allocatable {
 		if device.Type() == ImexChannelType {
 			continue
 		}
-		dspecs, err := cdi.nvcdiDevice.GetDeviceSpecsByID(device.CanonicalIndex())
+		if device.Type() == MigDeviceType && device.Mig.IsDynamic() {
+			continue
+		}
+		// NVLink groups get the combined edits of their member GPUs.
+		ids := strings.Split(device.CanonicalIndex(), ",")
+		dspecs, err := cdi.nvcdiDevice.GetDeviceSpecsByID(ids...)
 		if err != nil {
 			return fmt.Errorf("unable to get device spec for %s: %w", device.CanonicalName(), err)
 		}
-		dspecs[0].Name = device.CanonicalName()
-		deviceSpecs = append(deviceSpecs, dspecs[0])
+		edits := &cdiapi.ContainerEdits{ContainerEdits: &cdispec.ContainerEdits{}}
+		for _, dspec := range dspecs {
+			edits = edits.Append(&cdiapi.ContainerEdits{ContainerEdits: &dspec.ContainerEdits})
+		}
+		deviceSpecs = append(deviceSpecs, cdispec.Device{
+			Name:           device.CanonicalName(),
+			ContainerEdits: *edits.ContainerEdits,
+		})
 	}
 
 	// Generate base spec from commonEdits and deviceEdits.
@@ -230,16 +249,30 @@ func (cdi *CDIHandler) CreateClaimSpecFile(claimUID string, preparedDevices Prep
 	// Generate claim specific specs for each device.
 	var deviceSpecs []cdispec.Device
 	for _, group := range preparedDevices {
-		// If there are no edits passed back as part of the device config state, skip it
-		if group.ConfigState.containerEdits == nil {
-			continue
-		}
-
-		// Apply any edits passed back as part of the device config state to all devices
 		for _, device := range group.Devices {
+			containerEdits := group.ConfigState.ContainerEdits
+			if requests := device.Device().GetRequestNames(); len(requests) > 0 {
+				containerEdits = group.ConfigState.GetContainerEdits(requests[0])
+			}
+
+			// MIG devices created on demand are not part of the base spec,
+			// so their device edits are added to the claim spec instead.
+			if device.Type() == MigDeviceType && device.Mig.Instance != nil {
+				edits, err := cdi.getMigDeviceEdits(device.Mig.Info.UUID)
+				if err != nil {
+					return fmt.Errorf("unable to get device edits for %s: %w", device.CanonicalName(), err)
+				}
+				containerEdits = edits.Append(containerEdits)
+			}
+
+			// If there are no edits for the device, skip it
+			if containerEdits == nil {
+				continue
+			}
+
 			deviceSpec := cdispec.Device{
 				Name:           fmt.Sprintf("%s-%s", claimUID, device.CanonicalName()),
-				ContainerEdits: *group.ConfigState.containerEdits.ContainerEdits,
+				ContainerEdits: *containerEdits.ContainerEdits,
 			}
 
 			deviceSpecs = append(deviceSpecs, deviceSpec)
@@ -278,20 +311,61 @@ func (cdi *CDIHandler) CreateClaimSpecFile(claimUID string, preparedDevices Prep
 	return cdi.cache.WriteSpec(spec.Raw(), specName)
 }
 
+func (cdi *CDIHandler) getMigDeviceEdits(uuid string) (*cdiapi.ContainerEdits, error) {
+	if r := cdi.nvml.Init(); r != nvml.SUCCESS {
+		return nil, fmt.Errorf("failed to initialize NVML: %v", r)
+	}
+	defer func() {
+		if r := cdi.nvml.Shutdown(); r != nvml.SUCCESS {
+			klog.Warningf("failed to shutdown NVML: %v", r)
+		}
+	}()
+
+	dspecs, err := cdi.nvcdiClaim.GetDeviceSpecsByID(uuid)
+	if err != nil {
+		return nil, err
+	}
+	return &cdiapi.ContainerEdits{ContainerEdits: &dspecs[0].ContainerEdits}, nil
+}
+
 func (cdi *CDIHandler) DeleteClaimSpecFile(claimUID string) error {
 	specName := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClaimClass, claimUID)
 	return cdi.cache.RemoveSpec(specName)
 }
 
+// ListClaimSpecFiles returns the claim UIDs of all claim spec files in the CDI root.
+func (cdi *CDIHandler) ListClaimSpecFiles() ([]string, error) {
+	prefix := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClaimClass, "")
+	paths, err := filepath.Glob(filepath.Join(cdi.cdiRoot, prefix+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing claim spec files: %w", err)
+	}
+
+	var claimUIDs []string
+	for _, path := range paths {
+		name := filepath.Base(path)
+		ext := filepath.Ext(name)
+		if ext != ".json" && ext != ".yaml" {
+			continue
+		}
+		claimUIDs = append(claimUIDs, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+	}
+	return claimUIDs, nil
+}
+
 func (cdi *CDIHandler) GetStandardDevice(device *AllocatableDevice) string {
 	if device.Type() == ImexChannelType {
 		return ""
 	}
+	if device.Type() == MigDeviceType && device.Mig.IsDynamic() {
+		return ""
+	}
 	return cdiparser.QualifiedName(cdiVendor, cdiDeviceClass, device.CanonicalName())
 }
 
 func (cdi *CDIHandler) GetClaimDevice(claimUID string, device *AllocatableDevice, containerEdits *cdiapi.ContainerEdits) string {
-	if containerEdits == nil {
+	isDynamicMig := device.Type() == MigDeviceType && device.Mig.IsDynamic()
+	if containerEdits == nil && !isDynamicMig {
 		return ""
 	}
 	return cdiparser.QualifiedName(cdiVendor, cdiClaimClass, fmt.Sprintf("%s-%s", claimUID, device.CanonicalName()))
@@ -0,0 +1,173 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	nvdev "github.com/NVIDIA/go-nvlib/pkg/nvlib/device"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/nvidia-container-toolkit/pkg/nvcdi"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
+)
+
+// mockNvcdi generates CDI device specs for the devices of a mock NVML
+// library, which have no device nodes for nvcdi to discover. The device nodes
+// of each device are backed by /dev/null on the host, so that containers
+// using them can still be started. The common edits are generated by nvcdi
+// from the stub driver files.
+type mockNvcdi struct {
+	nvcdi.Interface
+	nvml *mockNvml
+}
+
+// newMockDeviceLib creates a device library backed by a mock NVML library
+// serving the GPUs of a topology file, along with stub driver files in the
+// given driver root for nvcdi to discover.
+func newMockDeviceLib(driverRoot root, topologyPath string) (*deviceLib, error) {
+	topology, err := LoadMockTopology(topologyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mock NVML topology: %w", err)
+	}
+	nvmllib, err := newMockNvml(topology)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mock NVML library: %w", err)
+	}
+	if err := nvmllib.createDriverFiles(string(driverRoot)); err != nil {
+		return nil, fmt.Errorf("failed to create mock driver files: %w", err)
+	}
+
+	d := deviceLib{
+		Interface:   nvdev.New(nvmllib),
+		nvmllib:     nvmllib,
+		devRoot:     driverRoot.getDevRoot(),
+		killProcess: nvmllib.killProcess,
+	}
+	return &d, nil
+}
+
+// mockCDIOptions returns the options a CDI handler needs to generate specs
+// for the devices of a device library created by newMockDeviceLib.
+func mockCDIOptions(l *deviceLib) []cdiOption {
+	nvmllib := l.nvmllib.(*mockNvml)
+	wrap := func(nvcdilib nvcdi.Interface) nvcdi.Interface {
+		return &mockNvcdi{Interface: nvcdilib, nvml: nvmllib}
+	}
+	return []cdiOption{WithNvcdiWrapper(wrap)}
+}
+
+// createDriverFiles creates empty driver libraries under the given root.
+func (m *mockNvml) createDriverFiles(driverRoot string) error {
+	files := []string{
+		"usr/lib64/libcuda.so." + m.driverVersion,
+		"usr/lib64/libnvidia-ml.so." + m.driverVersion,
+	}
+	for _, file := range files {
+		path := filepath.Join(driverRoot, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDeviceSpecsByID returns the specs of GPUs identified by index or UUID,
+// and of MIG devices identified by UUID or by GPU and MIG device index.
+func (c *mockNvcdi) GetDeviceSpecsByID(ids ...string) ([]cdispec.Device, error) {
+	var specs []cdispec.Device
+	for _, id := range ids {
+		paths, err := c.getDevicePaths(id)
+		if err != nil {
+			return nil, err
+		}
+		spec := cdispec.Device{Name: id}
+		for _, path := range paths {
+			spec.ContainerEdits.DeviceNodes = append(spec.ContainerEdits.DeviceNodes, &cdispec.DeviceNode{
+				Path:     path,
+				HostPath: "/dev/null",
+			})
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// getDevicePaths returns the paths of the device nodes of the device with
+// the given identifier.
+func (c *mockNvcdi) getDevicePaths(id string) ([]string, error) {
+	gpuIndex, migIndex, isMig := strings.Cut(id, ":")
+	var device nvml.Device
+	var ret nvml.Return
+	switch {
+	case strings.HasPrefix(id, "GPU-") || strings.HasPrefix(id, "MIG-"):
+		device, ret = c.nvml.DeviceGetHandleByUUID(id)
+	case isMig:
+		gpu, err := strconv.Atoi(gpuIndex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device identifier %q: %w", id, err)
+		}
+		mig, err := strconv.Atoi(migIndex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device identifier %q: %w", id, err)
+		}
+		device, ret = c.nvml.DeviceGetHandleByIndex(gpu)
+		if ret == nvml.SUCCESS {
+			device, ret = device.GetMigDeviceHandleByIndex(mig)
+		}
+	default:
+		index, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device identifier %q: %w", id, err)
+		}
+		device, ret = c.nvml.DeviceGetHandleByIndex(index)
+	}
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("error getting device %v: %v", id, ret)
+	}
+
+	switch device := device.(type) {
+	case *mockDevice:
+		return []string{fmt.Sprintf("/dev/nvidia%d", device.index)}, nil
+	case *mockMigDevice:
+		gpu := device.computeInstance.gpuInstance.device
+		return append([]string{fmt.Sprintf("/dev/nvidia%d", gpu.index)}, device.capDevicePaths()...), nil
+	}
+	return nil, fmt.Errorf("unexpected device type %T", device)
+}
+
+// capDevicePaths returns the paths of the capability devices granting
+// access to the GPU and compute instance of a MIG device. The minor numbers
+// are made up, as there is no driver to assign them.
+func (m *mockMigDevice) capDevicePaths() []string {
+	gpu := m.computeInstance.gpuInstance.device.index
+	gi := int(m.computeInstance.gpuInstance.info.Id)
+	ci := int(m.computeInstance.info.Id)
+	giMinor := gpu*256 + gi*16
+	return []string{
+		fmt.Sprintf("/dev/nvidia-caps/nvidia-cap%d", giMinor),
+		fmt.Sprintf("/dev/nvidia-caps/nvidia-cap%d", giMinor+1+ci),
+	}
+}
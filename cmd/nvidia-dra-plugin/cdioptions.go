@@ -19,6 +19,7 @@ package main
 import (
 	nvdevice "github.com/NVIDIA/go-nvlib/pkg/nvlib/device"
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/NVIDIA/nvidia-container-toolkit/pkg/nvcdi"
 )
 
 // cdiOption represents a functional option for constructing a CDI handler.
@@ -79,3 +80,10 @@ func WithVendor(vendor string) cdiOption {
 		c.vendor = vendor
 	}
 }
+
+// WithNvcdiWrapper provides an cdiOption to wrap the CDI libraries used by the 'cdi' interface, e.g. to generate specs for devices that nvcdi cannot discover.
+func WithNvcdiWrapper(wrap func(nvcdi.Interface) nvcdi.Interface) cdiOption {
+	return func(c *CDIHandler) {
+		c.nvcdiWrapper = wrap
+	}
+}
@@ -3,43 +3,158 @@ package main
 import (
 	"encoding/json"
 
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
 	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager/checksum"
 )
 
+// Checkpoint is the on-disk record of all prepared claims.
+//
+// The checksum at the top level only covers V1, exactly as written by
+// releases that predate V2. Those releases verify the checksum after
+// dropping the fields they do not know about, so covering V2 with it would
+// make them reject the checkpoint after a downgrade. V2 carries its own
+// checksum instead. Any future versions must follow the same scheme.
 type Checkpoint struct {
 	Checksum checksum.Checksum `json:"checksum"`
 	V1       *CheckpointV1     `json:"v1,omitempty"`
+	V2       *CheckpointV2     `json:"v2,omitempty"`
 }
 
 type CheckpointV1 struct {
-	PreparedClaims PreparedClaims `json:"preparedClaims,omitempty"`
+	PreparedClaims map[string][]*preparedDeviceGroupV1 `json:"preparedClaims,omitempty"`
+}
+
+// The types below freeze the layout of prepared claims in V1, as written by
+// releases that predate V2. They must not change, even as the types they
+// were copied from evolve, because releases that predate V2 only verify the
+// checksum of what they can decode into these types.
+type preparedDeviceGroupV1 struct {
+	Devices     []preparedDeviceV1  `json:"devices"`
+	ConfigState deviceConfigStateV1 `json:"configState"`
+}
+
+type preparedDeviceV1 struct {
+	Gpu         *preparedGpuV1         `json:"gpu"`
+	Mig         *preparedMigDeviceV1   `json:"mig"`
+	ImexChannel *preparedImexChannelV1 `json:"imexChannel"`
+}
+
+type preparedGpuV1 struct {
+	Info   *gpuInfoV1      `json:"info"`
+	Device *drapbv1.Device `json:"device"`
+}
+
+type preparedMigDeviceV1 struct {
+	Info   *migDeviceInfoV1 `json:"info"`
+	Device *drapbv1.Device  `json:"device"`
+}
+
+type preparedImexChannelV1 struct {
+	Info   *imexChannelInfoV1 `json:"info"`
+	Device *drapbv1.Device    `json:"device"`
+}
+
+type gpuInfoV1 struct {
+	UUID string `json:"uuid"`
+}
+
+type migDeviceInfoV1 struct {
+	UUID string `json:"uuid"`
+}
+
+type imexChannelInfoV1 struct {
+	Channel int `json:"channel"`
+}
+
+type deviceConfigStateV1 struct {
+	MpsControlDaemonID string `json:"mpsControlDaemonID"`
+}
+
+// CheckpointV2 extends CheckpointV1 with the full state of each prepared
+// device group, such as its resolved config and container edits. It is the
+// version read and written by the plugin, while V1 is derived from it on
+// every write for the sake of older releases.
+type CheckpointV2 struct {
+	Checksum       checksum.Checksum `json:"checksum"`
+	PreparedClaims PreparedClaims    `json:"preparedClaims,omitempty"`
+	// GpuSettingsSnapshots are the settings of each full GPU, by UUID, from
+	// before the first claim using it changed them. They are restored once
+	// the last claim using the GPU is unprepared.
+	GpuSettingsSnapshots map[string]*GpuSettings `json:"gpuSettingsSnapshots,omitempty"`
+}
+
+// checkpointV1 is the layout of a Checkpoint as known to releases that
+// predate V2. It is used to compute the top-level checksum.
+type checkpointV1 struct {
+	Checksum checksum.Checksum `json:"checksum"`
+	V1       *CheckpointV1     `json:"v1,omitempty"`
 }
 
 func newCheckpoint() *Checkpoint {
 	pc := &Checkpoint{
 		Checksum: 0,
-		V1: &CheckpointV1{
-			PreparedClaims: make(PreparedClaims),
+		V2: &CheckpointV2{
+			PreparedClaims:       make(PreparedClaims),
+			GpuSettingsSnapshots: make(map[string]*GpuSettings),
 		},
 	}
 	return pc
 }
 
 func (cp *Checkpoint) MarshalCheckpoint() ([]byte, error) {
+	cp.V1 = cp.V2.ToV1()
+
+	cp.V2.Checksum = 0
+	out, err := json.Marshal(*cp.V2)
+	if err != nil {
+		return nil, err
+	}
+	cp.V2.Checksum = checksum.New(out)
+
 	cp.Checksum = 0
-	out, err := json.Marshal(*cp)
+	out, err = json.Marshal(checkpointV1{V1: cp.V1})
 	if err != nil {
 		return nil, err
 	}
 	cp.Checksum = checksum.New(out)
+
 	return json.Marshal(*cp)
 }
 
+// UnmarshalCheckpoint loads a checkpoint, migrating it to V2 if it was
+// written by a release that predates V2.
 func (cp *Checkpoint) UnmarshalCheckpoint(data []byte) error {
-	return json.Unmarshal(data, cp)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return err
+	}
+	if cp.V2 == nil && cp.V1 != nil {
+		v2, err := cp.V1.ToV2()
+		if err != nil {
+			return err
+		}
+		cp.V2 = v2
+	}
+	if cp.V2 != nil && cp.V2.GpuSettingsSnapshots == nil {
+		cp.V2.GpuSettingsSnapshots = make(map[string]*GpuSettings)
+	}
+	return nil
 }
 
 func (cp *Checkpoint) VerifyChecksum() error {
+	out, err := json.Marshal(checkpointV1{V1: cp.V1})
+	if err != nil {
+		return err
+	}
+	if err := cp.Checksum.Verify(out); err != nil {
+		return err
+	}
+	if cp.V2 == nil {
+		return nil
+	}
+	return cp.V2.verifyChecksum()
+}
+
+func (cp *CheckpointV2) verifyChecksum() error {
 	ck := cp.Checksum
 	cp.Checksum = 0
 	defer func() {
@@ -51,3 +166,108 @@ func (cp *Checkpoint) VerifyChecksum() error {
 	}
 	return ck.Verify(out)
 }
+
+// ToV2 migrates a V1 checkpoint to V2. State that V1 does not record is left
+// empty.
+func (cp *CheckpointV1) ToV2() (*CheckpointV2, error) {
+	v2 := &CheckpointV2{
+		PreparedClaims: make(PreparedClaims),
+	}
+	for claimUID, groups := range cp.PreparedClaims {
+		var devices PreparedDevices
+		for _, group := range groups {
+			devices = append(devices, group.toV2())
+		}
+		v2.PreparedClaims[claimUID] = devices
+	}
+	out, err := json.Marshal(*v2)
+	if err != nil {
+		return nil, err
+	}
+	v2.Checksum = checksum.New(out)
+	return v2, nil
+}
+
+func (g *preparedDeviceGroupV1) toV2() *PreparedDeviceGroup {
+	group := &PreparedDeviceGroup{
+		ConfigState: DeviceConfigState{
+			MpsControlDaemonID: g.ConfigState.MpsControlDaemonID,
+		},
+	}
+	for _, d := range g.Devices {
+		var device PreparedDevice
+		switch {
+		case d.Gpu != nil:
+			device.Gpu = &PreparedGpu{Device: d.Gpu.Device}
+			if d.Gpu.Info != nil {
+				device.Gpu.Info = &GpuInfo{UUID: d.Gpu.Info.UUID}
+			}
+		case d.Mig != nil:
+			device.Mig = &PreparedMigDevice{Device: d.Mig.Device}
+			if d.Mig.Info != nil {
+				device.Mig.Info = &MigDeviceInfo{UUID: d.Mig.Info.UUID}
+			}
+		case d.ImexChannel != nil:
+			device.ImexChannel = &PreparedImexChannel{Device: d.ImexChannel.Device}
+			if d.ImexChannel.Info != nil {
+				device.ImexChannel.Info = &ImexChannelInfo{Channel: d.ImexChannel.Info.Channel}
+			}
+		}
+		group.Devices = append(group.Devices, device)
+	}
+	return group
+}
+
+// ToV1 derives a V1 checkpoint from V2, dropping all state V1 does not
+// record so that it matches what releases predating V2 wrote themselves.
+// Devices of types unknown to those releases are omitted, as are the device
+// groups and claims left without any devices.
+func (cp *CheckpointV2) ToV1() *CheckpointV1 {
+	v1 := &CheckpointV1{
+		PreparedClaims: make(map[string][]*preparedDeviceGroupV1),
+	}
+	for claimUID, devices := range cp.PreparedClaims {
+		var groups []*preparedDeviceGroupV1
+		for _, group := range devices {
+			if g := group.toV1(); len(g.Devices) > 0 {
+				groups = append(groups, g)
+			}
+		}
+		if len(groups) > 0 {
+			v1.PreparedClaims[claimUID] = groups
+		}
+	}
+	return v1
+}
+
+func (group *PreparedDeviceGroup) toV1() *preparedDeviceGroupV1 {
+	g := &preparedDeviceGroupV1{
+		ConfigState: deviceConfigStateV1{
+			MpsControlDaemonID: group.ConfigState.MpsControlDaemonID,
+		},
+	}
+	for _, device := range group.Devices {
+		var d preparedDeviceV1
+		switch device.Type() {
+		case GpuDeviceType:
+			d.Gpu = &preparedGpuV1{Device: device.Gpu.Device}
+			if device.Gpu.Info != nil {
+				d.Gpu.Info = &gpuInfoV1{UUID: device.Gpu.Info.UUID}
+			}
+		case MigDeviceType:
+			d.Mig = &preparedMigDeviceV1{Device: device.Mig.Device}
+			if device.Mig.Info != nil {
+				d.Mig.Info = &migDeviceInfoV1{UUID: device.Mig.Info.UUID}
+			}
+		case ImexChannelType:
+			d.ImexChannel = &preparedImexChannelV1{Device: device.ImexChannel.Device}
+			if device.ImexChannel.Info != nil {
+				d.ImexChannel.Info = &imexChannelInfoV1{Channel: device.ImexChannel.Info.Channel}
+			}
+		default:
+			continue
+		}
+		g.Devices = append(g.Devices, d)
+	}
+	return g
+}
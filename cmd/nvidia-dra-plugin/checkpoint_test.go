@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager/checksum"
+	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
+)
+
+func TestCheckpoint(t *testing.T) {
+	devices := PreparedDevices{
+		{
+			Devices: PreparedDeviceList{
+				{
+					Gpu: &PreparedGpu{
+						Info:   &GpuInfo{UUID: "GPU-0"},
+						Device: &drapbv1.Device{DeviceName: "gpu-0", PoolName: "node"},
+					},
+				},
+			},
+			ConfigState: DeviceConfigState{
+				MpsControlDaemonID: "mps-0",
+				Config:             &runtime.RawExtension{Raw: []byte(`{"kind":"GpuConfig"}`)},
+				ContainerEdits: &cdiapi.ContainerEdits{
+					ContainerEdits: &cdispec.ContainerEdits{Env: []string{"FOO=bar"}},
+				},
+				PreviousGpuSettings: map[string]*GpuSettings{
+					"GPU-0": {ComputeMode: "DEFAULT"},
+				},
+			},
+		},
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		checkpoint := newCheckpoint()
+		checkpoint.V2.PreparedClaims["claim"] = devices
+		checkpoint.V2.GpuSettingsSnapshots["GPU-0"] = &GpuSettings{ComputeMode: "PROHIBITED"}
+		data, err := checkpoint.MarshalCheckpoint()
+		require.NoError(t, err)
+
+		loaded := &Checkpoint{}
+		require.NoError(t, loaded.UnmarshalCheckpoint(data))
+		require.NoError(t, loaded.VerifyChecksum())
+		require.Equal(t, "PROHIBITED", loaded.V2.GpuSettingsSnapshots["GPU-0"].ComputeMode)
+		require.Equal(t, "mps-0", loaded.V2.PreparedClaims["claim"][0].ConfigState.MpsControlDaemonID)
+		require.Equal(t, []string{"FOO=bar"}, loaded.V2.PreparedClaims["claim"][0].ConfigState.ContainerEdits.Env)
+		require.Equal(t, "DEFAULT", loaded.V2.PreparedClaims["claim"][0].ConfigState.PreviousGpuSettings["GPU-0"].ComputeMode)
+	})
+
+	t.Run("readable by releases predating V2", func(t *testing.T) {
+		migDevice := &drapbv1.Device{DeviceName: "gpu-1-mig-9-4-4", PoolName: "node"}
+		checkpoint := newCheckpoint()
+		checkpoint.V2.PreparedClaims["claim"] = append(slices.Clone(devices),
+			&PreparedDeviceGroup{
+				Devices: PreparedDeviceList{
+					{
+						Mig: &PreparedMigDevice{
+							Info:     &MigDeviceInfo{UUID: "MIG-0"},
+							Instance: &MigInstance{ParentUUID: "GPU-1", Profile: "3g.20gb", PlacementStart: 4, PlacementSize: 4},
+							Device:   migDevice,
+						},
+					},
+					{
+						VGpu: &PreparedVGpu{
+							Info:   &VGpuInfo{ParentUUID: "GPU-2"},
+							Limits: &VGpuLimits{Core: 50},
+							Device: &drapbv1.Device{DeviceName: "vgpu-2-0", PoolName: "node"},
+						},
+					},
+				},
+			},
+			&PreparedDeviceGroup{
+				Devices: PreparedDeviceList{
+					{
+						NvlinkGroup: &PreparedNvlinkGroup{
+							Info:   &NvlinkGroupInfo{Gpus: []*GpuInfo{{UUID: "GPU-3"}, {UUID: "GPU-4"}}},
+							Device: &drapbv1.Device{DeviceName: "nvlink-group-3-4", PoolName: "node"},
+						},
+					},
+				},
+			},
+		)
+		checkpoint.V2.PreparedClaims["vgpu-claim"] = PreparedDevices{
+			{
+				Devices: PreparedDeviceList{
+					{
+						VGpu: &PreparedVGpu{
+							Info:   &VGpuInfo{ParentUUID: "GPU-2", Slot: 1},
+							Device: &drapbv1.Device{DeviceName: "vgpu-2-1", PoolName: "node"},
+						},
+					},
+				},
+			},
+		}
+		data, err := checkpoint.MarshalCheckpoint()
+		require.NoError(t, err)
+
+		// Mimic how such a release loads and verifies the checkpoint, using
+		// the types it knows about.
+		var legacy checkpointV1
+		require.NoError(t, json.Unmarshal(data, &legacy))
+		ck := legacy.Checksum
+		legacy.Checksum = 0
+		out, err := json.Marshal(legacy)
+		require.NoError(t, err)
+		require.NoError(t, ck.Verify(out))
+
+		// Only the devices known to such a release are recorded.
+		require.Equal(t, map[string][]*preparedDeviceGroupV1{
+			"claim": {
+				{
+					Devices: []preparedDeviceV1{
+						{
+							Gpu: &preparedGpuV1{
+								Info:   &gpuInfoV1{UUID: "GPU-0"},
+								Device: &drapbv1.Device{DeviceName: "gpu-0", PoolName: "node"},
+							},
+						},
+					},
+					ConfigState: deviceConfigStateV1{MpsControlDaemonID: "mps-0"},
+				},
+				{
+					Devices: []preparedDeviceV1{
+						{
+							Mig: &preparedMigDeviceV1{
+								Info:   &migDeviceInfoV1{UUID: "MIG-0"},
+								Device: migDevice,
+							},
+						},
+					},
+				},
+			},
+		}, legacy.V1.PreparedClaims)
+	})
+
+	t.Run("migrated from V1", func(t *testing.T) {
+		legacy := checkpointV1{
+			V1: (&CheckpointV2{PreparedClaims: PreparedClaims{"claim": devices}}).ToV1(),
+		}
+		out, err := json.Marshal(legacy)
+		require.NoError(t, err)
+		legacy.Checksum = checksum.New(out)
+		data, err := json.Marshal(legacy)
+		require.NoError(t, err)
+
+		loaded := &Checkpoint{}
+		require.NoError(t, loaded.UnmarshalCheckpoint(data))
+		require.NoError(t, loaded.VerifyChecksum())
+		require.Len(t, loaded.V2.PreparedClaims, 1)
+		require.Equal(t, "mps-0", loaded.V2.PreparedClaims["claim"][0].ConfigState.MpsControlDaemonID)
+		require.Nil(t, loaded.V2.PreparedClaims["claim"][0].ConfigState.Config)
+		require.NotNil(t, loaded.V2.GpuSettingsSnapshots)
+	})
+
+	t.Run("corrupted V2", func(t *testing.T) {
+		checkpoint := newCheckpoint()
+		checkpoint.V2.PreparedClaims["claim"] = devices
+		_, err := checkpoint.MarshalCheckpoint()
+		require.NoError(t, err)
+
+		checkpoint.V2.PreparedClaims["claim"][0].ConfigState.PreviousGpuSettings["GPU-0"].ComputeMode = "PROHIBITED"
+		require.Error(t, checkpoint.VerifyChecksum())
+		checkpoint.V2.PreparedClaims["claim"][0].ConfigState.PreviousGpuSettings["GPU-0"].ComputeMode = "DEFAULT"
+	})
+}
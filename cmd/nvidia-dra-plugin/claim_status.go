@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	resourceapi "k8s.io/api/resource/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/retry"
+)
+
+// PreparedDeviceStatus is the data reported for each prepared device in the
+// status of its ResourceClaim.
+type PreparedDeviceStatus struct {
+	Type               string   `json:"type"`
+	UUID               string   `json:"uuid,omitempty"`
+	ParentUUID         string   `json:"parentUUID,omitempty"`
+	GpuUUIDs           []string `json:"gpuUUIDs,omitempty"`
+	Index              string   `json:"index"`
+	ImexChannel        *int     `json:"imexChannel,omitempty"`
+	SharingStrategy    string   `json:"sharingStrategy,omitempty"`
+	MpsControlDaemonID string   `json:"mpsControlDaemonID,omitempty"`
+}
+
+// GetDeviceStatus returns the status data of each prepared device by name.
+// It relies on device info that is not recorded in the checkpoint, so it can
+// only be used on devices that have just been prepared.
+func (d PreparedDevices) GetDeviceStatus() map[string]*PreparedDeviceStatus {
+	statuses := make(map[string]*PreparedDeviceStatus)
+	for _, group := range d {
+		for _, device := range group.Devices {
+			status := &PreparedDeviceStatus{
+				Type:               device.Type(),
+				Index:              device.CanonicalIndex(),
+				SharingStrategy:    group.ConfigState.SharingStrategy,
+				MpsControlDaemonID: group.ConfigState.MpsControlDaemonID,
+			}
+			var name string
+			switch device.Type() {
+			case GpuDeviceType:
+				name = device.Gpu.Device.DeviceName
+				status.UUID = device.Gpu.Info.UUID
+			case MigDeviceType:
+				name = device.Mig.Device.DeviceName
+				status.UUID = device.Mig.Info.UUID
+				if device.Mig.Instance != nil {
+					status.ParentUUID = device.Mig.Instance.ParentUUID
+				}
+			case ImexChannelType:
+				name = device.ImexChannel.Device.DeviceName
+				status.ImexChannel = &device.ImexChannel.Info.Channel
+			case VGpuDeviceType:
+				name = device.VGpu.Device.DeviceName
+				status.ParentUUID = device.VGpu.Info.ParentUUID
+			case NvlinkGroupDeviceType:
+				name = device.NvlinkGroup.Device.DeviceName
+				status.GpuUUIDs = device.NvlinkGroup.Info.UUIDs()
+			}
+			statuses[name] = status
+		}
+	}
+	return statuses
+}
+
+// UpdateClaimDeviceStatus replaces the status of the devices of a claim
+// allocated from this node with the given data. Passing no data clears it.
+func (s *DeviceState) UpdateClaimDeviceStatus(ctx context.Context, namespace, name string, statuses map[string]*PreparedDeviceStatus) error {
+	client := s.config.clientsets.Core
+	nodeName := s.config.flags.nodeName
+
+	var devices []resourceapi.AllocatedDeviceStatus
+	for device, status := range statuses {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("error encoding status of device %v: %w", device, err)
+		}
+		devices = append(devices, resourceapi.AllocatedDeviceStatus{
+			Driver: DriverName,
+			Pool:   nodeName,
+			Device: device,
+			Data:   runtime.RawExtension{Raw: data},
+		})
+	}
+	slices.SortFunc(devices, func(a, b resourceapi.AllocatedDeviceStatus) int {
+		return strings.Compare(a.Device, b.Device)
+	})
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		claim, err := client.ResourceV1beta1().ResourceClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) && len(devices) == 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		updated := slices.DeleteFunc(slices.Clone(claim.Status.Devices), func(status resourceapi.AllocatedDeviceStatus) bool {
+			return status.Driver == DriverName && status.Pool == nodeName
+		})
+		updated = append(updated, devices...)
+		if len(updated) == 0 && len(claim.Status.Devices) == 0 {
+			return nil
+		}
+		claim.Status.Devices = updated
+
+		_, err = client.ResourceV1beta1().ResourceClaims(namespace).UpdateStatus(ctx, claim, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,146 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+// deviceStatuses decodes the status data of the devices of a claim that were
+// reported by this driver for the given pool.
+func deviceStatuses(t *testing.T, claim *resourceapi.ResourceClaim, pool string) map[string]*PreparedDeviceStatus {
+	statuses := make(map[string]*PreparedDeviceStatus)
+	for _, device := range claim.Status.Devices {
+		if device.Driver != DriverName || device.Pool != pool {
+			continue
+		}
+		var status PreparedDeviceStatus
+		require.NoError(t, json.Unmarshal(device.Data.Raw, &status))
+		statuses[device.Device] = &status
+	}
+	return statuses
+}
+
+func TestClaimDeviceStatus(t *testing.T) {
+	ctx := context.Background()
+	state := newTestDeviceState(t, "gpus:\n- model: L4\n  count: 2\n", &Flags{})
+	claims := state.client.ResourceV1beta1().ResourceClaims("default")
+	d := &driver{client: state.client, state: state.DeviceState}
+
+	claim := state.createClaim(t, "claim", configapi.DefaultGpuConfig(), "gpu-1")
+
+	// Entries reported by other drivers, and by this driver on other nodes,
+	// must be left alone.
+	others := []resourceapi.AllocatedDeviceStatus{
+		{
+			Driver: "other.example.com",
+			Pool:   testNodeName,
+			Device: "nic-0",
+			Data:   runtime.RawExtension{Raw: []byte(`{"ip":"10.0.0.1"}`)},
+		},
+		{
+			Driver: DriverName,
+			Pool:   "other-node",
+			Device: "gpu-0",
+			Data:   runtime.RawExtension{Raw: []byte(`{"type":"gpu","index":"0"}`)},
+		},
+	}
+	claim.Status.Devices = others
+	claim, err := claims.UpdateStatus(ctx, claim, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	resp := d.nodePrepareResource(ctx, &drapbv1.Claim{Namespace: claim.Namespace, Name: claim.Name, UID: string(claim.UID)})
+	require.Empty(t, resp.Error)
+
+	claim, err = claims.Get(ctx, claim.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, claim.Status.Devices, len(others)+1)
+	require.Subset(t, claim.Status.Devices, others)
+	require.Equal(t, map[string]*PreparedDeviceStatus{
+		"gpu-1": {
+			Type:            GpuDeviceType,
+			UUID:            state.allocatable["gpu-1"].Gpu.UUID,
+			Index:           "1",
+			SharingStrategy: string(configapi.TimeSlicingStrategy),
+		},
+	}, deviceStatuses(t, claim, testNodeName))
+
+	unprepareResp := d.nodeUnprepareResource(ctx, &drapbv1.Claim{Namespace: claim.Namespace, Name: claim.Name, UID: string(claim.UID)})
+	require.Empty(t, unprepareResp.Error)
+
+	claim, err = claims.Get(ctx, claim.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, others, claim.Status.Devices)
+}
+
+func TestGetDeviceStatus(t *testing.T) {
+	devices := PreparedDevices{
+		{
+			Devices: PreparedDeviceList{
+				{
+					Gpu: &PreparedGpu{
+						Info:   &GpuInfo{UUID: "GPU-0", index: 0},
+						Device: &drapbv1.Device{DeviceName: "gpu-0", PoolName: testNodeName},
+					},
+				},
+			},
+			ConfigState: DeviceConfigState{
+				SharingStrategy:    string(configapi.MpsStrategy),
+				MpsControlDaemonID: "mps-0",
+			},
+		},
+		{
+			Devices: PreparedDeviceList{
+				{
+					Mig: &PreparedMigDevice{
+						Info:     &MigDeviceInfo{UUID: "MIG-0", index: 2, parent: &GpuInfo{index: 1}},
+						Instance: &MigInstance{ParentUUID: "GPU-1"},
+						Device:   &drapbv1.Device{DeviceName: "gpu-1-mig-9-4-4", PoolName: testNodeName},
+					},
+				},
+			},
+		},
+	}
+
+	require.Equal(t, map[string]*PreparedDeviceStatus{
+		"gpu-0": {
+			Type:               GpuDeviceType,
+			UUID:               "GPU-0",
+			Index:              "0",
+			SharingStrategy:    string(configapi.MpsStrategy),
+			MpsControlDaemonID: "mps-0",
+		},
+		"gpu-1-mig-9-4-4": {
+			Type:       MigDeviceType,
+			UUID:       "MIG-0",
+			ParentUUID: "GPU-1",
+			Index:      "1:2",
+		},
+	}, devices.GetDeviceStatus())
+}
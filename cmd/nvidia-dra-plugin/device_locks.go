@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// DeviceLocks hands out a mutex per physical device, so that claims touching
+// disjoint devices can be prepared and unprepared concurrently while the
+// configuration of any single device is serialized.
+type DeviceLocks struct {
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func NewDeviceLocks() *DeviceLocks {
+	return &DeviceLocks{
+		locks: make(map[string]*sync.Mutex),
+	}
+}
+
+// Lock acquires the locks for all given keys and returns a function that
+// releases them again. Locks are always acquired in sorted order to prevent
+// deadlocks between callers with overlapping keys.
+func (l *DeviceLocks) Lock(keys []string) func() {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	var held []*sync.Mutex
+	for _, key := range keys {
+		lock := l.get(key)
+		lock.Lock()
+		held = append(held, lock)
+	}
+
+	return func() {
+		for _, lock := range slices.Backward(held) {
+			lock.Unlock()
+		}
+	}
+}
+
+func (l *DeviceLocks) get(key string) *sync.Mutex {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.locks[key] == nil {
+		l.locks[key] = &sync.Mutex{}
+	}
+	return l.locks[key]
+}
+
+// deviceLockKeys returns the keys of the locks guarding the physical devices
+// backing an allocatable device. MIG devices and vGPUs share the lock of the
+// full GPU they are carved out of, as configuring them can touch GPU-wide
+// settings. NVLink groups take the locks of all of their member GPUs.
+func deviceLockKeys(name string, device *AllocatableDevice) []string {
+	if device == nil {
+		return []string{name}
+	}
+	if uuids := gpuUUIDs(device); len(uuids) > 0 {
+		return uuids
+	}
+	return []string{name}
+}
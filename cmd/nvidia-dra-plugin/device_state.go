@@ -18,16 +18,21 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
 	"slices"
 	"sync"
 
 	resourceapi "k8s.io/api/resource/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
 	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager"
+	"k8s.io/utils/ptr"
 	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
 
 	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
 )
@@ -39,14 +44,82 @@ type OpaqueDeviceConfig struct {
 
 type DeviceConfigState struct {
 	MpsControlDaemonID string `json:"mpsControlDaemonID"`
-	containerEdits     *cdiapi.ContainerEdits
+
+	// The fields below are only recorded in V2 checkpoints.
+
+	// Config is the normalized config applied to the device group.
+	Config *runtime.RawExtension `json:"config,omitempty"`
+	// ContainerEdits are the edits injected into containers using the
+	// device group through the claim's CDI spec.
+	ContainerEdits *cdiapi.ContainerEdits `json:"containerEdits,omitempty"`
+	// RequestContainerEdits are the edits injected, in addition to
+	// ContainerEdits, into containers using the devices allocated for a
+	// request, by request name.
+	RequestContainerEdits map[string]*cdiapi.ContainerEdits `json:"requestContainerEdits,omitempty"`
+	// VGpuLimits are the limits of each vGPU in the group, by device name.
+	VGpuLimits map[string]*VGpuLimits `json:"vgpuLimits,omitempty"`
+	// SharingStrategy is the strategy the devices in the group are shared
+	// with, if any.
+	SharingStrategy string `json:"sharingStrategy,omitempty"`
+	// PreviousGpuSettings are the settings of each full GPU in the group, by
+	// UUID, in effect before the config was applied.
+	PreviousGpuSettings map[string]*GpuSettings `json:"previousGpuSettings,omitempty"`
+}
+
+// GetContainerEdits returns the edits injected into containers using the
+// devices allocated for the given request.
+func (s *DeviceConfigState) GetContainerEdits(request string) *cdiapi.ContainerEdits {
+	if s.RequestContainerEdits[request] == nil {
+		return s.ContainerEdits
+	}
+	edits := &cdiapi.ContainerEdits{ContainerEdits: &cdispec.ContainerEdits{}}
+	return edits.Append(s.ContainerEdits).Append(s.RequestContainerEdits[request])
+}
+
+// vgpuSharing returns the sharing settings of a group of vGPUs. Groups
+// prepared before configs were recorded only know their sharing strategy.
+func (s *DeviceConfigState) vgpuSharing() (*configapi.GpuSharing, error) {
+	if s.Config == nil {
+		if s.SharingStrategy == "" {
+			return nil, nil
+		}
+		return &configapi.GpuSharing{Strategy: configapi.GpuSharingStrategy(s.SharingStrategy)}, nil
+	}
+	config, err := runtime.Decode(configapi.Decoder, s.Config.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding config: %w", err)
+	}
+	vgpuConfig, ok := config.(*configapi.VGpuConfig)
+	if !ok {
+		return nil, fmt.Errorf("unexpected config type %T", config)
+	}
+	return vgpuConfig.Sharing, nil
 }
 
 type DeviceState struct {
-	sync.Mutex
+	// prepareLock is held for reading while claims are prepared or
+	// unprepared, and for writing by anything that needs to observe the set
+	// of prepared claims without any of them being in flux.
+	prepareLock sync.RWMutex
+	// checkpointLock serializes reads and writes of the checkpoint.
+	checkpointLock sync.Mutex
+	// deviceLocks serializes the configuration of each physical device.
+	deviceLocks *DeviceLocks
+	// healthLock guards the set of devices marked unhealthy.
+	healthLock sync.Mutex
+	unhealthy  sets.Set[string]
+	// healthCleared is signaled when unhealthy marks are cleared outside of
+	// re-enumeration, so that the devices are republished.
+	healthCleared chan struct{}
+	// allocatableLock guards replacing the allocatable devices when they
+	// are re-enumerated. Readers holding prepareLock need not take it.
+	allocatableLock sync.RWMutex
+
 	cdi         *CDIHandler
 	tsManager   *TimeSlicingManager
 	mpsManager  *MpsManager
+	vgpuManager *VGpuManager
+	vgpuLedger  *VGpuLedger
 	allocatable AllocatableDevices
 	config      *Config
 
@@ -56,7 +129,22 @@ type DeviceState struct {
 
 func NewDeviceState(ctx context.Context, config *Config) (*DeviceState, error) {
 	containerDriverRoot := root(config.flags.containerDriverRoot)
-	nvdevlib, err := newDeviceLib(containerDriverRoot)
+	hostDriverRoot := config.flags.hostDriverRoot
+
+	var nvdevlib *deviceLib
+	var mockOptions []cdiOption
+	var err error
+	if config.flags.mockNvmlTopology != "" {
+		// The stub driver files of the mock are at the same path on the host.
+		containerDriverRoot = root(MockDriverRoot)
+		hostDriverRoot = MockDriverRoot
+		nvdevlib, err = newMockDeviceLib(containerDriverRoot, config.flags.mockNvmlTopology)
+		if err == nil {
+			mockOptions = mockCDIOptions(nvdevlib)
+		}
+	} else {
+		nvdevlib, err = newDeviceLib(containerDriverRoot)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create device library: %w", err)
 	}
@@ -69,8 +157,7 @@ func NewDeviceState(ctx context.Context, config *Config) (*DeviceState, error) {
 	devRoot := containerDriverRoot.getDevRoot()
 	klog.Infof("using devRoot=%v", devRoot)
 
-	hostDriverRoot := config.flags.hostDriverRoot
-	cdi, err := NewCDIHandler(
+	cdiOptions := []cdiOption{
 		WithNvml(nvdevlib.nvmllib),
 		WithDeviceLib(nvdevlib),
 		WithDriverRoot(string(containerDriverRoot)),
@@ -79,13 +166,30 @@ func NewDeviceState(ctx context.Context, config *Config) (*DeviceState, error) {
 		WithNvidiaCTKPath(config.flags.nvidiaCTKPath),
 		WithCDIRoot(config.flags.cdiRoot),
 		WithVendor(cdiVendor),
-	)
+	}
+	cdi, err := NewCDIHandler(append(cdiOptions, mockOptions...)...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create CDI handler: %w", err)
 	}
 
 	tsManager := NewTimeSlicingManager(nvdevlib)
-	mpsManager := NewMpsManager(config, nvdevlib, MpsRoot, hostDriverRoot, MpsControlDaemonTemplatePath)
+	var mpsBackend MpsControlDaemonBackend
+	switch config.flags.mpsControlDaemonBackend {
+	case MpsProcessBackendName:
+		mpsBackend, err = NewMpsProcessBackend(containerDriverRoot, nvdevlib.driverLibraryPath, MpsRoot)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create MPS process backend: %w", err)
+		}
+	default:
+		mpsBackend = NewMpsDeploymentBackend(config, hostDriverRoot, MpsControlDaemonTemplatePath)
+	}
+	mpsManager := NewMpsManager(config, nvdevlib, MpsRoot, mpsBackend)
+	vgpuManager := NewVGpuManager(config, VGpuRoot)
+	vgpuLedger := NewVGpuLedger(allocatable, config.flags.vgpuScaling)
+
+	if err := config.registry.Register(NewVGpuUsageCollector(vgpuManager)); err != nil {
+		return nil, fmt.Errorf("unable to register vGPU usage collector: %w", err)
+	}
 
 	if err := cdi.CreateStandardDeviceSpecFile(allocatable); err != nil {
 		return nil, fmt.Errorf("unable to create base CDI spec file: %v", err)
@@ -97,9 +201,14 @@ func NewDeviceState(ctx context.Context, config *Config) (*DeviceState, error) {
 	}
 
 	state := &DeviceState{
+		deviceLocks:       NewDeviceLocks(),
+		unhealthy:         sets.New[string](),
+		healthCleared:     make(chan struct{}, 1),
 		cdi:               cdi,
 		tsManager:         tsManager,
 		mpsManager:        mpsManager,
+		vgpuManager:       vgpuManager,
+		vgpuLedger:        vgpuLedger,
 		allocatable:       allocatable,
 		config:            config,
 		nvdevlib:          nvdevlib,
@@ -111,85 +220,253 @@ func NewDeviceState(ctx context.Context, config *Config) (*DeviceState, error) {
 		return nil, fmt.Errorf("unable to list checkpoints: %v", err)
 	}
 
-	for _, c := range checkpoints {
-		if c == DriverPluginCheckpointFile {
-			return state, nil
+	if !slices.Contains(checkpoints, DriverPluginCheckpointFile) {
+		checkpoint := newCheckpoint()
+		if err := state.checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
+			return nil, fmt.Errorf("unable to sync to checkpoint: %v", err)
 		}
 	}
 
-	checkpoint := newCheckpoint()
-	if err := state.checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
-		return nil, fmt.Errorf("unable to sync to checkpoint: %v", err)
+	if err := state.restoreVGpuLedger(); err != nil {
+		return nil, fmt.Errorf("unable to restore vGPU ledger: %w", err)
+	}
+	if err := state.vgpuManager.SetActivePriorities(state.vgpuLedger.ActivePriorities()); err != nil {
+		return nil, fmt.Errorf("unable to set active vGPU priorities: %w", err)
+	}
+
+	// Clean up after claims that went away while the plugin was not running.
+	// This is best effort, as the API server may not be reachable yet.
+	if err := state.reconcilePreparedClaims(ctx); err != nil {
+		klog.Warningf("Unable to reconcile prepared claims: %v", err)
+	}
+
+	if config.flags.dynamicMig {
+		if err := state.deleteUnknownMigDevices(); err != nil {
+			return nil, fmt.Errorf("unable to clean up unknown MIG devices: %w", err)
+		}
 	}
 
 	return state, nil
 }
 
 func (s *DeviceState) Prepare(ctx context.Context, claim *resourceapi.ResourceClaim) ([]*drapbv1.Device, error) {
-	s.Lock()
-	defer s.Unlock()
+	s.prepareLock.RLock()
+	defer s.prepareLock.RUnlock()
 
 	claimUID := string(claim.UID)
 
-	checkpoint := newCheckpoint()
-	if err := s.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
-		return nil, fmt.Errorf("unable to sync from checkpoint: %v", err)
+	if claim.Status.Allocation == nil {
+		return nil, fmt.Errorf("claim not yet allocated")
+	}
+	var lockKeys []string
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		lockKeys = append(lockKeys, deviceLockKeys(result.Device, s.allocatable[result.Device])...)
 	}
-	preparedClaims := checkpoint.V1.PreparedClaims
+	unlock := s.deviceLocks.Lock(lockKeys)
+	defer unlock()
 
-	if preparedClaims[claimUID] != nil {
-		return preparedClaims[claimUID].GetDevices(), nil
+	checkpoint, err := s.getCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint.V2.PreparedClaims[claimUID] != nil {
+		return checkpoint.V2.PreparedClaims[claimUID].GetDevices(), nil
+	}
+
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		if result.Driver == DriverName && !s.IsHealthy(result.Device) {
+			return nil, fmt.Errorf("device %v is unhealthy", result.Device)
+		}
+	}
+
+	if err := s.checkNvlinkGroupConflicts(checkpoint, claim); err != nil {
+		return nil, err
+	}
+	if err := s.checkVGpuConflicts(checkpoint, claim); err != nil {
+		return nil, err
 	}
 
 	preparedDevices, err := s.prepareDevices(ctx, claim)
 	if err != nil {
+		s.abortPrepare(claim, nil)
 		return nil, fmt.Errorf("prepare devices failed: %w", err)
 	}
 
+	if len(preparedDevices.VGpus()) > 0 {
+		if err := s.vgpuManager.WriteClaimInfo(claim, preparedDevices); err != nil {
+			s.abortPrepare(claim, preparedDevices)
+			return nil, fmt.Errorf("unable to write vGPU claim info: %w", err)
+		}
+	}
+
 	if err := s.cdi.CreateClaimSpecFile(claimUID, preparedDevices); err != nil {
+		s.abortPrepare(claim, preparedDevices)
 		return nil, fmt.Errorf("unable to create CDI spec file for claim: %w", err)
 	}
 
-	preparedClaims[claimUID] = preparedDevices
-	if err := s.checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
-		return nil, fmt.Errorf("unable to sync to checkpoint: %v", err)
+	// The priorities are applied before the claim is committed to the
+	// checkpoint, so that a failure leaves no trace of the claim behind.
+	if err := s.vgpuManager.SetActivePriorities(s.vgpuLedger.ActivePriorities()); err != nil {
+		s.abortPrepare(claim, preparedDevices)
+		return nil, fmt.Errorf("unable to set active vGPU priorities: %w", err)
+	}
+
+	err = s.updateCheckpoint(func(checkpoint *Checkpoint) {
+		checkpoint.V2.PreparedClaims[claimUID] = preparedDevices
+	})
+	if err != nil {
+		s.abortPrepare(claim, preparedDevices)
+		return nil, err
+	}
+
+	// The device status is informational only, and is dropped by the API
+	// server unless the DRAResourceClaimDeviceStatus feature is enabled.
+	if err := s.UpdateClaimDeviceStatus(ctx, claim.Namespace, claim.Name, preparedDevices.GetDeviceStatus()); err != nil {
+		klog.Warningf("Unable to update device status of claim %v/%v: %v", claim.Namespace, claim.Name, err)
 	}
 
-	return preparedClaims[claimUID].GetDevices(), nil
+	return preparedDevices.GetDevices(), nil
 }
 
 func (s *DeviceState) Unprepare(ctx context.Context, claimUID string) error {
-	s.Lock()
-	defer s.Unlock()
+	s.prepareLock.RLock()
+	defer s.prepareLock.RUnlock()
 
-	checkpoint := newCheckpoint()
-	if err := s.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
-		return fmt.Errorf("unable to sync from checkpoint: %v", err)
+	checkpoint, err := s.getCheckpoint()
+	if err != nil {
+		return err
+	}
+	if checkpoint.V2.PreparedClaims[claimUID] == nil {
+		return nil
 	}
-	preparedClaims := checkpoint.V1.PreparedClaims
 
-	if preparedClaims[claimUID] == nil {
+	var lockKeys []string
+	for _, device := range checkpoint.V2.PreparedClaims[claimUID].GetDevices() {
+		lockKeys = append(lockKeys, deviceLockKeys(device.DeviceName, s.allocatable[device.DeviceName])...)
+	}
+	unlock := s.deviceLocks.Lock(lockKeys)
+	defer unlock()
+
+	// Re-read the checkpoint now that the devices are locked, in case the
+	// claim was unprepared concurrently.
+	checkpoint, err = s.getCheckpoint()
+	if err != nil {
+		return err
+	}
+	preparedDevices := checkpoint.V2.PreparedClaims[claimUID]
+	if preparedDevices == nil {
 		return nil
 	}
 
-	if err := s.unprepareDevices(ctx, claimUID, preparedClaims[claimUID]); err != nil {
+	if err := s.unprepareDevices(ctx, checkpoint, claimUID, preparedDevices); err != nil {
 		return fmt.Errorf("unprepare devices failed: %w", err)
 	}
 
-	err := s.cdi.DeleteClaimSpecFile(claimUID)
+	// Claims recorded without their config were prepared by a release that
+	// did not snapshot GPU settings.
+	legacy := slices.ContainsFunc(preparedDevices, func(group *PreparedDeviceGroup) bool {
+		return group.ConfigState.Config == nil
+	})
+	restored, err := s.restoreGpuSettings(checkpoint, claimUID, preparedDevices.FullGpuUUIDs(), legacy)
 	if err != nil {
+		return err
+	}
+
+	if err := s.cdi.DeleteClaimSpecFile(claimUID); err != nil {
 		return fmt.Errorf("unable to delete CDI spec file for claim: %w", err)
 	}
 
-	delete(preparedClaims, claimUID)
+	err = s.updateCheckpoint(func(checkpoint *Checkpoint) {
+		delete(checkpoint.V2.PreparedClaims, claimUID)
+		for _, uuid := range restored {
+			delete(checkpoint.V2.GpuSettingsSnapshots, uuid)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	s.vgpuLedger.Release(claimUID)
+	if err := s.vgpuManager.SetActivePriorities(s.vgpuLedger.ActivePriorities()); err != nil {
+		return fmt.Errorf("unable to set active vGPU priorities: %w", err)
+	}
+
+	return nil
+}
+
+// getCheckpoint reads the current checkpoint.
+func (s *DeviceState) getCheckpoint() (*Checkpoint, error) {
+	s.checkpointLock.Lock()
+	defer s.checkpointLock.Unlock()
+
+	checkpoint := newCheckpoint()
+	if err := s.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
+		return nil, fmt.Errorf("unable to sync from checkpoint: %v", err)
+	}
+	return checkpoint, nil
+}
+
+// updateCheckpoint applies a change to the current checkpoint and writes it
+// back. Updates are serialized so that concurrent changes for different
+// claims are not lost.
+func (s *DeviceState) updateCheckpoint(update func(*Checkpoint)) error {
+	s.checkpointLock.Lock()
+	defer s.checkpointLock.Unlock()
+
+	checkpoint := newCheckpoint()
+	if err := s.checkpointManager.GetCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
+		return fmt.Errorf("unable to sync from checkpoint: %v", err)
+	}
+	update(checkpoint)
 	if err := s.checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, checkpoint); err != nil {
 		return fmt.Errorf("unable to sync to checkpoint: %v", err)
 	}
+	return nil
+}
+
+// abortPrepare drops the vGPU capacity, vGPU control directory and MIG
+// devices held by a claim whose preparation failed part way through, and
+// restores the settings of any GPUs it changed.
+func (s *DeviceState) abortPrepare(claim *resourceapi.ResourceClaim, devices PreparedDevices) {
+	claimUID := string(claim.UID)
+	s.vgpuLedger.Release(claimUID)
+	if err := s.vgpuManager.SetActivePriorities(s.vgpuLedger.ActivePriorities()); err != nil {
+		klog.Warningf("Error resetting active vGPU priorities for claim %v: %v", claimUID, err)
+	}
+	s.abortGpuSettings(claim)
+	if err := s.vgpuManager.DeleteClaimDir(claimUID); err != nil {
+		klog.Warningf("Error cleaning up vGPU claim directory for claim %v: %v", claimUID, err)
+	}
+	for _, device := range devices.MigDevices() {
+		if device.Mig.Instance == nil {
+			continue
+		}
+		if err := s.nvdevlib.deleteMigDevice(device.Mig.Instance); err != nil {
+			klog.Warningf("Error deleting MIG device %v for claim %v: %v", device.Mig.Info.UUID, claimUID, err)
+		}
+	}
+}
+
+// restoreVGpuLedger rebuilds the vGPU ledger from the vGPUs of all claims
+// recorded as prepared in the checkpoint.
+func (s *DeviceState) restoreVGpuLedger() error {
+	checkpoint, err := s.getCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	for claimUID, devices := range checkpoint.V2.PreparedClaims {
+		for _, group := range devices {
+			for _, device := range group.Devices.VGpus() {
+				s.vgpuLedger.Restore(claimUID, device.VGpu.Info, device.VGpu.Limits)
+			}
+		}
+	}
 
 	return nil
 }
 
-func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.ResourceClaim) (PreparedDevices, error) {
+func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.ResourceClaim) (_ PreparedDevices, rerr error) {
 	if claim.Status.Allocation == nil {
 		return nil, fmt.Errorf("claim not yet allocated")
 	}
@@ -219,18 +496,32 @@ func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.Res
 		Requests: []string{},
 		Config:   configapi.DefaultImexChannelConfig(),
 	})
+	configs = slices.Insert(configs, 0, &OpaqueDeviceConfig{
+		Requests: []string{},
+		Config:   configapi.DefaultVGpuConfig(),
+	})
+
+	// Look up the devices allocated to the claim, creating any MIG devices
+	// that are only created on demand. These are destroyed again if the claim
+	// fails to be prepared.
+	allocatable, err := s.createMigDevices(claim)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if rerr != nil {
+			s.deleteMigDevices(allocatable)
+		}
+	}()
 
 	// Look through the configs and figure out which one will be applied to
 	// each device allocation result based on their order of precedence and type.
 	configResultsMap := make(map[runtime.Object][]*resourceapi.DeviceRequestAllocationResult)
 	for _, result := range claim.Status.Allocation.Devices.Results {
-		device, exists := s.allocatable[result.Device]
-		if !exists {
-			return nil, fmt.Errorf("requested device is not allocatable: %v", result.Device)
-		}
+		device := allocatable[result.Device]
 		for _, c := range slices.Backward(configs) {
 			if slices.Contains(c.Requests, result.Request) {
-				if _, ok := c.Config.(*configapi.GpuConfig); ok && device.Type() != GpuDeviceType {
+				if _, ok := c.Config.(*configapi.GpuConfig); ok && device.Type() != GpuDeviceType && device.Type() != NvlinkGroupDeviceType {
 					return nil, fmt.Errorf("cannot apply GPU config to request: %v", result.Request)
 				}
 				if _, ok := c.Config.(*configapi.MigDeviceConfig); ok && device.Type() != MigDeviceType {
@@ -239,11 +530,14 @@ func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.Res
 				if _, ok := c.Config.(*configapi.ImexChannelConfig); ok && device.Type() != ImexChannelType {
 					return nil, fmt.Errorf("cannot apply Imex Channel config to request: %v", result.Request)
 				}
+				if _, ok := c.Config.(*configapi.VGpuConfig); ok && device.Type() != VGpuDeviceType {
+					return nil, fmt.Errorf("cannot apply vGPU config to request: %v", result.Request)
+				}
 				configResultsMap[c.Config] = append(configResultsMap[c.Config], &result)
 				break
 			}
 			if len(c.Requests) == 0 {
-				if _, ok := c.Config.(*configapi.GpuConfig); ok && device.Type() != GpuDeviceType {
+				if _, ok := c.Config.(*configapi.GpuConfig); ok && device.Type() != GpuDeviceType && device.Type() != NvlinkGroupDeviceType {
 					continue
 				}
 				if _, ok := c.Config.(*configapi.MigDeviceConfig); ok && device.Type() != MigDeviceType {
@@ -252,6 +546,9 @@ func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.Res
 				if _, ok := c.Config.(*configapi.ImexChannelConfig); ok && device.Type() != ImexChannelType {
 					continue
 				}
+				if _, ok := c.Config.(*configapi.VGpuConfig); ok && device.Type() != VGpuDeviceType {
+					continue
+				}
 				configResultsMap[c.Config] = append(configResultsMap[c.Config], &result)
 				break
 			}
@@ -272,6 +569,8 @@ func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.Res
 			config = castConfig
 		case *configapi.ImexChannelConfig:
 			config = castConfig
+		case *configapi.VGpuConfig:
+			config = castConfig
 		default:
 			return nil, fmt.Errorf("runtime object is not a recognized configuration")
 		}
@@ -287,11 +586,18 @@ func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.Res
 		}
 
 		// Apply the config to the list of results associated with it.
-		configState, err := s.applyConfig(ctx, config, claim, results)
+		configState, err := s.applyConfig(ctx, config, claim, allocatable, results)
 		if err != nil {
 			return nil, fmt.Errorf("error applying GPU config: %w", err)
 		}
 
+		// Record the normalized config alongside the state it resulted in.
+		raw, err := json.Marshal(config)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding GPU config: %w", err)
+		}
+		configState.Config = &runtime.RawExtension{Raw: raw}
+
 		// Capture the prepared device group config in the map.
 		preparedDeviceGroupConfigState[c] = configState
 	}
@@ -306,10 +612,10 @@ func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.Res
 
 		for _, result := range results {
 			cdiDevices := []string{}
-			if d := s.cdi.GetStandardDevice(s.allocatable[result.Device]); d != "" {
+			if d := s.cdi.GetStandardDevice(allocatable[result.Device]); d != "" {
 				cdiDevices = append(cdiDevices, d)
 			}
-			if d := s.cdi.GetClaimDevice(string(claim.UID), s.allocatable[result.Device], preparedDeviceGroupConfigState[c].containerEdits); d != "" {
+			if d := s.cdi.GetClaimDevice(string(claim.UID), allocatable[result.Device], preparedDeviceGroupConfigState[c].GetContainerEdits(result.Request)); d != "" {
 				cdiDevices = append(cdiDevices, d)
 			}
 
@@ -321,20 +627,32 @@ func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.Res
 			}
 
 			var preparedDevice PreparedDevice
-			switch s.allocatable[result.Device].Type() {
+			switch allocatable[result.Device].Type() {
 			case GpuDeviceType:
 				preparedDevice.Gpu = &PreparedGpu{
-					Info:   s.allocatable[result.Device].Gpu,
+					Info:   allocatable[result.Device].Gpu,
 					Device: device,
 				}
 			case MigDeviceType:
 				preparedDevice.Mig = &PreparedMigDevice{
-					Info:   s.allocatable[result.Device].Mig,
-					Device: device,
+					Info:     allocatable[result.Device].Mig,
+					Instance: allocatable[result.Device].Mig.Instance(),
+					Device:   device,
 				}
 			case ImexChannelType:
 				preparedDevice.ImexChannel = &PreparedImexChannel{
-					Info:   s.allocatable[result.Device].ImexChannel,
+					Info:   allocatable[result.Device].ImexChannel,
+					Device: device,
+				}
+			case VGpuDeviceType:
+				preparedDevice.VGpu = &PreparedVGpu{
+					Info:   allocatable[result.Device].VGPU,
+					Limits: preparedDeviceGroupConfigState[c].VGpuLimits[result.Device],
+					Device: device,
+				}
+			case NvlinkGroupDeviceType:
+				preparedDevice.NvlinkGroup = &PreparedNvlinkGroup{
+					Info:   allocatable[result.Device].NvlinkGroup,
 					Device: device,
 				}
 			}
@@ -347,7 +665,7 @@ func (s *DeviceState) prepareDevices(ctx context.Context, claim *resourceapi.Res
 	return preparedDevices, nil
 }
 
-func (s *DeviceState) unprepareDevices(ctx context.Context, claimUID string, devices PreparedDevices) error {
+func (s *DeviceState) unprepareDevices(ctx context.Context, checkpoint *Checkpoint, claimUID string, devices PreparedDevices) error {
 	for _, group := range devices {
 		// Stop any MPS control daemons started for each group of prepared devices.
 		mpsControlDaemon := s.mpsManager.NewMpsControlDaemon(claimUID, group)
@@ -355,29 +673,433 @@ func (s *DeviceState) unprepareDevices(ctx context.Context, claimUID string, dev
 			return fmt.Errorf("error stopping MPS control daemon: %w", err)
 		}
 
-		// Go back to default time-slicing for all full GPUs.
-		tsc := configapi.DefaultGpuConfig().Sharing.TimeSlicingConfig
-		if err := s.tsManager.SetTimeSlice(group.Devices.Gpus(), tsc); err != nil {
-			return fmt.Errorf("error setting timeslice for devices: %w", err)
+		// Remove the control directory of any vGPUs.
+		if len(group.Devices.VGpus()) > 0 {
+			if err := s.vgpuManager.DeleteClaimDir(claimUID); err != nil {
+				return fmt.Errorf("error removing vGPU claim directory: %w", err)
+			}
+		}
+
+		// Scrub the devices before any MIG devices are destroyed, so that
+		// processes left on them are found. Failing leaves the claim
+		// prepared, to be scrubbed again when unprepare is retried.
+		if err := s.scrubDevices(ctx, checkpoint, claimUID, group); err != nil {
+			return fmt.Errorf("error scrubbing devices: %w", err)
+		}
+
+		// Destroy any MIG devices created on demand for the claim.
+		for _, device := range group.Devices.MigDevices() {
+			if device.Mig.Instance == nil {
+				continue
+			}
+			if err := s.nvdevlib.deleteMigDevice(device.Mig.Instance); err != nil {
+				return fmt.Errorf("error deleting MIG device %v: %w", device.Mig.Info.UUID, err)
+			}
 		}
 	}
 	return nil
 }
 
-func (s *DeviceState) applyConfig(ctx context.Context, config configapi.Interface, claim *resourceapi.ResourceClaim, results []*resourceapi.DeviceRequestAllocationResult) (*DeviceConfigState, error) {
+// scrubDevices cleans up the full GPUs and MIG devices of a group of
+// prepared devices as required by the scrub policy of the node and that of
+// the config applied to the group. Devices still used by other prepared
+// claims are skipped, as are vGPUs, as the parent GPU they are carved from is
+// shared with co-tenants.
+func (s *DeviceState) scrubDevices(ctx context.Context, checkpoint *Checkpoint, claimUID string, group *PreparedDeviceGroup) error {
+	var groupPolicy *configapi.ScrubPolicy
+	if group.ConfigState.Config != nil {
+		config, err := runtime.Decode(configapi.Decoder, group.ConfigState.Config.Raw)
+		if err != nil {
+			return fmt.Errorf("error decoding config of device group: %w", err)
+		}
+		if gpuConfig, ok := config.(*configapi.GpuConfig); ok {
+			groupPolicy = gpuConfig.Scrub
+		}
+	}
+	policy := s.config.flags.scrubPolicy.Merge(groupPolicy)
+	if !policy.IsEnabled() {
+		return nil
+	}
+
+	inUse := sets.New[string]()
+	for uid, devices := range checkpoint.V2.PreparedClaims {
+		if uid != claimUID {
+			inUse.Insert(devices.FullGpuUUIDs()...)
+			inUse.Insert(devices.MigDeviceUUIDs()...)
+		}
+	}
+	gpus := slices.DeleteFunc(group.GpuUUIDs(), inUse.Has)
+	migDevices := slices.DeleteFunc(group.MigDeviceUUIDs(), inUse.Has)
+
+	if policy.LeftoverProcesses != "" {
+		uuids := append(slices.Clone(gpus), migDevices...)
+		if err := s.nvdevlib.stopLeftoverProcesses(ctx, uuids, policy.LeftoverProcesses); err != nil {
+			return fmt.Errorf("error stopping leftover processes: %w", err)
+		}
+	}
+	if policy.ResetGpu {
+		for _, uuid := range gpus {
+			klog.Infof("Resetting GPU %v for claim %v", uuid, claimUID)
+			if err := s.nvdevlib.resetGpu(uuid); err != nil {
+				return fmt.Errorf("error resetting GPU %v: %w", uuid, err)
+			}
+		}
+		s.clearUnhealthyGpus(gpus...)
+	}
+	return nil
+}
+
+// abortGpuSettings restores the settings of the full GPUs allocated to a
+// claim whose preparation failed, unless other claims still use them.
+func (s *DeviceState) abortGpuSettings(claim *resourceapi.ResourceClaim) {
+	claimUID := string(claim.UID)
+	allocatable := s.Allocatable()
+
+	var uuids []string
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		device, exists := allocatable[result.Device]
+		if result.Driver != DriverName || !exists {
+			continue
+		}
+		switch device.Type() {
+		case GpuDeviceType:
+			uuids = append(uuids, device.Gpu.UUID)
+		case NvlinkGroupDeviceType:
+			uuids = append(uuids, device.NvlinkGroup.UUIDs()...)
+		case VGpuDeviceType:
+			if !device.VGPU.IsMigBacked() {
+				uuids = append(uuids, device.VGPU.ParentUUID)
+			}
+		}
+	}
+
+	checkpoint, err := s.getCheckpoint()
+	if err != nil {
+		klog.Warningf("Error restoring GPU settings for claim %v: %v", claimUID, err)
+		return
+	}
+	restored, err := s.restoreGpuSettings(checkpoint, claimUID, uuids, false)
+	if err != nil {
+		klog.Warningf("Error restoring GPU settings for claim %v: %v", claimUID, err)
+		return
+	}
+	err = s.updateCheckpoint(func(checkpoint *Checkpoint) {
+		for _, uuid := range restored {
+			delete(checkpoint.V2.GpuSettingsSnapshots, uuid)
+		}
+	})
+	if err != nil {
+		klog.Warningf("Error dropping GPU settings snapshots for claim %v: %v", claimUID, err)
+	}
+}
+
+// checkNvlinkGroupConflicts ensures that no GPU allocated to a claim as part
+// of an NVLink group is in use by another prepared claim, and vice versa. The
+// scheduler cannot prevent this, as NVLink groups and their member GPUs are
+// published as independent devices.
+func (s *DeviceState) checkNvlinkGroupConflicts(checkpoint *Checkpoint, claim *resourceapi.ResourceClaim) error {
+	allocatable := s.Allocatable()
+
+	uuids := sets.New[string]()
+	hasGroup := false
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		device, exists := allocatable[result.Device]
+		if result.Driver != DriverName || !exists {
+			continue
+		}
+		switch device.Type() {
+		case GpuDeviceType:
+			uuids.Insert(device.Gpu.UUID)
+		case NvlinkGroupDeviceType:
+			uuids.Insert(device.NvlinkGroup.UUIDs()...)
+			hasGroup = true
+		case VGpuDeviceType:
+			// GPUs with MIG enabled are never members of NVLink groups, so
+			// only vGPUs backed by full GPUs can overlap them.
+			if !device.VGPU.IsMigBacked() {
+				uuids.Insert(device.VGPU.ParentUUID)
+			}
+		}
+	}
+
+	for uid, devices := range checkpoint.V2.PreparedClaims {
+		if uid == string(claim.UID) {
+			continue
+		}
+		otherHasGroup := slices.ContainsFunc(devices, func(group *PreparedDeviceGroup) bool {
+			return len(group.Devices.NvlinkGroups()) > 0
+		})
+		if !hasGroup && !otherHasGroup {
+			continue
+		}
+		if inUse := uuids.Intersection(sets.New(devices.FullGpuUUIDs()...)); inUse.Len() > 0 {
+			return fmt.Errorf("GPUs %v of NVLink group are in use by claim %v", sets.List(inUse), uid)
+		}
+	}
+	return nil
+}
+
+// checkVGpuConflicts ensures that no GPU or MIG device allocated in full to
+// a claim backs vGPUs of another prepared claim, and vice versa. The
+// scheduler cannot prevent this, as vGPU slots and the devices backing them
+// are published as independent devices.
+func (s *DeviceState) checkVGpuConflicts(checkpoint *Checkpoint, claim *resourceapi.ResourceClaim) error {
+	allocatable := s.Allocatable()
+
+	gpus := sets.New[string]()
+	migDevices := sets.New[string]()
+	var parents VGpuParentDevices
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		device, exists := allocatable[result.Device]
+		if result.Driver != DriverName || !exists {
+			continue
+		}
+		switch device.Type() {
+		case GpuDeviceType:
+			gpus.Insert(device.Gpu.UUID)
+		case MigDeviceType:
+			migDevices.Insert(device.Mig.UUID)
+		case NvlinkGroupDeviceType:
+			gpus.Insert(device.NvlinkGroup.UUIDs()...)
+		case VGpuDeviceType:
+			parents = append(parents, device.VGPU)
+		}
+	}
+	gpuParents := sets.New(parents.GpuUUIDs()...)
+	migParents := sets.New(parents.MigDeviceUUIDs()...)
+
+	for uid, devices := range checkpoint.V2.PreparedClaims {
+		if uid == string(claim.UID) {
+			continue
+		}
+		otherGpus := sets.New[string]()
+		otherMigDevices := sets.New[string]()
+		otherGpuParents := sets.New[string]()
+		otherMigParents := sets.New[string]()
+		for _, group := range devices {
+			otherGpus.Insert(group.Devices.GpuUUIDs()...)
+			otherMigDevices.Insert(group.Devices.MigDeviceUUIDs()...)
+			otherGpuParents.Insert(group.Devices.VGpuParents().GpuUUIDs()...)
+			otherMigParents.Insert(group.Devices.VGpuParents().MigDeviceUUIDs()...)
+		}
+		if inUse := gpus.Intersection(otherGpuParents); inUse.Len() > 0 {
+			return fmt.Errorf("GPUs %v back vGPUs in use by claim %v", sets.List(inUse), uid)
+		}
+		if inUse := gpuParents.Intersection(otherGpus); inUse.Len() > 0 {
+			return fmt.Errorf("GPUs %v backing vGPUs are in use by claim %v", sets.List(inUse), uid)
+		}
+		if inUse := migDevices.Intersection(otherMigParents); inUse.Len() > 0 {
+			return fmt.Errorf("MIG devices %v back vGPUs in use by claim %v", sets.List(inUse), uid)
+		}
+		if inUse := migParents.Intersection(otherMigDevices); inUse.Len() > 0 {
+			return fmt.Errorf("MIG devices %v backing vGPUs are in use by claim %v", sets.List(inUse), uid)
+		}
+	}
+	return nil
+}
+
+// checkVGpuSharing ensures that the sharing settings of the vGPUs of a claim
+// can be applied alongside those of other prepared claims with vGPUs on the
+// same parent devices. Time-slicing can be shared if the intervals match. MPS
+// cannot be shared at all, as it puts the parent devices into exclusive
+// process mode for the control daemon of a single claim.
+func (s *DeviceState) checkVGpuSharing(claimUID string, sharing *configapi.GpuSharing, parents VGpuParentDevices) error {
+	checkpoint, err := s.getCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	uuids := sets.New(parents.UUIDs()...)
+	for uid, devices := range checkpoint.V2.PreparedClaims {
+		if uid == claimUID {
+			continue
+		}
+		for _, group := range devices {
+			shared := uuids.Intersection(sets.New(group.Devices.VGpuParents().UUIDs()...))
+			if shared.Len() == 0 {
+				continue
+			}
+			otherSharing, err := group.ConfigState.vgpuSharing()
+			if err != nil {
+				return fmt.Errorf("error getting sharing settings of vGPUs of claim %v: %w", uid, err)
+			}
+			if err := checkVGpuSharingCompatible(sharing, otherSharing); err != nil {
+				return fmt.Errorf("devices %v backing vGPUs are shared with claim %v: %w", sets.List(shared), uid, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkVGpuSharingCompatible(sharing, other *configapi.GpuSharing) error {
+	if sharing.IsMps() || other.IsMps() {
+		return fmt.Errorf("MPS cannot be shared between vGPU claims")
+	}
+	if !sharing.IsTimeSlicing() || !other.IsTimeSlicing() {
+		return nil
+	}
+	interval, err := timeSliceInterval(sharing)
+	if err != nil {
+		return err
+	}
+	otherInterval, err := timeSliceInterval(other)
+	if err != nil {
+		return err
+	}
+	if interval != otherInterval {
+		return fmt.Errorf("time-slice interval %v differs from %v", interval, otherInterval)
+	}
+	return nil
+}
+
+func timeSliceInterval(sharing *configapi.GpuSharing) (configapi.TimeSliceInterval, error) {
+	tsc, err := sharing.GetTimeSlicingConfig()
+	if err != nil {
+		return "", err
+	}
+	if tsc == nil {
+		return configapi.DefaultTimeSlice, nil
+	}
+	return ptr.Deref(tsc.Interval, configapi.DefaultTimeSlice), nil
+}
+
+// restoreGpuSettings restores the snapshotted settings of the given full GPUs
+// of a claim that no other prepared claim uses, and returns their UUIDs. GPUs
+// without a snapshot are reset to the defaults if resetUnknown is set, as
+// releases that did not snapshot settings always did.
+func (s *DeviceState) restoreGpuSettings(checkpoint *Checkpoint, claimUID string, uuids []string, resetUnknown bool) ([]string, error) {
+	inUse := sets.New[string]()
+	for uid, devices := range checkpoint.V2.PreparedClaims {
+		if uid != claimUID {
+			inUse.Insert(devices.FullGpuUUIDs()...)
+		}
+	}
+
+	settings := make(map[string]*GpuSettings)
+	for _, uuid := range uuids {
+		if inUse.Has(uuid) {
+			continue
+		}
+		if snapshot := checkpoint.V2.GpuSettingsSnapshots[uuid]; snapshot != nil {
+			settings[uuid] = snapshot
+			continue
+		}
+		if resetUnknown {
+			settings[uuid] = &GpuSettings{ComputeMode: "DEFAULT"}
+		}
+	}
+
+	if err := s.tsManager.RestoreGpuSettings(settings); err != nil {
+		return nil, fmt.Errorf("error restoring GPU settings: %w", err)
+	}
+	return slices.Sorted(maps.Keys(settings)), nil
+}
+
+// createMigDevices returns the devices allocated to a claim, with any MIG
+// devices that are only created on demand replaced by newly created ones.
+func (s *DeviceState) createMigDevices(claim *resourceapi.ResourceClaim) (AllocatableDevices, error) {
+	allocatable := make(AllocatableDevices)
+	for _, result := range claim.Status.Allocation.Devices.Results {
+		device, exists := s.allocatable[result.Device]
+		if !exists {
+			s.deleteMigDevices(allocatable)
+			return nil, fmt.Errorf("requested device is not allocatable: %v", result.Device)
+		}
+		if device.Type() == MigDeviceType && device.Mig.IsDynamic() {
+			mig, err := s.nvdevlib.createMigDevice(device.Mig)
+			if err != nil {
+				s.deleteMigDevices(allocatable)
+				return nil, fmt.Errorf("error creating MIG device %v: %w", result.Device, err)
+			}
+			klog.Infof("Created MIG device %v with UUID %v for claim %v", result.Device, mig.UUID, claim.UID)
+			device = &AllocatableDevice{Mig: mig}
+		}
+		allocatable[result.Device] = device
+	}
+	return allocatable, nil
+}
+
+// deleteMigDevices destroys the MIG devices created on demand among a set of
+// devices allocated to a claim whose preparation failed.
+func (s *DeviceState) deleteMigDevices(allocatable AllocatableDevices) {
+	for name, device := range allocatable {
+		if device.Type() != MigDeviceType {
+			continue
+		}
+		instance := device.Mig.Instance()
+		if instance == nil {
+			continue
+		}
+		if err := s.nvdevlib.deleteMigDevice(instance); err != nil {
+			klog.Warningf("Error deleting MIG device %v: %v", name, err)
+		}
+	}
+}
+
+// deleteUnknownMigDevices destroys all MIG devices on the GPUs managed with
+// dynamic MIG that are not recorded as prepared in the checkpoint. These are
+// left behind if the plugin is interrupted between creating a MIG device and
+// recording it in the checkpoint.
+func (s *DeviceState) deleteUnknownMigDevices() error {
+	checkpoint, err := s.getCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	known := make(map[string]sets.Set[int])
+	for _, devices := range checkpoint.V2.PreparedClaims {
+		for _, group := range devices {
+			for _, device := range group.Devices.MigDevices() {
+				instance := device.Mig.Instance
+				if instance == nil {
+					continue
+				}
+				if known[instance.ParentUUID] == nil {
+					known[instance.ParentUUID] = sets.New[int]()
+				}
+				known[instance.ParentUUID].Insert(instance.GpuInstanceID)
+			}
+		}
+	}
+
+	gpus := make(map[string]*GpuInfo)
+	for _, device := range s.allocatable {
+		if device.Type() == MigDeviceType && device.Mig.IsDynamic() {
+			gpus[device.Mig.parent.UUID] = device.Mig.parent
+		}
+	}
+	for uuid, gpu := range gpus {
+		if err := s.nvdevlib.deleteUnknownMigDevices(gpu, known[uuid]); err != nil {
+			return fmt.Errorf("error deleting unknown MIG devices on GPU %v: %w", uuid, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *DeviceState) applyConfig(ctx context.Context, config configapi.Interface, claim *resourceapi.ResourceClaim, allocatable AllocatableDevices, results []*resourceapi.DeviceRequestAllocationResult) (*DeviceConfigState, error) {
 	switch castConfig := config.(type) {
 	case *configapi.GpuConfig:
-		return s.applySharingConfig(ctx, castConfig.Sharing, claim, results)
+		configState, err := s.applySharingConfig(ctx, castConfig.Sharing, claim, allocatable, results)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.applyPowerAndClocksConfig(castConfig, claim, allocatable, results); err != nil {
+			return nil, err
+		}
+		return configState, nil
 	case *configapi.MigDeviceConfig:
-		return s.applySharingConfig(ctx, castConfig.Sharing, claim, results)
+		return s.applySharingConfig(ctx, castConfig.Sharing, claim, allocatable, results)
 	case *configapi.ImexChannelConfig:
-		return s.applyImexChannelConfig(ctx, castConfig, claim, results)
+		return s.applyImexChannelConfig(ctx, castConfig, claim, allocatable, results)
+	case *configapi.VGpuConfig:
+		return s.applyVGpuConfig(ctx, castConfig, claim, allocatable, results)
 	default:
 		return nil, fmt.Errorf("unknown config type: %T", castConfig)
 	}
 }
 
-func (s *DeviceState) applySharingConfig(ctx context.Context, config configapi.Sharing, claim *resourceapi.ResourceClaim, results []*resourceapi.DeviceRequestAllocationResult) (*DeviceConfigState, error) {
+func (s *DeviceState) applySharingConfig(ctx context.Context, config configapi.Sharing, claim *resourceapi.ResourceClaim, allocatable AllocatableDevices, results []*resourceapi.DeviceRequestAllocationResult) (*DeviceConfigState, error) {
 	// Get the list of claim requests this config is being applied over.
 	var requests []string
 	for _, r := range results {
@@ -387,20 +1109,49 @@ func (s *DeviceState) applySharingConfig(ctx context.Context, config configapi.S
 	// Get the list of allocatable devices this config is being applied over.
 	allocatableDevices := make(AllocatableDevices)
 	for _, r := range results {
-		allocatableDevices[r.Device] = s.allocatable[r.Device]
+		allocatableDevices[r.Device] = allocatable[r.Device]
+	}
+
+	// Sharing settings for vGPUs are applied to the devices backing them.
+	var devices UUIDProvider = allocatableDevices
+	if parents := allocatableDevices.VGpuParents(); len(parents) > 0 {
+		devices = parents
 	}
 
 	// Declare a device group state object to populate.
 	var configState DeviceConfigState
 
+	// Record the settings of the GPUs before they are changed.
+	if config.IsTimeSlicing() || config.IsMps() {
+		settings, err := s.tsManager.GetGpuSettings(devices.GpuUUIDs())
+		if err != nil {
+			return nil, fmt.Errorf("error getting current settings of GPUs for requests '%v' in claim '%v': %w", requests, claim.UID, err)
+		}
+		configState.PreviousGpuSettings = settings
+
+		// Persist the settings of GPUs not yet used by any claim, so that
+		// they can be restored once the last claim using them is gone.
+		err = s.updateCheckpoint(func(checkpoint *Checkpoint) {
+			for uuid, gpuSettings := range settings {
+				if checkpoint.V2.GpuSettingsSnapshots[uuid] == nil {
+					checkpoint.V2.GpuSettingsSnapshots[uuid] = gpuSettings
+				}
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error recording settings of GPUs for requests '%v' in claim '%v': %w", requests, claim.UID, err)
+		}
+	}
+
 	// Apply time-slicing settings (if available).
 	if config.IsTimeSlicing() {
+		configState.SharingStrategy = configapi.TimeSlicingStrategy
 		tsc, err := config.GetTimeSlicingConfig()
 		if err != nil {
 			return nil, fmt.Errorf("error getting timeslice config for requests '%v' in claim '%v': %w", requests, claim.UID, err)
 		}
 		if tsc != nil {
-			err = s.tsManager.SetTimeSlice(allocatableDevices, tsc)
+			err = s.tsManager.SetTimeSlice(devices, tsc)
 			if err != nil {
 				return nil, fmt.Errorf("error setting timeslice config for requests '%v' in claim '%v': %w", requests, claim.UID, err)
 			}
@@ -409,11 +1160,12 @@ func (s *DeviceState) applySharingConfig(ctx context.Context, config configapi.S
 
 	// Apply MPS settings.
 	if config.IsMps() {
+		configState.SharingStrategy = configapi.MpsStrategy
 		mpsc, err := config.GetMpsConfig()
 		if err != nil {
 			return nil, fmt.Errorf("error getting MPS configuration: %w", err)
 		}
-		mpsControlDaemon := s.mpsManager.NewMpsControlDaemon(string(claim.UID), allocatableDevices)
+		mpsControlDaemon := s.mpsManager.NewMpsControlDaemon(string(claim.UID), devices)
 		if err := mpsControlDaemon.Start(ctx, mpsc); err != nil {
 			return nil, fmt.Errorf("error starting MPS control daemon: %w", err)
 		}
@@ -421,23 +1173,126 @@ func (s *DeviceState) applySharingConfig(ctx context.Context, config configapi.S
 			return nil, fmt.Errorf("MPS control daemon is not yet ready: %w", err)
 		}
 		configState.MpsControlDaemonID = mpsControlDaemon.GetID()
-		configState.containerEdits = mpsControlDaemon.GetCDIContainerEdits()
+		configState.ContainerEdits = mpsControlDaemon.GetCDIContainerEdits()
+		configState.RequestContainerEdits, err = getMpsClientContainerEdits(mpsc, results)
+		if err != nil {
+			return nil, fmt.Errorf("error getting MPS client settings for requests '%v' in claim '%v': %w", requests, claim.UID, err)
+		}
 	}
 
 	return &configState, nil
 }
 
-func (s *DeviceState) applyImexChannelConfig(ctx context.Context, config *configapi.ImexChannelConfig, claim *resourceapi.ResourceClaim, results []*resourceapi.DeviceRequestAllocationResult) (*DeviceConfigState, error) {
+// applyPowerAndClocksConfig applies the power limit and clocks of a GPU
+// config. The previous settings are recorded by applySharingConfig, which
+// always runs first, so that they can be restored once the last claim using
+// the GPUs is gone.
+func (s *DeviceState) applyPowerAndClocksConfig(config *configapi.GpuConfig, claim *resourceapi.ResourceClaim, allocatable AllocatableDevices, results []*resourceapi.DeviceRequestAllocationResult) error {
+	if config.PowerLimit == nil && config.LockedGraphicsClocks == nil && config.ApplicationClocks == nil {
+		return nil
+	}
+
+	var requests []string
+	allocatableDevices := make(AllocatableDevices)
+	for _, r := range results {
+		requests = append(requests, r.Request)
+		allocatableDevices[r.Device] = allocatable[r.Device]
+	}
+	uuids := allocatableDevices.GpuUUIDs()
+
+	// Validate against all GPUs before changing any of them.
+	for _, uuid := range uuids {
+		supported, err := s.nvdevlib.getSupportedGpuSettings(uuid)
+		if err != nil {
+			return fmt.Errorf("error getting supported settings of GPU %v for requests '%v' in claim '%v': %w", uuid, requests, claim.UID, err)
+		}
+		if err := config.ValidateSupported(supported); err != nil {
+			return fmt.Errorf("unsupported GPU config for GPU %v for requests '%v' in claim '%v': %w", uuid, requests, claim.UID, err)
+		}
+	}
+
+	if config.LockedGraphicsClocks != nil {
+		err := s.updateCheckpoint(func(checkpoint *Checkpoint) {
+			for _, uuid := range uuids {
+				if snapshot := checkpoint.V2.GpuSettingsSnapshots[uuid]; snapshot != nil {
+					snapshot.ResetLockedClocks = true
+				}
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("error recording locked clocks of GPUs for requests '%v' in claim '%v': %w", requests, claim.UID, err)
+		}
+	}
+
+	for _, uuid := range uuids {
+		if err := s.nvdevlib.setPowerAndClocks(uuid, config); err != nil {
+			return fmt.Errorf("error setting power limit and clocks for requests '%v' in claim '%v': %w", requests, claim.UID, err)
+		}
+	}
+	return nil
+}
+
+func (s *DeviceState) applyImexChannelConfig(ctx context.Context, config *configapi.ImexChannelConfig, claim *resourceapi.ResourceClaim, allocatable AllocatableDevices, results []*resourceapi.DeviceRequestAllocationResult) (*DeviceConfigState, error) {
 	// Declare a device group state object to populate.
 	var configState DeviceConfigState
 
 	// Create any necessary IMEX channels and gather their CDI container edits.
 	for _, r := range results {
-		imexChannel := s.allocatable[r.Device].ImexChannel
+		imexChannel := allocatable[r.Device].ImexChannel
 		if err := s.nvdevlib.createImexChannelDevice(imexChannel.Channel); err != nil {
 			return nil, fmt.Errorf("error creating IMEX channel device: %w", err)
 		}
-		configState.containerEdits = configState.containerEdits.Append(s.cdi.GetImexChannelContainerEdits(imexChannel))
+		configState.ContainerEdits = configState.ContainerEdits.Append(s.cdi.GetImexChannelContainerEdits(imexChannel))
+	}
+
+	return &configState, nil
+}
+
+func (s *DeviceState) applyVGpuConfig(ctx context.Context, config *configapi.VGpuConfig, claim *resourceapi.ResourceClaim, allocatable AllocatableDevices, results []*resourceapi.DeviceRequestAllocationResult) (*DeviceConfigState, error) {
+	// Sharing settings are applied to the parent devices as a whole, so they
+	// must agree with those of other claims with vGPUs on the same parents.
+	parents := make(AllocatableDevices)
+	for _, r := range results {
+		parents[r.Device] = allocatable[r.Device]
+	}
+	if err := s.checkVGpuSharing(string(claim.UID), config.Sharing, parents.VGpuParents()); err != nil {
+		return nil, err
+	}
+
+	// Declare a device group state object to populate.
+	configState := DeviceConfigState{
+		VGpuLimits: make(map[string]*VGpuLimits),
+	}
+
+	// Resolve the limits for each vGPU, commit them against the capacity of
+	// its parent GPU, and gather the CDI container edits that enforce them.
+	var vgpus []*VGpuInfo
+	var limits []*VGpuLimits
+	for _, r := range results {
+		vgpu := allocatable[r.Device].VGPU
+		configState.VGpuLimits[r.Device] = s.vgpuManager.GetLimits(vgpu, config)
+		if err := s.vgpuLedger.Reserve(string(claim.UID), vgpu, configState.VGpuLimits[r.Device]); err != nil {
+			return nil, fmt.Errorf("error admitting vGPU %v: %w", r.Device, err)
+		}
+		vgpus = append(vgpus, vgpu)
+		limits = append(limits, configState.VGpuLimits[r.Device])
+	}
+	if err := s.vgpuManager.CreateClaimDir(string(claim.UID)); err != nil {
+		return nil, fmt.Errorf("error creating vGPU claim directory: %w", err)
+	}
+	configState.ContainerEdits = s.vgpuManager.GetCDIContainerEdits(string(claim.UID), vgpus, limits)
+
+	// Apply any sharing settings to the physical GPUs backing the vGPUs.
+	if config.Sharing != nil {
+		sharingState, err := s.applySharingConfig(ctx, config.Sharing, claim, allocatable, results)
+		if err != nil {
+			return nil, err
+		}
+		configState.MpsControlDaemonID = sharingState.MpsControlDaemonID
+		configState.SharingStrategy = sharingState.SharingStrategy
+		configState.PreviousGpuSettings = sharingState.PreviousGpuSettings
+		configState.ContainerEdits = configState.ContainerEdits.Append(sharingState.ContainerEdits)
+		configState.RequestContainerEdits = sharingState.RequestContainerEdits
 	}
 
 	return &configState, nil
@@ -508,51 +1363,3 @@ func GetOpaqueDeviceConfigs(
 
 	return resultConfigs, nil
 }
-
-// TODO: Dynamic MIG is not yet supported with structured parameters.
-// Refactor this to allow for the allocation of statically partitioned MIG
-// devices.
-//
-// func (s *DeviceState) prepareMigDevices(claimUID string, allocated *nascrd.AllocatedMigDevices) (*PreparedMigDevices, error) {
-// 	prepared := &PreparedMigDevices{}
-//
-// 	for _, device := range allocated.Devices {
-// 		if _, exists := s.allocatable[device.ParentUUID]; !exists {
-// 			return nil, fmt.Errorf("allocated GPU does not exist: %v", device.ParentUUID)
-// 		}
-//
-// 		parent := s.allocatable[device.ParentUUID]
-//
-// 		if !parent.migEnabled {
-// 			return nil, fmt.Errorf("cannot prepare a GPU with MIG mode disabled: %v", device.ParentUUID)
-// 		}
-//
-// 		if _, exists := parent.migProfiles[device.Profile]; !exists {
-// 			return nil, fmt.Errorf("MIG profile %v does not exist on GPU: %v", device.Profile, device.ParentUUID)
-// 		}
-//
-// 		placement := nvml.GpuInstancePlacement{
-// 			Start: uint32(device.Placement.Start),
-// 			Size:  uint32(device.Placement.Size),
-// 		}
-//
-// 		migInfo, err := s.nvdevlib.createMigDevice(parent.GpuInfo, parent.migProfiles[device.Profile].profile, &placement)
-// 		if err != nil {
-// 			return nil, fmt.Errorf("error creating MIG device: %w", err)
-// 		}
-//
-// 		prepared.Devices = append(prepared.Devices, migInfo)
-// 	}
-//
-// 	return prepared, nil
-// }
-//
-// func (s *DeviceState) unprepareMigDevices(claimUID string, devices *PreparedDevices) error {
-// 	for _, device := range devices.Mig.Devices {
-// 		err := s.nvdevlib.deleteMigDevice(device)
-// 		if err != nil {
-// 			return fmt.Errorf("error deleting MIG device for %v: %w", device.uuid, err)
-// 		}
-// 	}
-// 	return nil
-//}
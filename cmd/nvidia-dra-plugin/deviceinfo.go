@@ -18,6 +18,7 @@ package main
 
 import (
 	"fmt"
+	"maps"
 
 	"github.com/Masterminds/semver"
 	nvdev "github.com/NVIDIA/go-nvlib/pkg/nvlib/device"
@@ -33,6 +34,8 @@ type GpuInfo struct {
 	minor                 int
 	migEnabled            bool
 	memoryBytes           uint64
+	pciBusID              string
+	pciTopology           *PciTopology
 	productName           string
 	brand                 string
 	architecture          string
@@ -52,6 +55,19 @@ type MigDeviceInfo struct {
 	giInfo        *nvml.GpuInstanceInfo
 	ciProfileInfo *nvml.ComputeInstanceProfileInfo
 	ciInfo        *nvml.ComputeInstanceInfo
+	profileInfo   *MigProfileInfo
+	dynamic       bool
+}
+
+// MigInstance identifies the GPU and compute instance of a MIG device that
+// was created on demand for a claim.
+type MigInstance struct {
+	ParentUUID        string `json:"parentUUID"`
+	Profile           string `json:"profile"`
+	PlacementStart    uint32 `json:"placementStart"`
+	PlacementSize     uint32 `json:"placementSize"`
+	GpuInstanceID     int    `json:"gpuInstanceID"`
+	ComputeInstanceID int    `json:"computeInstanceID"`
 }
 
 type MigProfileInfo struct {
@@ -76,7 +92,7 @@ func (d *GpuInfo) CanonicalName() string {
 }
 
 func (d *MigDeviceInfo) CanonicalName() string {
-	return fmt.Sprintf("gpu-%d-mig-%d-%d-%d", d.parent.index, d.giInfo.ProfileId, d.placement.Start, d.placement.Size)
+	return fmt.Sprintf("gpu-%d-mig-%d-%d-%d", d.parent.index, d.giProfileInfo.Id, d.placement.Start, d.placement.Size)
 }
 
 func (d *ImexChannelInfo) CanonicalName() string {
@@ -91,6 +107,27 @@ func (d *MigDeviceInfo) CanonicalIndex() string {
 	return fmt.Sprintf("%d:%d", d.parent.index, d.index)
 }
 
+// IsDynamic reports whether the MIG device is only created on demand when a
+// claim it is allocated to is prepared.
+func (d *MigDeviceInfo) IsDynamic() bool {
+	return d.dynamic
+}
+
+// Instance returns the record needed to destroy a MIG device created on demand.
+func (d *MigDeviceInfo) Instance() *MigInstance {
+	if !d.dynamic || d.giInfo == nil || d.ciInfo == nil {
+		return nil
+	}
+	return &MigInstance{
+		ParentUUID:        d.parent.UUID,
+		Profile:           d.profile,
+		PlacementStart:    d.placement.Start,
+		PlacementSize:     d.placement.Size,
+		GpuInstanceID:     int(d.giInfo.Id),
+		ComputeInstanceID: int(d.ciInfo.Id),
+	}
+}
+
 func (d *ImexChannelInfo) CanonicalIndex() string {
 	return fmt.Sprintf("%d", d.Channel)
 }
@@ -138,9 +175,39 @@ func (d *GpuInfo) GetDevice() resourceapi.Device {
 			},
 		},
 	}
+	maps.Copy(device.Basic.Attributes, d.topologyAttributes())
 	return device
 }
 
+// topologyAttributes returns the attributes describing where a GPU sits in
+// the PCIe hierarchy, which its MIG devices share. Attributes not reported
+// by the platform are omitted. Only the name of the nearest NIC is included,
+// as MIG devices with all memory slices are at the limit of attributes and
+// capacities per device.
+func (d *GpuInfo) topologyAttributes() map[resourceapi.QualifiedName]resourceapi.DeviceAttribute {
+	attributes := make(map[resourceapi.QualifiedName]resourceapi.DeviceAttribute)
+	if d.pciBusID != "" {
+		attributes["pciBusID"] = resourceapi.DeviceAttribute{StringValue: &d.pciBusID}
+	}
+	t := d.pciTopology
+	if t == nil {
+		return attributes
+	}
+	if t.numaNode >= 0 {
+		attributes["numaNode"] = resourceapi.DeviceAttribute{IntValue: ptr.To(int64(t.numaNode))}
+	}
+	if t.pcieRoot != "" {
+		attributes["pcieRoot"] = resourceapi.DeviceAttribute{StringValue: &t.pcieRoot}
+	}
+	if t.cpuAffinity != "" {
+		attributes["cpuAffinity"] = resourceapi.DeviceAttribute{StringValue: &t.cpuAffinity}
+	}
+	if t.nearestNic != "" {
+		attributes["nearestNic"] = resourceapi.DeviceAttribute{StringValue: &t.nearestNic}
+	}
+	return attributes
+}
+
 func (d *MigDeviceInfo) GetDevice() resourceapi.Device {
 	device := resourceapi.Device{
 		Name: d.CanonicalName(),
@@ -149,15 +216,9 @@ func (d *MigDeviceInfo) GetDevice() resourceapi.Device {
 				"type": {
 					StringValue: ptr.To(MigDeviceType),
 				},
-				"uuid": {
-					StringValue: &d.UUID,
-				},
 				"parentUUID": {
 					StringValue: &d.parent.UUID,
 				},
-				"index": {
-					IntValue: ptr.To(int64(d.index)),
-				},
 				"parentIndex": {
 					IntValue: ptr.To(int64(d.parent.index)),
 				},
@@ -196,12 +257,22 @@ func (d *MigDeviceInfo) GetDevice() resourceapi.Device {
 			},
 		},
 	}
+	// MIG devices created on demand have no UUID or index until they exist.
+	if d.UUID != "" {
+		device.Basic.Attributes["uuid"] = resourceapi.DeviceAttribute{
+			StringValue: &d.UUID,
+		}
+		device.Basic.Attributes["index"] = resourceapi.DeviceAttribute{
+			IntValue: ptr.To(int64(d.index)),
+		}
+	}
 	for i := d.placement.Start; i < d.placement.Start+d.placement.Size; i++ {
 		capacity := resourceapi.QualifiedName(fmt.Sprintf("memorySlice%d", i))
 		device.Basic.Capacity[capacity] = resourceapi.DeviceCapacity{
 			Value: *resource.NewQuantity(1, resource.BinarySI),
 		}
 	}
+	maps.Copy(device.Basic.Attributes, d.parent.topologyAttributes())
 	return device
 }
 
@@ -23,15 +23,19 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	coreclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/dynamic-resource-allocation/kubeletplugin"
 	"k8s.io/klog/v2"
 	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
 )
 
+// MaxConcurrentClaims bounds the number of claims of a single request that
+// are prepared or unprepared in parallel.
+const MaxConcurrentClaims = 8
+
 var _ drapbv1.DRAPluginServer = &driver{}
 
 type driver struct {
-	sync.Mutex
 	client coreclientset.Interface
 	plugin kubeletplugin.DRAPlugin
 	state  *DeviceState
@@ -48,6 +52,12 @@ func NewDriver(ctx context.Context, config *Config) (*driver, error) {
 	}
 	driver.state = state
 
+	gc, err := NewGarbageCollector(config, state)
+	if err != nil {
+		return nil, err
+	}
+	go gc.Run(ctx)
+
 	plugin, err := kubeletplugin.Start(
 		ctx,
 		[]any{driver},
@@ -62,27 +72,47 @@ func NewDriver(ctx context.Context, config *Config) (*driver, error) {
 	}
 	driver.plugin = plugin
 
-	// If not responsible for advertising GPUs or MIG devices, we are done
-	if !(config.flags.deviceClasses.Has(GpuDeviceType) || config.flags.deviceClasses.Has(MigDeviceType)) {
+	// If not responsible for advertising GPUs, MIG devices, vGPUs or NVLink groups, we are done
+	if !(config.flags.deviceClasses.Has(GpuDeviceType) || config.flags.deviceClasses.Has(MigDeviceType) || config.flags.deviceClasses.Has(VGpuDeviceType) || config.flags.deviceClasses.Has(NvlinkGroupDeviceType)) {
 		return driver, nil
 	}
 
-	// Otherwise, enumerate the set of GPU and MIG devices and publish them
+	// Otherwise, enumerate the set of GPU, MIG and vGPU devices and publish them
+	if err := driver.publishResources(ctx); err != nil {
+		return nil, err
+	}
+
+	rediscoverer := NewDeviceRediscoverer(config, state, driver.publishResources)
+	go rediscoverer.Run(ctx)
+
+	if config.flags.healthChecks {
+		health := NewHealthMonitor(config, state, func(ctx context.Context) {
+			if err := driver.publishResources(ctx); err != nil {
+				klog.Errorf("Error withdrawing unhealthy devices: %v", err)
+			}
+		})
+		go health.Run(ctx)
+	}
+
+	return driver, nil
+}
+
+// publishResources publishes all healthy GPU, MIG and vGPU devices.
+func (d *driver) publishResources(ctx context.Context) error {
 	var resources kubeletplugin.Resources
-	for _, device := range state.allocatable {
+	for name, device := range d.state.Allocatable() {
 		// Explicitly exclude IMEX channels from being advertised here. They
 		// are instead advertised in as a network resource from the control plane.
 		if device.Type() == ImexChannelType {
 			continue
 		}
+		if !d.state.IsHealthy(name) {
+			continue
+		}
 		resources.Devices = append(resources.Devices, device.GetDevice())
 	}
 
-	if err := plugin.PublishResources(ctx, resources); err != nil {
-		return nil, err
-	}
-
-	return driver, nil
+	return d.plugin.PublishResources(ctx, resources)
 }
 
 func (d *driver) Shutdown() error {
@@ -97,9 +127,16 @@ func (d *driver) NodePrepareResources(ctx context.Context, req *drapbv1.NodePrep
 	klog.Infof("NodePrepareResource is called: number of claims: %d", len(req.Claims))
 	preparedResources := &drapbv1.NodePrepareResourcesResponse{Claims: map[string]*drapbv1.NodePrepareResourceResponse{}}
 
-	for _, claim := range req.Claims {
-		preparedResources.Claims[claim.UID] = d.nodePrepareResource(ctx, claim)
-	}
+	// Every claim must get a response, so the claims are not skipped once
+	// ctx is cancelled. They are handed ctx and fail individually instead.
+	var mutex sync.Mutex
+	workqueue.ParallelizeUntil(context.WithoutCancel(ctx), MaxConcurrentClaims, len(req.Claims), func(i int) {
+		claim := req.Claims[i]
+		response := d.nodePrepareResource(ctx, claim)
+		mutex.Lock()
+		defer mutex.Unlock()
+		preparedResources.Claims[claim.UID] = response
+	})
 
 	return preparedResources, nil
 }
@@ -108,17 +145,20 @@ func (d *driver) NodeUnprepareResources(ctx context.Context, req *drapbv1.NodeUn
 	klog.Infof("NodeUnprepareResource is called: number of claims: %d", len(req.Claims))
 	unpreparedResources := &drapbv1.NodeUnprepareResourcesResponse{Claims: map[string]*drapbv1.NodeUnprepareResourceResponse{}}
 
-	for _, claim := range req.Claims {
-		unpreparedResources.Claims[claim.UID] = d.nodeUnprepareResource(ctx, claim)
-	}
+	// As for NodePrepareResources, every claim must get a response.
+	var mutex sync.Mutex
+	workqueue.ParallelizeUntil(context.WithoutCancel(ctx), MaxConcurrentClaims, len(req.Claims), func(i int) {
+		claim := req.Claims[i]
+		response := d.nodeUnprepareResource(ctx, claim)
+		mutex.Lock()
+		defer mutex.Unlock()
+		unpreparedResources.Claims[claim.UID] = response
+	})
 
 	return unpreparedResources, nil
 }
 
 func (d *driver) nodePrepareResource(ctx context.Context, claim *drapbv1.Claim) *drapbv1.NodePrepareResourceResponse {
-	d.Lock()
-	defer d.Unlock()
-
 	resourceClaim, err := d.client.ResourceV1beta1().ResourceClaims(claim.Namespace).Get(
 		ctx,
 		claim.Name,
@@ -141,28 +181,15 @@ func (d *driver) nodePrepareResource(ctx context.Context, claim *drapbv1.Claim)
 }
 
 func (d *driver) nodeUnprepareResource(ctx context.Context, claim *drapbv1.Claim) *drapbv1.NodeUnprepareResourceResponse {
-	d.Lock()
-	defer d.Unlock()
-
 	if err := d.state.Unprepare(ctx, claim.UID); err != nil {
 		return &drapbv1.NodeUnprepareResourceResponse{
 			Error: fmt.Sprintf("error unpreparing devices for claim %v: %v", claim.UID, err),
 		}
 	}
 
+	if err := d.state.UpdateClaimDeviceStatus(ctx, claim.Namespace, claim.Name, nil); err != nil {
+		klog.Warningf("Unable to clear device status of claim %v/%v: %v", claim.Namespace, claim.Name, err)
+	}
+
 	return &drapbv1.NodeUnprepareResourceResponse{}
 }
-
-// TODO: implement loop to remove CDI files from the CDI path for claimUIDs
-//       that have been removed from the AllocatedClaims map.
-// func (d *driver) cleanupCDIFiles(wg *sync.WaitGroup) chan error {
-// 	errors := make(chan error)
-// 	return errors
-// }
-//
-// TODO: implement loop to remove mpsControlDaemon folders from the mps
-//       path for claimUIDs that have been removed from the AllocatedClaims map.
-// func (d *driver) cleanupMpsControlDaemonArtifacts(wg *sync.WaitGroup) chan error {
-// 	errors := make(chan error)
-// 	return errors
-// }
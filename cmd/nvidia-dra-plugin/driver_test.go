@@ -0,0 +1,197 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	resourcev1beta1 "k8s.io/client-go/kubernetes/typed/resource/v1beta1"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+)
+
+// blockingClaimStatusClient wraps the resource client of a fake clientset so
+// that updates of a claim's status block until released. Prepare updates the
+// status while holding the locks of the claim's devices, which allows tests
+// to observe which claims are being prepared at the same time. The reactors
+// of the fake clientset cannot be used for this, as they run under a lock
+// shared by all requests.
+type blockingClaimStatusClient struct {
+	resourcev1beta1.ResourceV1beta1Interface
+	entered chan string
+	release chan struct{}
+}
+
+type blockingResourceClaims struct {
+	resourcev1beta1.ResourceClaimInterface
+	client *blockingClaimStatusClient
+}
+
+func (c *blockingClaimStatusClient) ResourceClaims(namespace string) resourcev1beta1.ResourceClaimInterface {
+	return &blockingResourceClaims{
+		ResourceClaimInterface: c.ResourceV1beta1Interface.ResourceClaims(namespace),
+		client:                 c,
+	}
+}
+
+func (c *blockingResourceClaims) UpdateStatus(ctx context.Context, claim *resourceapi.ResourceClaim, opts metav1.UpdateOptions) (*resourceapi.ResourceClaim, error) {
+	c.client.entered <- claim.Name
+	<-c.client.release
+	return c.ResourceClaimInterface.UpdateStatus(ctx, claim, opts)
+}
+
+type blockingClientset struct {
+	*fake.Clientset
+	resource *blockingClaimStatusClient
+}
+
+func (c *blockingClientset) ResourceV1beta1() resourcev1beta1.ResourceV1beta1Interface {
+	return c.resource
+}
+
+func newBlockingDriver(state *testDeviceState) (*driver, *blockingClaimStatusClient) {
+	resource := &blockingClaimStatusClient{
+		ResourceV1beta1Interface: state.client.ResourceV1beta1(),
+		entered:                  make(chan string),
+		release:                  make(chan struct{}),
+	}
+	client := &blockingClientset{
+		Clientset: state.client,
+		resource:  resource,
+	}
+	state.config.clientsets.Core = client
+	return &driver{client: client, state: state.DeviceState}, resource
+}
+
+func prepareRequest(claims ...*resourceapi.ResourceClaim) *drapbv1.NodePrepareResourcesRequest {
+	req := &drapbv1.NodePrepareResourcesRequest{}
+	for _, claim := range claims {
+		req.Claims = append(req.Claims, &drapbv1.Claim{
+			Namespace: claim.Namespace,
+			Name:      claim.Name,
+			UID:       string(claim.UID),
+		})
+	}
+	return req
+}
+
+func TestNodePrepareResourcesConcurrency(t *testing.T) {
+	const timeout = 5 * time.Second
+
+	testCases := []struct {
+		description string
+		devices     [][]string
+		concurrent  bool
+	}{
+		{
+			description: "claims on disjoint devices are prepared concurrently",
+			devices:     [][]string{{"gpu-0"}, {"gpu-1"}},
+			concurrent:  true,
+		},
+		{
+			description: "claims on overlapping devices are serialized",
+			devices:     [][]string{{"gpu-0", "gpu-1"}, {"gpu-1"}},
+			concurrent:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			state := newTestDeviceState(t, "gpus:\n- model: L4\n  count: 2\n", &Flags{})
+			var claims []*resourceapi.ResourceClaim
+			for i, devices := range tc.devices {
+				claims = append(claims, state.createClaim(t, string(rune('a'+i)), nil, devices...))
+			}
+			d, client := newBlockingDriver(state)
+
+			done := make(chan *drapbv1.NodePrepareResourcesResponse)
+			go func() {
+				resp, _ := d.NodePrepareResources(context.Background(), prepareRequest(claims...))
+				done <- resp
+			}()
+
+			select {
+			case <-client.entered:
+			case <-time.After(timeout):
+				t.Fatal("no claim is being prepared")
+			}
+			if tc.concurrent {
+				select {
+				case <-client.entered:
+				case <-time.After(timeout):
+					t.Fatal("claims are not prepared concurrently")
+				}
+				client.release <- struct{}{}
+				client.release <- struct{}{}
+			} else {
+				select {
+				case name := <-client.entered:
+					t.Fatalf("claim %v is prepared while holding locks of another claim", name)
+				case <-time.After(100 * time.Millisecond):
+				}
+				client.release <- struct{}{}
+				select {
+				case <-client.entered:
+				case <-time.After(timeout):
+					t.Fatal("second claim is not prepared")
+				}
+				client.release <- struct{}{}
+			}
+
+			resp := <-done
+			require.Len(t, resp.Claims, len(claims))
+			for _, claim := range claims {
+				require.Empty(t, resp.Claims[string(claim.UID)].Error)
+			}
+		})
+	}
+}
+
+func TestNodePrepareResourcesCancelled(t *testing.T) {
+	state := newTestDeviceState(t, "gpus:\n- model: L4\n  count: 2\n", &Flags{})
+	claims := []*resourceapi.ResourceClaim{
+		state.createClaim(t, "a", nil, "gpu-0"),
+		state.createClaim(t, "b", nil, "gpu-1"),
+	}
+	d := &driver{client: state.client, state: state.DeviceState}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	prepared, err := d.NodePrepareResources(ctx, prepareRequest(claims...))
+	require.NoError(t, err)
+	require.Len(t, prepared.Claims, len(claims))
+
+	unprepareReq := &drapbv1.NodeUnprepareResourcesRequest{}
+	for _, claim := range claims {
+		unprepareReq.Claims = append(unprepareReq.Claims, &drapbv1.Claim{
+			Namespace: claim.Namespace,
+			Name:      claim.Name,
+			UID:       string(claim.UID),
+		})
+	}
+	unprepared, err := d.NodeUnprepareResources(ctx, unprepareReq)
+	require.NoError(t, err)
+	require.Len(t, unprepared.Claims, len(claims))
+}
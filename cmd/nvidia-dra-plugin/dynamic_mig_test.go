@@ -0,0 +1,100 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const dynamicMigTopology = `
+gpus:
+- model: A100-SXM4-40GB
+  migEnabled: true
+`
+
+// gpuInstanceStarts returns the placement starts of the GPU instances that
+// exist on a mock GPU.
+func gpuInstanceStarts(device *mockDevice) []uint32 {
+	device.Lock()
+	defer device.Unlock()
+	var starts []uint32
+	for _, gi := range device.gpuInstances {
+		starts = append(starts, gi.info.Placement.Start)
+	}
+	slices.Sort(starts)
+	return starts
+}
+
+func TestPrepareDynamicMigDevice(t *testing.T) {
+	ctx := context.Background()
+	state := newTestDeviceState(t, dynamicMigTopology, &Flags{dynamicMig: true})
+	gpu := state.mock.devices[0]
+	require.Empty(t, gpuInstanceStarts(gpu))
+
+	claim := state.createClaim(t, "claim", nil, "gpu-0-mig-2-4-4")
+	_, err := state.Prepare(ctx, claim)
+	require.NoError(t, err)
+
+	// The MIG device is created at its placement and recorded along with
+	// its instance, so that it can be destroyed again.
+	require.Equal(t, []uint32{4}, gpuInstanceStarts(gpu))
+	checkpoint, err := state.getCheckpoint()
+	require.NoError(t, err)
+	require.Len(t, checkpoint.V2.PreparedClaims[string(claim.UID)].GetDevices(), 1)
+	prepared := checkpoint.V2.PreparedClaims[string(claim.UID)][0].Devices[0].Mig
+	require.NotNil(t, prepared.Instance)
+	require.Equal(t, gpu.uuid, prepared.Instance.ParentUUID)
+	require.Equal(t, uint32(4), prepared.Instance.PlacementStart)
+	require.NotEmpty(t, prepared.Info.UUID)
+
+	specs, err := filepath.Glob(filepath.Join(state.config.flags.cdiRoot, "*"+string(claim.UID)+"*"))
+	require.NoError(t, err)
+	require.Len(t, specs, 1)
+
+	require.NoError(t, state.Unprepare(ctx, string(claim.UID)))
+	require.Empty(t, gpuInstanceStarts(gpu))
+}
+
+func TestDeleteUnknownMigDevices(t *testing.T) {
+	ctx := context.Background()
+	state := newTestDeviceState(t, dynamicMigTopology, &Flags{dynamicMig: true})
+	gpu := state.mock.devices[0]
+
+	claim := state.createClaim(t, "claim", nil, "gpu-0-mig-2-4-4")
+	_, err := state.Prepare(ctx, claim)
+	require.NoError(t, err)
+
+	// A MIG device created by a plugin that stopped before recording it in
+	// the checkpoint.
+	_, err = state.nvdevlib.createMigDevice(state.allocatable["gpu-0-mig-2-0-4"].Mig)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{0, 4}, gpuInstanceStarts(gpu))
+
+	require.NoError(t, state.deleteUnknownMigDevices())
+	require.Equal(t, []uint32{4}, gpuInstanceStarts(gpu))
+
+	// The MIG device of the prepared claim is still usable.
+	require.NoError(t, state.Unprepare(ctx, string(claim.UID)))
+	require.Empty(t, gpuInstanceStarts(gpu))
+}
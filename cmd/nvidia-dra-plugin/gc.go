@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultGarbageCollectionInterval = 5 * time.Minute
+
+	gcKindCDIClaimSpec  = "cdi_claim_spec"
+	gcKindMpsDeployment = "mps_deployment"
+	gcKindMpsProcess    = "mps_process"
+	gcKindMpsRootDir    = "mps_root_dir"
+	gcKindVGpuClaimDir  = "vgpu_claim_dir"
+)
+
+// GarbageCollector periodically removes claim CDI spec files, MPS control
+// daemon artifacts and vGPU claim directories that are not referenced by any
+// prepared claim. These are left behind when preparing a claim fails part way
+// through, or when the plugin is interrupted while unpreparing one.
+type GarbageCollector struct {
+	state    *DeviceState
+	interval time.Duration
+	removals *prometheus.CounterVec
+}
+
+func NewGarbageCollector(config *Config, state *DeviceState) (*GarbageCollector, error) {
+	removals := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "nvidia_dra_gc_removals_total",
+			Help: "Number of stale artifacts removed by the garbage collector.",
+		},
+		[]string{"kind"},
+	)
+	if err := config.registry.Register(removals); err != nil {
+		return nil, fmt.Errorf("unable to register garbage collection metrics: %w", err)
+	}
+
+	gc := &GarbageCollector{
+		state:    state,
+		interval: config.flags.gcInterval,
+		removals: removals,
+	}
+	return gc, nil
+}
+
+// Run collects garbage at the configured interval until the context is done.
+func (gc *GarbageCollector) Run(ctx context.Context) {
+	if gc.interval <= 0 {
+		return
+	}
+	wait.UntilWithContext(ctx, gc.collect, gc.interval)
+}
+
+func (gc *GarbageCollector) collect(ctx context.Context) {
+	// Block claims from being prepared or unprepared while collecting, so
+	// that the artifacts of claims in flux are not mistaken for garbage.
+	gc.state.prepareLock.Lock()
+	defer gc.state.prepareLock.Unlock()
+
+	checkpoint, err := gc.state.getCheckpoint()
+	if err != nil {
+		klog.Warningf("Skipping garbage collection: %v", err)
+		return
+	}
+
+	claimUIDs := sets.New[string]()
+	mpsControlDaemonIDs := sets.New[string]()
+	for claimUID, devices := range checkpoint.V2.PreparedClaims {
+		claimUIDs.Insert(claimUID)
+		for _, group := range devices {
+			if group.ConfigState.MpsControlDaemonID != "" {
+				mpsControlDaemonIDs.Insert(group.ConfigState.MpsControlDaemonID)
+			}
+		}
+	}
+
+	if err := gc.cleanupCDIFiles(claimUIDs); err != nil {
+		klog.Warningf("Error cleaning up CDI claim spec files: %v", err)
+	}
+	if err := gc.cleanupMpsControlDaemonArtifacts(ctx, mpsControlDaemonIDs); err != nil {
+		klog.Warningf("Error cleaning up MPS control daemon artifacts: %v", err)
+	}
+	if err := gc.cleanupVGpuClaimDirs(claimUIDs); err != nil {
+		klog.Warningf("Error cleaning up vGPU claim directories: %v", err)
+	}
+}
+
+// cleanupCDIFiles removes the CDI spec files of claims that are not prepared.
+func (gc *GarbageCollector) cleanupCDIFiles(claimUIDs sets.Set[string]) error {
+	specs, err := gc.state.cdi.ListClaimSpecFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, claimUID := range specs {
+		if claimUIDs.Has(claimUID) {
+			continue
+		}
+		if err := gc.state.cdi.DeleteClaimSpecFile(claimUID); err != nil {
+			return fmt.Errorf("unable to delete CDI spec file for claim %v: %w", claimUID, err)
+		}
+		klog.Infof("Removed stale CDI spec file for claim %v", claimUID)
+		gc.removals.WithLabelValues(gcKindCDIClaimSpec).Inc()
+	}
+
+	return nil
+}
+
+// cleanupMpsControlDaemonArtifacts stops the MPS control daemons not started
+// for a prepared claim and removes their root directories.
+func (gc *GarbageCollector) cleanupMpsControlDaemonArtifacts(ctx context.Context, ids sets.Set[string]) error {
+	mpsManager := gc.state.mpsManager
+
+	kind := gcKindMpsDeployment
+	if gc.state.config.flags.mpsControlDaemonBackend == MpsProcessBackendName {
+		kind = gcKindMpsProcess
+	}
+
+	daemons, err := mpsManager.ListControlDaemons(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range daemons {
+		if ids.Has(id) {
+			continue
+		}
+		if err := mpsManager.DeleteControlDaemon(ctx, id); err != nil {
+			return fmt.Errorf("unable to delete MPS control daemon %v: %w", id, err)
+		}
+		klog.Infof("Removed stale MPS control daemon %v", id)
+		gc.removals.WithLabelValues(kind).Inc()
+	}
+
+	rootDirs, err := mpsManager.ListControlDaemonRootDirs()
+	if err != nil {
+		return err
+	}
+	for _, id := range rootDirs {
+		if ids.Has(id) {
+			continue
+		}
+		if err := mpsManager.RemoveControlDaemonRootDir(id); err != nil {
+			return fmt.Errorf("unable to remove MPS control daemon root directory %v: %w", id, err)
+		}
+		klog.Infof("Removed stale MPS control daemon root directory %v", id)
+		gc.removals.WithLabelValues(gcKindMpsRootDir).Inc()
+	}
+
+	return nil
+}
+
+// cleanupVGpuClaimDirs removes the vGPU control directories of claims that
+// are not prepared.
+func (gc *GarbageCollector) cleanupVGpuClaimDirs(claimUIDs sets.Set[string]) error {
+	dirs, err := gc.state.vgpuManager.ListClaimDirs()
+	if err != nil {
+		return err
+	}
+
+	for _, claimUID := range dirs {
+		if claimUIDs.Has(claimUID) {
+			continue
+		}
+		if err := gc.state.vgpuManager.DeleteClaimDir(claimUID); err != nil {
+			return fmt.Errorf("unable to delete vGPU claim directory for claim %v: %w", claimUID, err)
+		}
+		klog.Infof("Removed stale vGPU claim directory for claim %v", claimUID)
+		gc.removals.WithLabelValues(gcKindVGpuClaimDir).Inc()
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestCleanupVGpuClaimDirs(t *testing.T) {
+	vgpuManager := &VGpuManager{controlFilesRoot: t.TempDir()}
+	for _, claimUID := range []string{"prepared", "orphaned"} {
+		require.NoError(t, vgpuManager.CreateClaimDir(claimUID))
+	}
+
+	gc := &GarbageCollector{
+		state: &DeviceState{vgpuManager: vgpuManager},
+		removals: prometheus.NewCounterVec(
+			prometheus.CounterOpts{Name: "removals"},
+			[]string{"kind"},
+		),
+	}
+	require.NoError(t, gc.cleanupVGpuClaimDirs(sets.New("prepared")))
+
+	dirs, err := vgpuManager.ListClaimDirs()
+	require.NoError(t, err)
+	require.Equal(t, []string{"prepared"}, dirs)
+
+	var removals dto.Metric
+	require.NoError(t, gc.removals.WithLabelValues(gcKindVGpuClaimDir).Write(&removals))
+	require.Equal(t, 1.0, removals.GetCounter().GetValue())
+}
@@ -0,0 +1,363 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	corev1 "k8s.io/api/core/v1"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// healthEventTypes are the NVML events that mark a device as unhealthy.
+	healthEventTypes = nvml.EventTypeXidCriticalError | nvml.EventTypeDoubleBitEccError
+	// nvmlEventTimeoutMs bounds how long to wait for an NVML event before
+	// checking whether to stop or to watch newly enumerated GPUs.
+	nvmlEventTimeoutMs = 5000
+	// healthErrorBackoff is the initial delay before waiting for events again
+	// after NVML failed to wait for them. It doubles on every consecutive
+	// failure, up to healthErrorBackoffCap.
+	healthErrorBackoff    = time.Second
+	healthErrorBackoffCap = time.Minute
+	// allGpuInstances is the GPU instance ID reported for events that are
+	// not specific to a single MIG device.
+	allGpuInstances = 0xFFFFFFFF
+
+	DeviceUnhealthyEventReason = "DeviceUnhealthy"
+)
+
+// ignoredXids are XIDs that are caused by applications rather than by a
+// failing device, and therefore do not affect its health.
+var ignoredXids = sets.New[uint64](
+	13,  // Graphics Engine Exception
+	31,  // GPU memory page fault
+	43,  // GPU stopped processing
+	45,  // Preemptive cleanup, due to previous errors
+	68,  // Video processor exception
+	109, // Context Switch Timeout Error
+)
+
+// HealthMonitor watches the GPUs backing the allocatable devices for
+// critical XID and ECC errors. Devices on a failing GPU are marked unhealthy,
+// causing them to be withdrawn from the published resources, and an Event is
+// emitted on every prepared claim using one of them.
+type HealthMonitor struct {
+	state       *DeviceState
+	config      *Config
+	onUnhealthy func(ctx context.Context)
+}
+
+func NewHealthMonitor(config *Config, state *DeviceState, onUnhealthy func(ctx context.Context)) *HealthMonitor {
+	return &HealthMonitor{
+		state:       state,
+		config:      config,
+		onUnhealthy: onUnhealthy,
+	}
+}
+
+// Run waits for health events until the context is done.
+func (m *HealthMonitor) Run(ctx context.Context) {
+	if err := m.run(ctx); err != nil {
+		klog.Errorf("Device health monitoring stopped: %v", err)
+	}
+}
+
+func (m *HealthMonitor) run(ctx context.Context) error {
+	nvdevlib := m.state.nvdevlib
+	if err := nvdevlib.Init(); err != nil {
+		return err
+	}
+	defer nvdevlib.alwaysShutdown()
+
+	eventSet, ret := nvdevlib.nvmllib.EventSetCreate()
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("error creating event set: %v", ret)
+	}
+	defer func() {
+		if ret := eventSet.Free(); ret != nvml.SUCCESS {
+			klog.Warningf("Error freeing event set: %v", ret)
+		}
+	}()
+
+	registered := sets.New[string]()
+	backoff := healthErrorBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		// Pick up GPUs that appeared since the last re-enumeration.
+		for _, uuid := range m.state.Allocatable().GpuParentUUIDs() {
+			if registered.Has(uuid) {
+				continue
+			}
+			if err := nvdevlib.registerEvents(eventSet, uuid, healthEventTypes); err != nil {
+				// A GPU that cannot be watched is not necessarily unhealthy,
+				// as it may simply not support these events.
+				klog.Warningf("Not monitoring health of GPU %v: %v", uuid, err)
+			}
+			registered.Insert(uuid)
+		}
+
+		event, ret := eventSet.Wait(nvmlEventTimeoutMs)
+		if ret != nvml.SUCCESS && ret != nvml.ERROR_TIMEOUT {
+			klog.Warningf("Error waiting for health events, retrying in %v: %v", backoff, ret)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, healthErrorBackoffCap)
+			continue
+		}
+		backoff = healthErrorBackoff
+		if ret == nvml.ERROR_TIMEOUT {
+			continue
+		}
+		m.handleEvent(ctx, event)
+	}
+}
+
+func (m *HealthMonitor) handleEvent(ctx context.Context, event nvml.EventData) {
+	if event.EventType == nvml.EventTypeXidCriticalError && ignoredXids.Has(event.EventData) {
+		klog.V(4).Infof("Ignoring application XID %d", event.EventData)
+		return
+	}
+
+	uuid, ret := event.Device.GetUUID()
+	if ret != nvml.SUCCESS {
+		klog.Errorf("Error getting UUID of device with health event: %v", ret)
+		return
+	}
+
+	reason := fmt.Sprintf("ECC error (event type %d)", event.EventType)
+	if event.EventType == nvml.EventTypeXidCriticalError {
+		reason = fmt.Sprintf("XID %d", event.EventData)
+	}
+
+	var names []string
+	for name, device := range m.state.Allocatable() {
+		if !slices.Contains(gpuUUIDs(device), uuid) {
+			continue
+		}
+		if id, ok := gpuInstanceID(device); ok && event.GpuInstanceId != allGpuInstances && event.GpuInstanceId != id {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	newlyUnhealthy := m.state.MarkUnhealthy(names...)
+	if len(newlyUnhealthy) == 0 {
+		return
+	}
+	klog.Warningf("Marking devices unhealthy after %s on GPU %v: %v", reason, uuid, newlyUnhealthy)
+
+	m.onUnhealthy(ctx)
+
+	if err := m.emitClaimEvents(ctx, newlyUnhealthy, reason); err != nil {
+		klog.Errorf("Error emitting events for claims on unhealthy devices: %v", err)
+	}
+}
+
+// emitClaimEvents emits an Event on every prepared claim using one of the
+// given devices.
+func (m *HealthMonitor) emitClaimEvents(ctx context.Context, names []string, reason string) error {
+	checkpoint, err := m.state.getCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	affected := make(map[string][]string)
+	for claimUID, devices := range checkpoint.V2.PreparedClaims {
+		for _, device := range devices.GetDevices() {
+			if slices.Contains(names, device.DeviceName) {
+				affected[claimUID] = append(affected[claimUID], device.DeviceName)
+			}
+		}
+	}
+	if len(affected) == 0 {
+		return nil
+	}
+
+	claims, err := m.config.clientsets.Core.ResourceV1beta1().ResourceClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing resource claims: %w", err)
+	}
+
+	for _, claim := range claims.Items {
+		devices, exists := affected[string(claim.UID)]
+		if !exists {
+			continue
+		}
+		message := fmt.Sprintf("Devices %s on node %s are unhealthy: %s", strings.Join(devices, ", "), m.config.flags.nodeName, reason)
+		if err := m.emitClaimEvent(ctx, &claim, message); err != nil {
+			klog.Errorf("Error emitting event for claim %v/%v: %v", claim.Namespace, claim.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (m *HealthMonitor) emitClaimEvent(ctx context.Context, claim *resourceapi.ResourceClaim, message string) error {
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: claim.Name + ".",
+			Namespace:    claim.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: resourceapi.SchemeGroupVersion.String(),
+			Kind:       "ResourceClaim",
+			Namespace:  claim.Namespace,
+			Name:       claim.Name,
+			UID:        claim.UID,
+		},
+		Reason:  DeviceUnhealthyEventReason,
+		Message: message,
+		Type:    corev1.EventTypeWarning,
+		Source: corev1.EventSource{
+			Component: DriverName,
+			Host:      m.config.flags.nodeName,
+		},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: DriverName,
+		ReportingInstance:   m.config.flags.nodeName,
+	}
+	_, err := m.config.clientsets.Core.CoreV1().Events(claim.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	return err
+}
+
+// MarkUnhealthy marks the given devices as unhealthy and returns those that
+// were not already marked.
+func (s *DeviceState) MarkUnhealthy(names ...string) []string {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+
+	var marked []string
+	for _, name := range names {
+		if s.unhealthy.Has(name) {
+			continue
+		}
+		s.unhealthy.Insert(name)
+		marked = append(marked, name)
+	}
+	return marked
+}
+
+// ClearUnhealthy clears the unhealthy marks of the given devices and returns
+// those that were marked.
+func (s *DeviceState) ClearUnhealthy(names ...string) []string {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+
+	var cleared []string
+	for _, name := range names {
+		if !s.unhealthy.Has(name) {
+			continue
+		}
+		s.unhealthy.Delete(name)
+		cleared = append(cleared, name)
+	}
+	return cleared
+}
+
+// clearUnhealthyGpus clears the unhealthy marks of all devices on the given
+// full GPUs, e.g. after they have been reset, and requests the devices to be
+// republished.
+func (s *DeviceState) clearUnhealthyGpus(uuids ...string) {
+	var names []string
+	for name, device := range s.Allocatable() {
+		if slices.ContainsFunc(gpuUUIDs(device), func(uuid string) bool { return slices.Contains(uuids, uuid) }) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	cleared := s.ClearUnhealthy(names...)
+	if len(cleared) == 0 {
+		return
+	}
+	klog.Infof("Clearing unhealthy marks of devices on GPUs %v: %v", uuids, cleared)
+
+	select {
+	case s.healthCleared <- struct{}{}:
+	default:
+	}
+}
+
+// IsHealthy reports whether a device has not been marked unhealthy.
+func (s *DeviceState) IsHealthy(name string) bool {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	return !s.unhealthy.Has(name)
+}
+
+// parentGpuUUID returns the UUID of the full GPU an allocatable device is
+// carved out of, or the empty string for devices not backed by a GPU.
+func parentGpuUUID(device *AllocatableDevice) string {
+	switch device.Type() {
+	case GpuDeviceType:
+		return device.Gpu.UUID
+	case MigDeviceType:
+		return device.Mig.parent.UUID
+	case VGpuDeviceType:
+		return device.VGPU.parent.UUID
+	}
+	return ""
+}
+
+// gpuUUIDs returns the UUIDs of the full GPUs an allocatable device is
+// carved out of or, for NVLink groups, made up of.
+func gpuUUIDs(device *AllocatableDevice) []string {
+	if device.Type() == NvlinkGroupDeviceType {
+		return device.NvlinkGroup.UUIDs()
+	}
+	if uuid := parentGpuUUID(device); uuid != "" {
+		return []string{uuid}
+	}
+	return nil
+}
+
+// gpuInstanceID returns the ID of the GPU instance an allocatable device
+// lives on, if it is known.
+func gpuInstanceID(device *AllocatableDevice) (uint32, bool) {
+	var mig *MigDeviceInfo
+	switch device.Type() {
+	case MigDeviceType:
+		mig = device.Mig
+	case VGpuDeviceType:
+		mig = device.VGPU.mig
+	}
+	if mig == nil || mig.giInfo == nil {
+		return 0, false
+	}
+	return mig.giInfo.Id, true
+}
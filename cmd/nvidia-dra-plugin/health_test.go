@@ -0,0 +1,66 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestClearUnhealthyGpus(t *testing.T) {
+	dir := t.TempDir()
+	topologyPath := filepath.Join(dir, "topology.yaml")
+	require.NoError(t, os.WriteFile(topologyPath, []byte("gpus:\n- model: A100-SXM4-80GB\n  count: 2\n"), 0644))
+
+	l, err := newMockDeviceLib(root(filepath.Join(dir, "driver")), topologyPath)
+	require.NoError(t, err)
+
+	config := &Config{
+		flags: &Flags{
+			deviceClasses:  sets.New(GpuDeviceType, VGpuDeviceType),
+			vgpuSplitCount: 2,
+		},
+	}
+	allocatable, err := l.enumerateAllPossibleDevices(config)
+	require.NoError(t, err)
+
+	state := &DeviceState{
+		allocatable:   allocatable,
+		unhealthy:     sets.New[string](),
+		healthCleared: make(chan struct{}, 1),
+	}
+	require.ElementsMatch(t, []string{"gpu-0", "vgpu-0-0", "gpu-1"}, state.MarkUnhealthy("gpu-0", "vgpu-0-0", "gpu-1"))
+
+	// Resetting a GPU clears the marks of all devices on it and requests
+	// the devices to be republished.
+	state.clearUnhealthyGpus(allocatable["gpu-0"].Gpu.UUID)
+	require.True(t, state.IsHealthy("gpu-0"))
+	require.True(t, state.IsHealthy("vgpu-0-0"))
+	require.False(t, state.IsHealthy("gpu-1"))
+	require.Len(t, state.healthCleared, 1)
+
+	// A GPU without marked devices is not republished again.
+	<-state.healthCleared
+	state.clearUnhealthyGpus(allocatable["gpu-0"].Gpu.UUID)
+	require.Empty(t, state.healthCleared)
+}
@@ -19,15 +19,24 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"path"
 	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
 
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
 	"github.com/NVIDIA/k8s-dra-driver/internal/info"
 	"github.com/NVIDIA/k8s-dra-driver/pkg/flags"
 )
@@ -39,24 +48,47 @@ const (
 	DriverPluginPath           = "/var/lib/kubelet/plugins/" + DriverName
 	DriverPluginSocketPath     = DriverPluginPath + "/plugin.sock"
 	DriverPluginCheckpointFile = "checkpoint.json"
+
+	// MockDriverRoot is where the stub driver files of the mock NVML backend
+	// are created. It is inside the plugin directory, which is mounted at the
+	// same path in the plugin container as on the host.
+	MockDriverRoot = DriverPluginPath + "/mock-driver-root"
 )
 
 type Flags struct {
 	kubeClientConfig flags.KubeClientConfig
 	loggingConfig    *flags.LoggingConfig
 
-	nodeName            string
-	namespace           string
-	cdiRoot             string
-	containerDriverRoot string
-	hostDriverRoot      string
-	nvidiaCTKPath       string
-	deviceClasses       sets.Set[string]
+	httpEndpoint string
+	metricsPath  string
+	profilePath  string
+
+	nodeName                 string
+	namespace                string
+	cdiRoot                  string
+	containerDriverRoot      string
+	hostDriverRoot           string
+	sysfsRoot                string
+	nvidiaCTKPath            string
+	vgpuLibraryPath          string
+	vgpuSplitCount           int
+	vgpuScaling              configapi.VGpuScalingConfig
+	vgpuLowPriorityCoreShare int
+	dynamicMig               bool
+	gcInterval               time.Duration
+	healthChecks             bool
+	rediscoveryInterval      time.Duration
+	mockNvmlTopology         string
+	scrubPolicy              configapi.ScrubPolicy
+	mpsControlDaemonBackend  string
+	deviceClasses            sets.Set[string]
 }
 
 type Config struct {
 	flags      *Flags
 	clientsets flags.ClientSets
+	mux        *http.ServeMux
+	registry   *prometheus.Registry
 }
 
 func main() {
@@ -85,6 +117,28 @@ func newApp() *cli.App {
 			Destination: &flags.namespace,
 			EnvVars:     []string{"NAMESPACE"},
 		},
+		&cli.StringFlag{
+			Category:    "HTTP server:",
+			Name:        "http-endpoint",
+			Usage:       "The TCP network `address` where the HTTP server for diagnostics, including pprof and metrics will listen (example: `:8080`). The default is the empty string, which means the server is disabled.",
+			Destination: &flags.httpEndpoint,
+			EnvVars:     []string{"HTTP_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Category:    "HTTP server:",
+			Name:        "metrics-path",
+			Usage:       "The HTTP `path` where Prometheus metrics will be exposed, disabled if empty.",
+			Value:       "/metrics",
+			Destination: &flags.metricsPath,
+			EnvVars:     []string{"METRICS_PATH"},
+		},
+		&cli.StringFlag{
+			Category:    "HTTP server:",
+			Name:        "pprof-path",
+			Usage:       "The HTTP `path` where pprof profiling will be available, disabled if empty.",
+			Destination: &flags.profilePath,
+			EnvVars:     []string{"PPROF_PATH"},
+		},
 		&cli.StringFlag{
 			Name:        "cdi-root",
 			Usage:       "Absolute path to the directory where CDI files will be generated.",
@@ -107,6 +161,13 @@ func newApp() *cli.App {
 			Destination: &flags.containerDriverRoot,
 			EnvVars:     []string{"CONTAINER_DRIVER_ROOT"},
 		},
+		&cli.StringFlag{
+			Name:        "sysfs-root",
+			Value:       DefaultSysfsRoot,
+			Usage:       "the path where sysfs is mounted in the container; used for reading the PCIe, NUMA and NIC topology of GPUs.",
+			Destination: &flags.sysfsRoot,
+			EnvVars:     []string{"SYSFS_ROOT"},
+		},
 		&cli.StringFlag{
 			Name:        "nvidia-ctk-path",
 			Value:       "/usr/bin/nvidia-ctk",
@@ -114,10 +175,97 @@ func newApp() *cli.App {
 			Destination: &flags.nvidiaCTKPath,
 			EnvVars:     []string{"NVIDIA_CTK_PATH"},
 		},
+		&cli.StringFlag{
+			Name:        "vgpu-library-path",
+			Value:       DefaultVGpuLibraryPath,
+			Usage:       "the path to the vGPU interception library injected into containers consuming vGPUs. Note that this represents the path on the host.",
+			Destination: &flags.vgpuLibraryPath,
+			EnvVars:     []string{"VGPU_LIBRARY_PATH"},
+		},
+		&cli.IntFlag{
+			Name:        "vgpu-split-count",
+			Value:       DefaultVGpuSplitCount,
+			Usage:       "the number of vGPU slots to advertise for each physical GPU.",
+			Destination: &flags.vgpuSplitCount,
+			EnvVars:     []string{"VGPU_SPLIT_COUNT"},
+		},
+		&cli.Float64Flag{
+			Name:        "vgpu-core-scaling",
+			Value:       1,
+			Usage:       "the ratio by which the cores of each physical GPU may be over-subscribed by vGPUs.",
+			Destination: &flags.vgpuScaling.CoreScaling,
+			EnvVars:     []string{"VGPU_CORE_SCALING"},
+		},
+		&cli.Float64Flag{
+			Name:        "vgpu-memory-scaling",
+			Value:       1,
+			Usage:       "the ratio by which the memory of each physical GPU may be over-subscribed by vGPUs.",
+			Destination: &flags.vgpuScaling.MemoryScaling,
+			EnvVars:     []string{"VGPU_MEMORY_SCALING"},
+		},
+		&cli.IntFlag{
+			Name:        "vgpu-low-priority-core-share",
+			Value:       DefaultVGpuLowPriorityCoreShare,
+			Usage:       "the percentage of its core limit a vGPU is throttled to while a higher priority vGPU is active on the same GPU; 0 suspends it.",
+			Destination: &flags.vgpuLowPriorityCoreShare,
+			EnvVars:     []string{"VGPU_LOW_PRIORITY_CORE_SHARE"},
+		},
+		&cli.BoolFlag{
+			Name:        "dynamic-mig",
+			Usage:       "publish every possible MIG placement on MIG-enabled GPUs and create MIG devices on demand when claims are prepared, rather than publishing the MIG devices that already exist.",
+			Destination: &flags.dynamicMig,
+			EnvVars:     []string{"DYNAMIC_MIG"},
+		},
+		&cli.DurationFlag{
+			Name:        "gc-interval",
+			Value:       DefaultGarbageCollectionInterval,
+			Usage:       "the interval at which stale claim CDI spec files and MPS control daemon artifacts are removed; 0 disables garbage collection.",
+			Destination: &flags.gcInterval,
+			EnvVars:     []string{"GC_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:        "health-checks",
+			Value:       true,
+			Usage:       "watch GPUs for critical XID and ECC errors and withdraw the devices on failing GPUs from the published resources.",
+			Destination: &flags.healthChecks,
+			EnvVars:     []string{"HEALTH_CHECKS"},
+		},
+		&cli.DurationFlag{
+			Name:        "rediscovery-interval",
+			Value:       DefaultRediscoveryInterval,
+			Usage:       "the interval at which devices are re-enumerated and republished if changed; 0 disables periodic re-enumeration, leaving only SIGHUP and MIG configuration change events as triggers.",
+			Destination: &flags.rediscoveryInterval,
+			EnvVars:     []string{"REDISCOVERY_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:        "mock-nvml-topology",
+			Usage:       "the path to a YAML file describing the GPUs of a mock NVML library to run against instead of the NVIDIA driver, for development and testing without GPUs. Requires a plugin built with the 'mock' build tag.",
+			Destination: &flags.mockNvmlTopology,
+			EnvVars:     []string{"MOCK_NVML_TOPOLOGY"},
+		},
+		&cli.StringFlag{
+			Name:        "scrub-leftover-processes",
+			Usage:       "what to do about compute processes left on a GPU or MIG device when any claim is unprepared: Fail or Kill; empty skips the check. Claims may ask for stricter scrubbing through their GPU config.",
+			Destination: (*string)(&flags.scrubPolicy.LeftoverProcesses),
+			EnvVars:     []string{"SCRUB_LEFTOVER_PROCESSES"},
+		},
+		&cli.BoolFlag{
+			Name:        "scrub-reset-gpu",
+			Usage:       "reset full GPUs, clearing their memory, when any claim using them is unprepared.",
+			Destination: &flags.scrubPolicy.ResetGpu,
+			EnvVars:     []string{"SCRUB_RESET_GPU"},
+		},
+		&cli.StringFlag{
+			Name:        "mps-control-daemon-backend",
+			Value:       MpsDeploymentBackendName,
+			Usage:       "how MPS control daemons are run: 'deployment' runs each in a Deployment on this node, 'process' runs each as a supervised child process of the plugin.",
+			Destination: &flags.mpsControlDaemonBackend,
+			EnvVars:     []string{"MPS_CONTROL_DAEMON_BACKEND"},
+		},
 		&cli.StringSliceFlag{
 			Name:    "device-classes",
-			Usage:   "The supported set of DRA device classes",
-			Value:   cli.NewStringSlice(GpuDeviceType, MigDeviceType, ImexChannelType, VGpuDeviceType),
+			Usage:   "The supported set of DRA device classes. The vgpu and nvlink-group classes are opt-in, as the scheduler may allocate a vGPU or NVLink group and the GPUs or MIG devices it overlaps to different claims, one of which then fails to be prepared.",
+			Value:   cli.NewStringSlice(GpuDeviceType, MigDeviceType, ImexChannelType),
 			EnvVars: []string{"DEVICE_CLASSES"},
 		},
 	}
@@ -134,6 +282,23 @@ func newApp() *cli.App {
 			if c.Args().Len() > 0 {
 				return fmt.Errorf("arguments not supported: %v", c.Args().Slice())
 			}
+			if flags.vgpuSplitCount < 1 || flags.vgpuSplitCount > 100 {
+				return fmt.Errorf("vgpu-split-count must be between 1 and 100: %v", flags.vgpuSplitCount)
+			}
+			if flags.vgpuLowPriorityCoreShare < 0 || flags.vgpuLowPriorityCoreShare > 100 {
+				return fmt.Errorf("vgpu-low-priority-core-share must be between 0 and 100: %v", flags.vgpuLowPriorityCoreShare)
+			}
+			if err := flags.vgpuScaling.Validate(); err != nil {
+				return fmt.Errorf("invalid vGPU scaling: %w", err)
+			}
+			switch flags.mpsControlDaemonBackend {
+			case MpsDeploymentBackendName, MpsProcessBackendName:
+			default:
+				return fmt.Errorf("unknown MPS control daemon backend: %v", flags.mpsControlDaemonBackend)
+			}
+			if err := flags.scrubPolicy.Validate(); err != nil {
+				return fmt.Errorf("invalid scrub policy: %w", err)
+			}
 			return flags.loggingConfig.Apply()
 		},
 		Action: func(c *cli.Context) error {
@@ -148,6 +313,15 @@ func newApp() *cli.App {
 			config := &Config{
 				flags:      flags,
 				clientsets: clientSets,
+				mux:        http.NewServeMux(),
+				registry:   prometheus.NewRegistry(),
+			}
+
+			if flags.httpEndpoint != "" {
+				err = SetupHTTPEndpoint(config)
+				if err != nil {
+					return fmt.Errorf("create http endpoint: %w", err)
+				}
 			}
 
 			return StartPlugin(ctx, config)
@@ -164,6 +338,52 @@ func newApp() *cli.App {
 	return app
 }
 
+func SetupHTTPEndpoint(config *Config) error {
+	if config.flags.metricsPath != "" {
+		gatherers := prometheus.Gatherers{
+			// Include Go runtime and process metrics:
+			// https://github.com/kubernetes/kubernetes/blob/9780d88cb6a4b5b067256ecb4abf56892093ee87/staging/src/k8s.io/component-base/metrics/legacyregistry/registry.go#L46-L49
+			legacyregistry.DefaultGatherer,
+			config.registry,
+		}
+
+		actualPath := path.Join("/", config.flags.metricsPath)
+		klog.InfoS("Starting metrics", "path", actualPath)
+		// This is similar to k8s.io/component-base/metrics HandlerWithReset
+		// except that we gather from multiple sources.
+		config.mux.Handle(actualPath,
+			promhttp.InstrumentMetricHandler(
+				config.registry,
+				promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})))
+	}
+
+	if config.flags.profilePath != "" {
+		actualPath := path.Join("/", config.flags.profilePath)
+		klog.InfoS("Starting profiling", "path", actualPath)
+		config.mux.HandleFunc(actualPath, pprof.Index)
+		config.mux.HandleFunc(path.Join(actualPath, "cmdline"), pprof.Cmdline)
+		config.mux.HandleFunc(path.Join(actualPath, "profile"), pprof.Profile)
+		config.mux.HandleFunc(path.Join(actualPath, "symbol"), pprof.Symbol)
+		config.mux.HandleFunc(path.Join(actualPath, "trace"), pprof.Trace)
+	}
+
+	listener, err := net.Listen("tcp", config.flags.httpEndpoint)
+	if err != nil {
+		return fmt.Errorf("listen on HTTP endpoint: %w", err)
+	}
+
+	go func() {
+		klog.InfoS("Starting HTTP server", "endpoint", config.flags.httpEndpoint)
+		err := http.Serve(listener, config.mux)
+		if err != nil {
+			klog.ErrorS(err, "HTTP server failed")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}()
+
+	return nil
+}
+
 func StartPlugin(ctx context.Context, config *Config) error {
 	err := os.MkdirAll(DriverPluginPath, 0750)
 	if err != nil {
@@ -184,7 +404,7 @@ func StartPlugin(ctx context.Context, config *Config) error {
 	}
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
 	var driver *driver
 	ctx, cancel := context.WithCancel(ctx)
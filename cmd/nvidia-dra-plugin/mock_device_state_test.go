@@ -0,0 +1,226 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/kubelet/checkpointmanager"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+	"github.com/NVIDIA/k8s-dra-driver/pkg/flags"
+)
+
+const testNodeName = "node"
+
+// testDeviceState is a DeviceState backed by the mock NVML library, a fake
+// clientset and a fake MPS control daemon backend, with all of its files
+// kept in a temporary directory.
+type testDeviceState struct {
+	*DeviceState
+	client *fake.Clientset
+	mps    *fakeMpsBackend
+	mock   *mockNvml
+}
+
+// newTestDeviceState creates a testDeviceState for the GPUs of a mock
+// topology. Unset flags are defaulted.
+func newTestDeviceState(t *testing.T, topology string, f *Flags, objects ...runtime.Object) *testDeviceState {
+	dir := t.TempDir()
+	topologyPath := filepath.Join(dir, "topology.yaml")
+	require.NoError(t, os.WriteFile(topologyPath, []byte(topology), 0644))
+	driverRoot := filepath.Join(dir, "driver")
+
+	l, err := newMockDeviceLib(root(driverRoot), topologyPath)
+	require.NoError(t, err)
+
+	if f.nodeName == "" {
+		f.nodeName = testNodeName
+	}
+	if f.cdiRoot == "" {
+		f.cdiRoot = filepath.Join(dir, "cdi")
+	}
+	if f.deviceClasses == nil {
+		f.deviceClasses = sets.New(GpuDeviceType, MigDeviceType, VGpuDeviceType)
+	}
+	if f.vgpuLibraryPath == "" {
+		f.vgpuLibraryPath = DefaultVGpuLibraryPath
+	}
+	if f.vgpuSplitCount == 0 {
+		f.vgpuSplitCount = 2
+	}
+	if f.vgpuScaling == (configapi.VGpuScalingConfig{}) {
+		f.vgpuScaling = configapi.VGpuScalingConfig{CoreScaling: 1, MemoryScaling: 1}
+	}
+	client := fake.NewSimpleClientset(objects...)
+	config := &Config{
+		flags:      f,
+		clientsets: flags.ClientSets{Core: client},
+	}
+
+	allocatable, err := l.enumerateAllPossibleDevices(config)
+	require.NoError(t, err)
+
+	cdi, err := NewCDIHandler(append(
+		mockCDIOptions(l),
+		WithNvml(l.nvmllib),
+		WithDeviceLib(l),
+		WithDriverRoot(driverRoot),
+		WithCDIRoot(f.cdiRoot),
+	)...)
+	require.NoError(t, err)
+
+	checkpointManager, err := checkpointmanager.NewCheckpointManager(filepath.Join(dir, "plugin"))
+	require.NoError(t, err)
+	require.NoError(t, checkpointManager.CreateCheckpoint(DriverPluginCheckpointFile, newCheckpoint()))
+
+	mps := newFakeMpsBackend()
+	state := &DeviceState{
+		deviceLocks:       NewDeviceLocks(),
+		unhealthy:         sets.New[string](),
+		healthCleared:     make(chan struct{}, 1),
+		cdi:               cdi,
+		tsManager:         NewTimeSlicingManager(l),
+		mpsManager:        NewMpsManager(config, l, filepath.Join(dir, "mps"), mps),
+		vgpuManager:       NewVGpuManager(config, filepath.Join(dir, "vgpu")),
+		vgpuLedger:        NewVGpuLedger(allocatable, f.vgpuScaling),
+		allocatable:       allocatable,
+		config:            config,
+		nvdevlib:          l,
+		checkpointManager: checkpointManager,
+	}
+
+	return &testDeviceState{
+		DeviceState: state,
+		client:      client,
+		mps:         mps,
+		mock:        l.nvmllib.(*mockNvml),
+	}
+}
+
+// createClaim creates a ResourceClaim allocated the given devices of this
+// node in the fake clientset and returns it. Each device is allocated for a
+// request of the same name. The config, if any, must have its type set.
+func (s *testDeviceState) createClaim(t *testing.T, name string, config runtime.Object, devices ...string) *resourceapi.ResourceClaim {
+	claim := &resourceapi.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			UID:       types.UID(name + "-uid"),
+		},
+		Status: resourceapi.ResourceClaimStatus{
+			Allocation: &resourceapi.AllocationResult{},
+		},
+	}
+	for _, device := range devices {
+		claim.Status.Allocation.Devices.Results = append(claim.Status.Allocation.Devices.Results, resourceapi.DeviceRequestAllocationResult{
+			Request: device,
+			Driver:  DriverName,
+			Pool:    s.config.flags.nodeName,
+			Device:  device,
+		})
+	}
+	if config != nil {
+		raw, err := json.Marshal(config)
+		require.NoError(t, err)
+		claim.Status.Allocation.Devices.Config = []resourceapi.DeviceAllocationConfiguration{
+			{
+				Source: resourceapi.AllocationConfigSourceClaim,
+				DeviceConfiguration: resourceapi.DeviceConfiguration{
+					Opaque: &resourceapi.OpaqueDeviceConfiguration{
+						Driver:     DriverName,
+						Parameters: runtime.RawExtension{Raw: raw},
+					},
+				},
+			},
+		}
+	}
+
+	claim, err := s.client.ResourceV1beta1().ResourceClaims(claim.Namespace).Create(context.Background(), claim, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return claim
+}
+
+// fakeMpsBackend records the MPS control daemons it is asked to run instead
+// of running them.
+type fakeMpsBackend struct {
+	sync.Mutex
+	started sets.Set[string]
+	adopted sets.Set[string]
+	starts  int
+}
+
+var _ MpsControlDaemonBackend = &fakeMpsBackend{}
+
+func newFakeMpsBackend() *fakeMpsBackend {
+	return &fakeMpsBackend{
+		started: sets.New[string](),
+		adopted: sets.New[string](),
+	}
+}
+
+func (b *fakeMpsBackend) IsStarted(ctx context.Context, id string) (bool, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.started.Has(id), nil
+}
+
+func (b *fakeMpsBackend) Start(ctx context.Context, daemon *MpsControlDaemon, settings *MpsControlDaemonTemplateData) error {
+	b.Lock()
+	defer b.Unlock()
+	b.started.Insert(daemon.GetID())
+	b.starts++
+	return nil
+}
+
+func (b *fakeMpsBackend) AssertReady(ctx context.Context, daemon *MpsControlDaemon) error {
+	return nil
+}
+
+func (b *fakeMpsBackend) Adopt(ctx context.Context, daemon *MpsControlDaemon) error {
+	b.Lock()
+	defer b.Unlock()
+	b.adopted.Insert(daemon.GetID())
+	return nil
+}
+
+func (b *fakeMpsBackend) Delete(ctx context.Context, id string) error {
+	b.Lock()
+	defer b.Unlock()
+	b.started.Delete(id)
+	return nil
+}
+
+func (b *fakeMpsBackend) List(ctx context.Context) ([]string, error) {
+	b.Lock()
+	defer b.Unlock()
+	return sets.List(b.started), nil
+}
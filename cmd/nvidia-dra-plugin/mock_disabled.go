@@ -0,0 +1,35 @@
+//go:build !mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+)
+
+// ErrMockNotSupported indicates that the plugin was built without the mock
+// NVML backend, which is only included when building with the mock tag.
+var ErrMockNotSupported = errors.New("mock NVML backend not supported: plugin built without the 'mock' build tag")
+
+func newMockDeviceLib(driverRoot root, topologyPath string) (*deviceLib, error) {
+	return nil, ErrMockNotSupported
+}
+
+func mockCDIOptions(l *deviceLib) []cdiOption {
+	return nil
+}
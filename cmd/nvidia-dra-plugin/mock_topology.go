@@ -0,0 +1,249 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultMockDriverVersion     = "550.54.15"
+	defaultMockCudaDriverVersion = "12.4"
+)
+
+// MockTopology describes the GPUs of a node served by the mock NVML backend.
+// For example, eight A100 GPUs with the first four in MIG mode, each split
+// into two 3g.40gb devices, and the last four connected over NVLink:
+//
+//	gpus:
+//	- model: A100-SXM4-80GB
+//	  count: 4
+//	  migDevices: [3g.40gb, 3g.40gb]
+//	- model: A100-SXM4-80GB
+//	  count: 4
+//	  nvlink: true
+type MockTopology struct {
+	DriverVersion     string         `json:"driverVersion,omitempty"`
+	CudaDriverVersion string         `json:"cudaDriverVersion,omitempty"`
+	Gpus              []MockGpuGroup `json:"gpus"`
+}
+
+// MockGpuGroup describes a number of identical GPUs. GPUs are indexed in the
+// order their groups are listed.
+type MockGpuGroup struct {
+	Model string `json:"model"`
+	Count int    `json:"count,omitempty"`
+	// MigEnabled puts the GPUs in MIG mode. It is implied by MigDevices.
+	MigEnabled bool `json:"migEnabled,omitempty"`
+	// MigDevices are the profiles of the MIG devices that exist on each GPU
+	// at startup. Each one takes the first free placement of its profile.
+	MigDevices []string `json:"migDevices,omitempty"`
+	// NvLink connects all GPUs of the group to each other over NVLink.
+	NvLink bool `json:"nvlink,omitempty"`
+}
+
+// mockGpuModel holds the properties of a GPU model known to the mock.
+type mockGpuModel struct {
+	productName           string
+	memoryMiB             uint64
+	brand                 nvml.BrandType
+	architecture          nvml.DeviceArchitecture
+	cudaComputeCapability [2]int
+	migCapable            bool
+}
+
+var mockGpuModels = map[string]mockGpuModel{
+	"A100-SXM4-40GB": {
+		productName:           "NVIDIA A100-SXM4-40GB",
+		memoryMiB:             40960,
+		brand:                 nvml.BRAND_NVIDIA,
+		architecture:          nvml.DEVICE_ARCH_AMPERE,
+		cudaComputeCapability: [2]int{8, 0},
+		migCapable:            true,
+	},
+	"A100-SXM4-80GB": {
+		productName:           "NVIDIA A100-SXM4-80GB",
+		memoryMiB:             81920,
+		brand:                 nvml.BRAND_NVIDIA,
+		architecture:          nvml.DEVICE_ARCH_AMPERE,
+		cudaComputeCapability: [2]int{8, 0},
+		migCapable:            true,
+	},
+	"H100-SXM5-80GB": {
+		productName:           "NVIDIA H100 80GB HBM3",
+		memoryMiB:             81920,
+		brand:                 nvml.BRAND_NVIDIA,
+		architecture:          nvml.DEVICE_ARCH_HOPPER,
+		cudaComputeCapability: [2]int{9, 0},
+		migCapable:            true,
+	},
+	"L4": {
+		productName:           "NVIDIA L4",
+		memoryMiB:             23034,
+		brand:                 nvml.BRAND_NVIDIA,
+		architecture:          nvml.DEVICE_ARCH_ADA,
+		cudaComputeCapability: [2]int{8, 9},
+	},
+	"T4": {
+		productName:           "Tesla T4",
+		memoryMiB:             15360,
+		brand:                 nvml.BRAND_TESLA,
+		architecture:          nvml.DEVICE_ARCH_TURING,
+		cudaComputeCapability: [2]int{7, 5},
+	},
+}
+
+// mockGpuInstanceProfile is a GPU instance profile of the MIG-capable models,
+// all of which have 7 compute slices and 8 memory slices.
+type mockGpuInstanceProfile struct {
+	id              int
+	slices          uint32
+	memorySlices    uint32
+	instanceCount   uint32
+	placementStarts []uint32
+	media           bool
+}
+
+var mockGpuInstanceProfiles = []mockGpuInstanceProfile{
+	{id: nvml.GPU_INSTANCE_PROFILE_1_SLICE, slices: 1, memorySlices: 1, instanceCount: 7, placementStarts: []uint32{0, 1, 2, 3, 4, 5, 6}},
+	{id: nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV1, slices: 1, memorySlices: 1, instanceCount: 1, placementStarts: []uint32{0, 1, 2, 3, 4, 5, 6}, media: true},
+	{id: nvml.GPU_INSTANCE_PROFILE_1_SLICE_REV2, slices: 1, memorySlices: 2, instanceCount: 4, placementStarts: []uint32{0, 2, 4, 6}},
+	{id: nvml.GPU_INSTANCE_PROFILE_2_SLICE, slices: 2, memorySlices: 2, instanceCount: 3, placementStarts: []uint32{0, 2, 4}},
+	{id: nvml.GPU_INSTANCE_PROFILE_3_SLICE, slices: 3, memorySlices: 4, instanceCount: 2, placementStarts: []uint32{0, 4}},
+	{id: nvml.GPU_INSTANCE_PROFILE_4_SLICE, slices: 4, memorySlices: 4, instanceCount: 1, placementStarts: []uint32{0}},
+	{id: nvml.GPU_INSTANCE_PROFILE_7_SLICE, slices: 7, memorySlices: 8, instanceCount: 1, placementStarts: []uint32{0}},
+}
+
+// mockComputeInstanceProfiles maps the number of slices of a compute
+// instance to its profile ID.
+var mockComputeInstanceProfiles = map[uint32]int{
+	1: nvml.COMPUTE_INSTANCE_PROFILE_1_SLICE,
+	2: nvml.COMPUTE_INSTANCE_PROFILE_2_SLICE,
+	3: nvml.COMPUTE_INSTANCE_PROFILE_3_SLICE,
+	4: nvml.COMPUTE_INSTANCE_PROFILE_4_SLICE,
+	7: nvml.COMPUTE_INSTANCE_PROFILE_7_SLICE,
+}
+
+// LoadMockTopology reads and validates a topology file.
+func LoadMockTopology(path string) (*MockTopology, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading topology file: %w", err)
+	}
+	var topology MockTopology
+	if err := yaml.UnmarshalStrict(data, &topology); err != nil {
+		return nil, fmt.Errorf("error parsing topology file: %w", err)
+	}
+	if err := topology.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid topology file: %w", err)
+	}
+	return &topology, nil
+}
+
+// Validate ensures that a topology only uses known models and MIG profiles
+// that fit on their GPUs.
+func (t *MockTopology) Validate() error {
+	if _, err := t.cudaDriverVersion(); err != nil {
+		return err
+	}
+	if len(t.Gpus) == 0 {
+		return fmt.Errorf("no GPUs specified")
+	}
+	for i, group := range t.Gpus {
+		model, exists := mockGpuModels[group.Model]
+		if !exists {
+			return fmt.Errorf("GPU group %d: unknown model %q", i, group.Model)
+		}
+		if group.Count < 0 {
+			return fmt.Errorf("GPU group %d: invalid count %d", i, group.Count)
+		}
+		if !group.migEnabled() {
+			continue
+		}
+		if !model.migCapable {
+			return fmt.Errorf("GPU group %d: model %v does not support MIG", i, group.Model)
+		}
+		gpu := newMockDevice(0, "", model, true)
+		for _, name := range group.MigDevices {
+			if _, err := gpu.createMigDevice(name); err != nil {
+				return fmt.Errorf("GPU group %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *MockTopology) driverVersion() string {
+	if t.DriverVersion == "" {
+		return defaultMockDriverVersion
+	}
+	return t.DriverVersion
+}
+
+// cudaDriverVersion returns the CUDA driver version in the integer form
+// reported by NVML, e.g. 12040 for 12.4.
+func (t *MockTopology) cudaDriverVersion() (int, error) {
+	version := t.CudaDriverVersion
+	if version == "" {
+		version = defaultMockCudaDriverVersion
+	}
+	major, minor, found := strings.Cut(version, ".")
+	if !found {
+		return 0, fmt.Errorf("invalid CUDA driver version %q", version)
+	}
+	majorInt, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CUDA driver version %q: %w", version, err)
+	}
+	minorInt, err := strconv.Atoi(minor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CUDA driver version %q: %w", version, err)
+	}
+	return majorInt*1000 + minorInt*10, nil
+}
+
+func (g *MockGpuGroup) migEnabled() bool {
+	return g.MigEnabled || len(g.MigDevices) > 0
+}
+
+func (g *MockGpuGroup) count() int {
+	if g.Count == 0 {
+		return 1
+	}
+	return g.Count
+}
+
+// getMockGpuInstanceProfile returns the GPU instance profile with the given
+// ID, if it is supported.
+func getMockGpuInstanceProfile(id int) (mockGpuInstanceProfile, bool) {
+	i := slices.IndexFunc(mockGpuInstanceProfiles, func(p mockGpuInstanceProfile) bool {
+		return p.id == id
+	})
+	if i < 0 {
+		return mockGpuInstanceProfile{}, false
+	}
+	return mockGpuInstanceProfiles[i], true
+}
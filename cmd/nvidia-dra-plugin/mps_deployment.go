@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/util/retry"
+)
+
+// MpsDeploymentBackend runs each MPS control daemon in a Deployment pinned
+// to this node, rendered from a template.
+type MpsDeploymentBackend struct {
+	config         *Config
+	hostDriverRoot string
+	templatePath   string
+}
+
+func NewMpsDeploymentBackend(config *Config, hostDriverRoot, templatePath string) *MpsDeploymentBackend {
+	return &MpsDeploymentBackend{
+		config:         config,
+		hostDriverRoot: hostDriverRoot,
+		templatePath:   templatePath,
+	}
+}
+
+func (b *MpsDeploymentBackend) IsStarted(ctx context.Context, id string) (bool, error) {
+	name := fmt.Sprintf(MpsControlDaemonNameFmt, id)
+	_, err := b.config.clientsets.Core.AppsV1().Deployments(b.config.flags.namespace).Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	return true, nil
+}
+
+func (b *MpsDeploymentBackend) Start(ctx context.Context, daemon *MpsControlDaemon, settings *MpsControlDaemonTemplateData) error {
+	templateData := *settings
+	templateData.NvidiaDriverRoot = b.hostDriverRoot
+
+	tmpl, err := template.ParseFiles(b.templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse template file: %w", err)
+	}
+
+	var deploymentYaml bytes.Buffer
+	if err := tmpl.Execute(&deploymentYaml, templateData); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	var unstructuredObj unstructured.Unstructured
+	err = yaml.Unmarshal(deploymentYaml.Bytes(), &unstructuredObj)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal yaml: %w", err)
+	}
+
+	var deployment appsv1.Deployment
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredObj.UnstructuredContent(), &deployment)
+	if err != nil {
+		return fmt.Errorf("failed to convert unstructured data to typed object: %w", err)
+	}
+
+	_, err = b.config.clientsets.Core.AppsV1().Deployments(daemon.namespace).Create(ctx, &deployment, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+
+	return nil
+}
+
+func (b *MpsDeploymentBackend) AssertReady(ctx context.Context, daemon *MpsControlDaemon) error {
+	backoff := wait.Backoff{
+		Duration: time.Second,
+		Factor:   2,
+		Jitter:   1,
+		Steps:    4,
+		Cap:      10 * time.Second,
+	}
+
+	return retry.OnError(
+		backoff,
+		func(error) bool {
+			return true
+		},
+		func() error {
+			deployment, err := b.config.clientsets.Core.AppsV1().Deployments(daemon.namespace).Get(
+				ctx,
+				daemon.name,
+				metav1.GetOptions{},
+			)
+			if err != nil {
+				return fmt.Errorf("failed to get deployment: %w", err)
+			}
+
+			if deployment.Status.ReadyReplicas != 1 {
+				return fmt.Errorf("waiting for MPS control daemon to come online")
+			}
+
+			selector := deployment.Spec.Selector.MatchLabels
+
+			pods, err := b.config.clientsets.Core.CoreV1().Pods(daemon.namespace).List(
+				ctx,
+				metav1.ListOptions{
+					LabelSelector: labels.Set(selector).AsSelector().String(),
+				},
+			)
+			if err != nil {
+				return fmt.Errorf("error listing pods from deployment")
+			}
+
+			if len(pods.Items) != 1 {
+				return fmt.Errorf("unexpected number of pods in deployment: %v", len(pods.Items))
+			}
+
+			if len(pods.Items[0].Status.ContainerStatuses) != 1 {
+				return fmt.Errorf("unexpected number of container statuses in pod")
+			}
+
+			if !pods.Items[0].Status.ContainerStatuses[0].Ready {
+				return fmt.Errorf("control daemon not yet ready")
+			}
+
+			return nil
+		},
+	)
+}
+
+// Adopt verifies that the deployment of a control daemon still exists and is
+// ready. Deployments outlive the plugin, so nothing else is needed.
+func (b *MpsDeploymentBackend) Adopt(ctx context.Context, daemon *MpsControlDaemon) error {
+	isStarted, err := b.IsStarted(ctx, daemon.id)
+	if err != nil {
+		return fmt.Errorf("error checking if control daemon is started: %w", err)
+	}
+	if !isStarted {
+		return fmt.Errorf("control daemon deployment %v not found", daemon.name)
+	}
+
+	return b.AssertReady(ctx, daemon)
+}
+
+// Delete deletes the deployment of an MPS control daemon.
+func (b *MpsDeploymentBackend) Delete(ctx context.Context, id string) error {
+	deletePolicy := metav1.DeletePropagationForeground
+	deleteOptions := metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	}
+
+	name := fmt.Sprintf(MpsControlDaemonNameFmt, id)
+	err := b.config.clientsets.Core.AppsV1().Deployments(b.config.flags.namespace).Delete(ctx, name, deleteOptions)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete deployment: %w", err)
+	}
+
+	return nil
+}
+
+// List returns the IDs of all MPS control daemons deployed to this node.
+func (b *MpsDeploymentBackend) List(ctx context.Context) ([]string, error) {
+	deployments, err := b.config.clientsets.Core.AppsV1().Deployments(b.config.flags.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	prefix := fmt.Sprintf(MpsControlDaemonNameFmt, "")
+	var ids []string
+	for _, deployment := range deployments.Items {
+		if !strings.HasPrefix(deployment.Name, prefix) {
+			continue
+		}
+		if deployment.Spec.Template.Spec.NodeName != b.config.flags.nodeName {
+			continue
+		}
+		ids = append(ids, strings.TrimPrefix(deployment.Name, prefix))
+	}
+	return ids, nil
+}
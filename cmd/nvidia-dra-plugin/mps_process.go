@@ -0,0 +1,485 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	mpsProcessStateFile   = "control-daemon.json"
+	mpsStartupLogFile     = "startup.log"
+	mpsControlPipeTimeout = 10 * time.Second
+	mpsReadyTimeout       = 30 * time.Second
+	mpsStopTimeout        = 10 * time.Second
+	mpsRestartBackoff     = time.Second
+	mpsRestartBackoffCap  = time.Minute
+	// mpsStableRunTime is how long a control daemon must run before a crash
+	// no longer counts towards backing off its restarts.
+	mpsStableRunTime   = 5 * time.Minute
+	mpsPidPollInterval = time.Second
+)
+
+// mpsNamespaceScript runs a control daemon in a private mount namespace with
+// the tmpfs of the daemon mounted at /dev/shm, where the MPS server and its
+// clients share memory.
+const mpsNamespaceScript = `mount --make-rprivate / && mount --bind "$MPS_SHM_DIRECTORY" /dev/shm && exec "$@"`
+
+// MpsProcessBackend runs each MPS control daemon as a child process of the
+// plugin, using the nvidia-cuda-mps-control executable of the driver root.
+// Control daemons that exit are restarted, and their output is logged by the
+// plugin. The state of each daemon is recorded in its root directory, so that
+// a restarted plugin can adopt it, or start it again with the same settings.
+type MpsProcessBackend struct {
+	controlFilesRoot  string
+	controlPath       string
+	driverLibraryPath string
+
+	mutex   sync.Mutex
+	daemons map[string]*mpsProcess
+}
+
+// mpsProcessState is the state of a control daemon recorded in its root
+// directory.
+type mpsProcessState struct {
+	Pid      int                          `json:"pid"`
+	Settings MpsControlDaemonTemplateData `json:"settings"`
+}
+
+// mpsProcess supervises a single control daemon.
+type mpsProcess struct {
+	id       string
+	rootDir  string
+	settings MpsControlDaemonTemplateData
+	backend  *MpsProcessBackend
+
+	// stop is closed to stop the control daemon, done once it has stopped.
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewMpsProcessBackend(containerDriverRoot root, driverLibraryPath, controlFilesRoot string) (*MpsProcessBackend, error) {
+	controlPath, err := containerDriverRoot.getNvidiaCudaMpsControlPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate MPS control daemon: %w", err)
+	}
+	return &MpsProcessBackend{
+		controlFilesRoot:  controlFilesRoot,
+		controlPath:       controlPath,
+		driverLibraryPath: driverLibraryPath,
+		daemons:           make(map[string]*mpsProcess),
+	}, nil
+}
+
+func (b *MpsProcessBackend) IsStarted(ctx context.Context, id string) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, exists := b.daemons[id]
+	return exists, nil
+}
+
+func (b *MpsProcessBackend) Start(ctx context.Context, daemon *MpsControlDaemon, settings *MpsControlDaemonTemplateData) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, exists := b.daemons[daemon.id]; exists {
+		return nil
+	}
+
+	p := b.newMpsProcess(daemon.id, *settings)
+	if err := p.writeState(0); err != nil {
+		return err
+	}
+	b.daemons[daemon.id] = p
+	go p.supervise(0)
+	return nil
+}
+
+// AssertReady waits for the control daemon to have been configured, which is
+// recorded in its startup log as by the deployment backend.
+func (b *MpsProcessBackend) AssertReady(ctx context.Context, daemon *MpsControlDaemon) error {
+	startupLog := filepath.Join(daemon.logDir, mpsStartupLogFile)
+	err := wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, mpsReadyTimeout, true, func(context.Context) (bool, error) {
+		_, err := os.Stat(startupLog)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for MPS control daemon to come online: %w", err)
+	}
+	return nil
+}
+
+// Adopt supervises a control daemon started by a previous instance of the
+// plugin. If it is no longer running, it is started again with the settings
+// it was recorded with.
+func (b *MpsProcessBackend) Adopt(ctx context.Context, daemon *MpsControlDaemon) error {
+	b.mutex.Lock()
+	_, exists := b.daemons[daemon.id]
+	if !exists {
+		state, err := readMpsProcessState(daemon.rootDir)
+		if err != nil {
+			b.mutex.Unlock()
+			return err
+		}
+		p := b.newMpsProcess(daemon.id, state.Settings)
+		pid := 0
+		if isMpsControlDaemonRunning(state.Pid) {
+			pid = state.Pid
+		} else {
+			klog.Warningf("MPS control daemon %v is no longer running, starting it again", daemon.id)
+		}
+		b.daemons[daemon.id] = p
+		go p.supervise(pid)
+	}
+	b.mutex.Unlock()
+
+	return b.AssertReady(ctx, daemon)
+}
+
+// Delete stops a control daemon, whether supervised by this instance of the
+// plugin or left running by a previous one.
+func (b *MpsProcessBackend) Delete(ctx context.Context, id string) error {
+	b.mutex.Lock()
+	p, exists := b.daemons[id]
+	b.mutex.Unlock()
+
+	if !exists {
+		state, err := readMpsProcessState(filepath.Join(b.controlFilesRoot, id))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if isMpsControlDaemonRunning(state.Pid) {
+			b.newMpsProcess(id, state.Settings).terminate(state.Pid, watchPid(state.Pid))
+		}
+		return nil
+	}
+
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return fmt.Errorf("error waiting for MPS control daemon %v to stop: %w", id, ctx.Err())
+	}
+
+	b.mutex.Lock()
+	delete(b.daemons, id)
+	b.mutex.Unlock()
+	return nil
+}
+
+// List returns the IDs of the control daemons supervised by the plugin and
+// of those left running by a previous instance of it.
+func (b *MpsProcessBackend) List(ctx context.Context) ([]string, error) {
+	b.mutex.Lock()
+	var ids []string
+	for id := range b.daemons {
+		ids = append(ids, id)
+	}
+	b.mutex.Unlock()
+
+	entries, err := os.ReadDir(b.controlFilesRoot)
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %v: %w", b.controlFilesRoot, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || slices.Contains(ids, entry.Name()) {
+			continue
+		}
+		state, err := readMpsProcessState(filepath.Join(b.controlFilesRoot, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if isMpsControlDaemonRunning(state.Pid) {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+func (b *MpsProcessBackend) newMpsProcess(id string, settings MpsControlDaemonTemplateData) *mpsProcess {
+	return &mpsProcess{
+		id:       id,
+		rootDir:  filepath.Join(b.controlFilesRoot, id),
+		settings: settings,
+		backend:  b,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// supervise runs the control daemon until it is stopped, restarting it with
+// an increasing delay whenever it exits. A control daemon that is already
+// running with the given PID is watched rather than started.
+func (p *mpsProcess) supervise(pid int) {
+	defer close(p.done)
+
+	backoff := mpsRestartBackoff
+	for {
+		var exited <-chan struct{}
+		if pid != 0 {
+			exited = watchPid(pid)
+		} else {
+			var err error
+			pid, exited, err = p.start()
+			if err != nil {
+				klog.Errorf("Error starting MPS control daemon %v: %v", p.id, err)
+			}
+		}
+
+		started := time.Now()
+		if exited != nil {
+			select {
+			case <-p.stop:
+				p.terminate(pid, exited)
+				return
+			case <-exited:
+			}
+			klog.Warningf("MPS control daemon %v exited, restarting it in %v", p.id, backoff)
+		}
+		pid = 0
+
+		if time.Since(started) > mpsStableRunTime {
+			backoff = mpsRestartBackoff
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, mpsRestartBackoffCap)
+	}
+}
+
+// start starts and configures the control daemon, returning its PID and a
+// channel closed once it has exited.
+func (p *mpsProcess) start() (int, <-chan struct{}, error) {
+	startupLog := filepath.Join(p.settings.MpsLogDirectory, mpsStartupLogFile)
+	if err := os.Remove(startupLog); err != nil && !os.IsNotExist(err) {
+		return 0, nil, fmt.Errorf("error removing startup log: %w", err)
+	}
+
+	cmd := exec.Command("sh", "-c", mpsNamespaceScript, "sh", p.backend.controlPath, "-f")
+	cmd.Env = append(p.env(), "MPS_SHM_DIRECTORY="+p.settings.MpsShmDirectory)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNS,
+		Setpgid:    true,
+	}
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, nil, fmt.Errorf("error capturing output: %w", err)
+	}
+	cmd.Stderr = cmd.Stdout
+
+	if err := cmd.Start(); err != nil {
+		return 0, nil, fmt.Errorf("error starting %v: %w", p.backend.controlPath, err)
+	}
+	pid := cmd.Process.Pid
+
+	exited := make(chan struct{})
+	go func() {
+		p.logOutput(output)
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	if err := p.writeState(pid); err != nil {
+		p.terminate(pid, exited)
+		return 0, nil, err
+	}
+	if err := p.configure(exited); err != nil {
+		p.terminate(pid, exited)
+		return 0, nil, err
+	}
+
+	klog.Infof("Started MPS control daemon %v with PID %d", p.id, pid)
+	return pid, exited, nil
+}
+
+// configure applies the default client limits of the settings once the
+// control daemon accepts commands, then records it as ready.
+func (p *mpsProcess) configure(exited <-chan struct{}) error {
+	controlPipe := filepath.Join(p.settings.MpsPipeDirectory, "control")
+	err := wait.PollUntilContextTimeout(context.Background(), 100*time.Millisecond, mpsControlPipeTimeout, true, func(context.Context) (bool, error) {
+		select {
+		case <-exited:
+			return false, fmt.Errorf("control daemon exited")
+		default:
+		}
+		_, err := os.Stat(controlPipe)
+		return err == nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("error waiting for control pipe: %w", err)
+	}
+
+	var commands []string
+	if p.settings.DefaultActiveThreadPercentage != "" {
+		commands = append(commands, fmt.Sprintf("set_default_active_thread_percentage %s", p.settings.DefaultActiveThreadPercentage))
+	}
+	for _, id := range slices.Sorted(maps.Keys(p.settings.DefaultPinnedDeviceMemoryLimits)) {
+		commands = append(commands, fmt.Sprintf("set_default_device_pinned_mem_limit %s %s", id, p.settings.DefaultPinnedDeviceMemoryLimits[id]))
+	}
+	for _, command := range commands {
+		if err := p.control(command); err != nil {
+			return err
+		}
+	}
+
+	startupLog := filepath.Join(p.settings.MpsLogDirectory, mpsStartupLogFile)
+	if err := os.WriteFile(startupLog, []byte("startup complete\n"), 0644); err != nil {
+		return fmt.Errorf("error writing startup log: %w", err)
+	}
+	return nil
+}
+
+// control sends a command to the control daemon.
+func (p *mpsProcess) control(command string) error {
+	cmd := exec.Command(p.backend.controlPath)
+	cmd.Env = p.env()
+	cmd.Stdin = strings.NewReader(command + "\n")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error running MPS control command %q: %w: %s", command, err, output)
+	}
+	return nil
+}
+
+// terminate asks the control daemon to quit, and kills it along with its MPS
+// servers if it has not exited in time.
+func (p *mpsProcess) terminate(pid int, exited <-chan struct{}) {
+	if err := p.control("quit"); err != nil {
+		klog.Warningf("Error asking MPS control daemon %v to quit: %v", p.id, err)
+	}
+	select {
+	case <-exited:
+		return
+	case <-time.After(mpsStopTimeout):
+	}
+	klog.Warningf("Killing MPS control daemon %v", p.id)
+	if err := unix.Kill(-pid, unix.SIGKILL); err != nil && !errors.Is(err, unix.ESRCH) {
+		klog.Warningf("Error killing MPS control daemon %v: %v", p.id, err)
+	}
+	<-exited
+}
+
+// env returns the environment of the control daemon and its clients.
+func (p *mpsProcess) env() []string {
+	env := os.Environ()
+	env = setOrOverrideEnvvar(env, "PATH", prependPathListEnvvar("PATH", filepath.Dir(p.backend.controlPath)))
+	if p.backend.driverLibraryPath != "" {
+		env = setOrOverrideEnvvar(env, "LD_LIBRARY_PATH", prependPathListEnvvar("LD_LIBRARY_PATH", filepath.Dir(p.backend.driverLibraryPath)))
+	}
+	env = setOrOverrideEnvvar(env, "CUDA_VISIBLE_DEVICES", p.settings.CUDA_VISIBLE_DEVICES)
+	env = setOrOverrideEnvvar(env, "CUDA_MPS_PIPE_DIRECTORY", p.settings.MpsPipeDirectory)
+	env = setOrOverrideEnvvar(env, "CUDA_MPS_LOG_DIRECTORY", p.settings.MpsLogDirectory)
+	return env
+}
+
+// logOutput logs the output of the control daemon until it is closed.
+func (p *mpsProcess) logOutput(output io.Reader) {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		klog.Infof("MPS control daemon %v: %s", p.id, scanner.Text())
+	}
+}
+
+func (p *mpsProcess) writeState(pid int) error {
+	state := mpsProcessState{
+		Pid:      pid,
+		Settings: p.settings,
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error encoding MPS control daemon state: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(p.rootDir, mpsProcessStateFile), data, 0644); err != nil {
+		return fmt.Errorf("error writing MPS control daemon state: %w", err)
+	}
+	return nil
+}
+
+func readMpsProcessState(rootDir string) (*mpsProcessState, error) {
+	data, err := os.ReadFile(filepath.Join(rootDir, mpsProcessStateFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading MPS control daemon state: %w", err)
+	}
+	var state mpsProcessState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error decoding MPS control daemon state: %w", err)
+	}
+	return &state, nil
+}
+
+// isMpsControlDaemonRunning reports whether the process with the given PID
+// is an MPS control daemon, rather than an unrelated process that reused the
+// PID of one that exited.
+func isMpsControlDaemonRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(cmdline), "nvidia-cuda-mps-control")
+}
+
+// watchPid returns a channel closed once the process with the given PID,
+// which need not be a child of the plugin, has exited.
+func watchPid(pid int) <-chan struct{} {
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		for isMpsControlDaemonRunning(pid) {
+			time.Sleep(mpsPidPollInterval)
+		}
+	}()
+	return exited
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMpsControl stands in for nvidia-cuda-mps-control: with -f it runs as
+// the control daemon until told to quit, otherwise it records the command
+// read from stdin.
+const fakeMpsControl = `#!/bin/sh
+if [ "$1" = "-f" ]; then
+  echo "$$" > "$CUDA_MPS_PIPE_DIRECTORY/daemon.pid"
+  touch "$CUDA_MPS_PIPE_DIRECTORY/control"
+  echo "control daemon started"
+  while [ ! -e "$CUDA_MPS_PIPE_DIRECTORY/quit" ]; do sleep 0.1; done
+  rm -f "$CUDA_MPS_PIPE_DIRECTORY/quit" "$CUDA_MPS_PIPE_DIRECTORY/control"
+  exit 0
+fi
+read command
+echo "$command" >> "$CUDA_MPS_LOG_DIRECTORY/commands"
+if [ "$command" = quit ]; then touch "$CUDA_MPS_PIPE_DIRECTORY/quit"; fi
+`
+
+func TestMpsProcessBackend(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("running control daemons in a mount namespace requires root")
+	}
+
+	dir := t.TempDir()
+	driverRoot := filepath.Join(dir, "driver")
+	controlPath := filepath.Join(driverRoot, "usr", "bin", "nvidia-cuda-mps-control")
+	require.NoError(t, os.MkdirAll(filepath.Dir(controlPath), 0755))
+	require.NoError(t, os.WriteFile(controlPath, []byte(fakeMpsControl), 0755))
+
+	controlFilesRoot := filepath.Join(dir, "mps")
+	newDaemon := func(id string) *MpsControlDaemon {
+		daemon := &MpsControlDaemon{
+			id:      id,
+			rootDir: filepath.Join(controlFilesRoot, id),
+			pipeDir: filepath.Join(controlFilesRoot, id, "pipe"),
+			shmDir:  filepath.Join(controlFilesRoot, id, "shm"),
+			logDir:  filepath.Join(controlFilesRoot, id, "log"),
+		}
+		for _, d := range []string{daemon.pipeDir, daemon.shmDir, daemon.logDir} {
+			require.NoError(t, os.MkdirAll(d, 0755))
+		}
+		return daemon
+	}
+	settingsFor := func(daemon *MpsControlDaemon) *MpsControlDaemonTemplateData {
+		return &MpsControlDaemonTemplateData{
+			CUDA_VISIBLE_DEVICES:            "GPU-0",
+			DefaultActiveThreadPercentage:   "50",
+			DefaultPinnedDeviceMemoryLimits: map[string]string{"GPU-0": "1024M"},
+			MpsShmDirectory:                 daemon.shmDir,
+			MpsPipeDirectory:                daemon.pipeDir,
+			MpsLogDirectory:                 daemon.logDir,
+		}
+	}
+	daemonPid := func(daemon *MpsControlDaemon) int {
+		data, err := os.ReadFile(filepath.Join(daemon.pipeDir, "daemon.pid"))
+		require.NoError(t, err)
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		require.NoError(t, err)
+		return pid
+	}
+
+	ctx := context.Background()
+	b, err := NewMpsProcessBackend(root(driverRoot), "", controlFilesRoot)
+	require.NoError(t, err)
+
+	// The control daemon is started and configured.
+	daemon := newDaemon("claim-1")
+	require.NoError(t, b.Start(ctx, daemon, settingsFor(daemon)))
+	require.NoError(t, b.AssertReady(ctx, daemon))
+	started, err := b.IsStarted(ctx, daemon.id)
+	require.NoError(t, err)
+	require.True(t, started)
+	commands, err := os.ReadFile(filepath.Join(daemon.logDir, "commands"))
+	require.NoError(t, err)
+	require.Equal(t, "set_default_active_thread_percentage 50\nset_default_device_pinned_mem_limit GPU-0 1024M\n", string(commands))
+
+	// The control daemon is restarted once it crashes.
+	pid := daemonPid(daemon)
+	require.NoError(t, exec.Command("kill", "-9", strconv.Itoa(pid)).Run())
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(daemon.logDir, mpsStartupLogFile))
+		return err == nil && daemonPid(daemon) != pid
+	}, 10*time.Second, 100*time.Millisecond)
+
+	ids, err := b.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{daemon.id}, ids)
+
+	require.NoError(t, b.Delete(ctx, daemon.id))
+	started, err = b.IsStarted(ctx, daemon.id)
+	require.NoError(t, err)
+	require.False(t, started)
+	require.False(t, isMpsControlDaemonRunning(daemonPid(daemon)))
+
+	// A control daemon left running by a previous instance of the plugin is
+	// adopted rather than started again.
+	daemon = newDaemon("claim-2")
+	p := b.newMpsProcess(daemon.id, *settingsFor(daemon))
+	cmd := exec.Command("sh", controlPath, "-f")
+	cmd.Env = p.env()
+	require.NoError(t, cmd.Start())
+	go func() { _ = cmd.Wait() }()
+	require.NoError(t, p.writeState(cmd.Process.Pid))
+	require.NoError(t, os.WriteFile(filepath.Join(daemon.logDir, mpsStartupLogFile), nil, 0644))
+
+	b, err = NewMpsProcessBackend(root(driverRoot), "", controlFilesRoot)
+	require.NoError(t, err)
+	ids, err = b.List(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{daemon.id}, ids)
+	require.NoError(t, b.Adopt(ctx, daemon))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(daemon.pipeDir, "daemon.pid"))
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	require.Equal(t, cmd.Process.Pid, daemonPid(daemon))
+
+	require.NoError(t, b.Delete(ctx, daemon.id))
+	require.False(t, isMpsControlDaemonRunning(cmd.Process.Pid))
+}
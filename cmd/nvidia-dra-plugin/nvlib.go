@@ -18,6 +18,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -26,6 +27,7 @@ import (
 	"strings"
 
 	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
 
 	nvdev "github.com/NVIDIA/go-nvlib/pkg/nvlib/device"
@@ -37,12 +39,36 @@ const (
 	nvidiaCapsImexChannelsDeviceName = "nvidia-caps-imex-channels"
 )
 
+// computeModes maps the names of compute modes, as used by nvidia-smi, to
+// their NVML values.
+var computeModes = map[string]nvml.ComputeMode{
+	"DEFAULT":           nvml.COMPUTEMODE_DEFAULT,
+	"PROHIBITED":        nvml.COMPUTEMODE_PROHIBITED,
+	"EXCLUSIVE_PROCESS": nvml.COMPUTEMODE_EXCLUSIVE_PROCESS,
+}
+
+// ErrTimeSliceNotSupported is returned when setting the time-slice interval
+// of a GPU without nvidia-smi being available.
+var ErrTimeSliceNotSupported = errors.New("setting the time-slice interval requires nvidia-smi")
+
+// NvmlError is returned when an NVML call on a GPU fails.
+type NvmlError struct {
+	Op     string
+	UUID   string
+	Return nvml.Return
+}
+
+func (e *NvmlError) Error() string {
+	return fmt.Sprintf("error %s for GPU %s: %v", e.Op, e.UUID, e.Return)
+}
+
 type deviceLib struct {
 	nvdev.Interface
 	nvmllib           nvml.Interface
 	driverLibraryPath string
 	devRoot           string
 	nvidiaSMIPath     string
+	killProcess       func(pid uint32) error
 }
 
 func newDeviceLib(driverRoot root) (*deviceLib, error) {
@@ -51,9 +77,10 @@ func newDeviceLib(driverRoot root) (*deviceLib, error) {
 		return nil, fmt.Errorf("failed to locate driver libraries: %w", err)
 	}
 
+	// nvidia-smi is only needed to set time-slice intervals.
 	nvidiaSMIPath, err := driverRoot.getNvidiaSMIPath()
 	if err != nil {
-		return nil, fmt.Errorf("failed to locate nvidia-smi: %w", err)
+		klog.Warningf("Unable to locate nvidia-smi, time-slice intervals cannot be set: %v", err)
 	}
 
 	// We construct an NVML library specifying the path to libnvidia-ml.so.1
@@ -67,6 +94,7 @@ func newDeviceLib(driverRoot root) (*deviceLib, error) {
 		driverLibraryPath: driverLibraryPath,
 		devRoot:           driverRoot.getDevRoot(),
 		nvidiaSMIPath:     nvidiaSMIPath,
+		killProcess:       killProcess,
 	}
 	return &d, nil
 }
@@ -108,11 +136,31 @@ func (l deviceLib) alwaysShutdown() {
 	}
 }
 
+// registerEvents registers the supported subset of the given NVML event
+// types of a GPU with an event set.
+func (l deviceLib) registerEvents(eventSet nvml.EventSet, uuid string, eventTypes uint64) error {
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(uuid)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("error getting device handle: %v", ret)
+	}
+	supported, ret := device.GetSupportedEventTypes()
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("error getting supported event types: %v", ret)
+	}
+	if eventTypes&supported == 0 {
+		return fmt.Errorf("event types %#x not supported", eventTypes)
+	}
+	if ret := device.RegisterEvents(eventTypes&supported, eventSet); ret != nvml.SUCCESS {
+		return fmt.Errorf("error registering events: %v", ret)
+	}
+	return nil
+}
+
 func (l deviceLib) enumerateAllPossibleDevices(config *Config) (AllocatableDevices, error) {
 	alldevices := make(AllocatableDevices)
 	deviceClasses := config.flags.deviceClasses
 
-	if deviceClasses.Has(GpuDeviceType) || deviceClasses.Has(MigDeviceType) {
+	if deviceClasses.Has(GpuDeviceType) || deviceClasses.Has(MigDeviceType) || deviceClasses.Has(NvlinkGroupDeviceType) {
 		gms, err := l.enumerateGpusAndMigDevices(config)
 		if err != nil {
 			return nil, fmt.Errorf("error enumerating GPUs and MIG devices: %w", err)
@@ -153,11 +201,17 @@ func (l deviceLib) enumerateGpusAndMigDevices(config *Config) (AllocatableDevice
 
 	devices := make(AllocatableDevices)
 	deviceClasses := config.flags.deviceClasses
+	var gpus []*GpuInfo
 	err := l.VisitDevices(func(i int, d nvdev.Device) error {
 		gpuInfo, err := l.getGpuInfo(i, d)
 		if err != nil {
 			return fmt.Errorf("error getting info for GPU %d: %w", i, err)
 		}
+		gpuInfo.pciTopology, err = getPciTopology(config.flags.sysfsRoot, gpuInfo.pciBusID)
+		if err != nil {
+			klog.Warningf("Unable to read PCI topology of GPU %d, topology attributes will not be published: %v", i, err)
+		}
+		gpus = append(gpus, gpuInfo)
 
 		if deviceClasses.Has(GpuDeviceType) && !gpuInfo.migEnabled {
 			deviceInfo := &AllocatableDevice{
@@ -167,7 +221,11 @@ func (l deviceLib) enumerateGpusAndMigDevices(config *Config) (AllocatableDevice
 		}
 
 		if deviceClasses.Has(MigDeviceType) {
-			migs, err := l.getMigDevices(gpuInfo)
+			getMigs := l.getMigDevices
+			if config.flags.dynamicMig {
+				getMigs = l.getMigPlacements
+			}
+			migs, err := getMigs(gpuInfo)
 			if err != nil {
 				return fmt.Errorf("error getting MIG devices for GPU %d: %w", i, err)
 			}
@@ -186,6 +244,19 @@ func (l deviceLib) enumerateGpusAndMigDevices(config *Config) (AllocatableDevice
 		return nil, fmt.Errorf("error visiting devices: %w", err)
 	}
 
+	if deviceClasses.Has(NvlinkGroupDeviceType) {
+		groups, err := l.getNvlinkGroups(gpus)
+		if err != nil {
+			return nil, fmt.Errorf("error getting NVLink groups: %w", err)
+		}
+		for _, group := range groups {
+			deviceInfo := &AllocatableDevice{
+				NvlinkGroup: group,
+			}
+			devices[group.CanonicalName()] = deviceInfo
+		}
+	}
+
 	return devices, nil
 }
 
@@ -242,6 +313,10 @@ func (l deviceLib) getGpuInfo(index int, device nvdev.Device) (*GpuInfo, error)
 	if err != nil {
 		return nil, fmt.Errorf("error getting CUDA compute capability for device %d: %w", index, err)
 	}
+	pciInfo, ret := device.GetPciInfo()
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("error getting PCI info for device %d: %v", index, ret)
+	}
 	driverVersion, ret := l.nvmllib.SystemGetDriverVersion()
 	if ret != nvml.SUCCESS {
 		return nil, fmt.Errorf("error getting driver version: %w", err)
@@ -310,6 +385,7 @@ func (l deviceLib) getGpuInfo(index int, device nvdev.Device) (*GpuInfo, error)
 		index:                 index,
 		migEnabled:            migEnabled,
 		memoryBytes:           memory.Total,
+		pciBusID:              normalizePciBusID(pciBusIDToString(pciInfo.BusId)),
 		productName:           productName,
 		brand:                 brand,
 		architecture:          architecture,
@@ -528,7 +604,18 @@ func (l deviceLib) createImexChannelDevice(channel int) error {
 	return nil
 }
 
+// setTimeSlice sets the time-slice interval of the given GPUs.
+//
+// Unlike the compute mode, this is not set through NVML: its scheduler APIs
+// (nvmlDeviceSetVgpuSchedulerState and friends) only apply to vGPU hosts, and
+// there is no NVML equivalent of `nvidia-smi compute-policy --set-timeslice`
+// for compute workloads on bare-metal GPUs. The interval is therefore still
+// set by running nvidia-smi once per GPU. If nvidia-smi is not available,
+// ErrTimeSliceNotSupported is returned.
 func (l deviceLib) setTimeSlice(uuids []string, timeSlice int) error {
+	if l.nvidiaSMIPath == "" {
+		return ErrTimeSliceNotSupported
+	}
 	for _, uuid := range uuids {
 		cmd := exec.Command(
 			l.nvidiaSMIPath,
@@ -548,132 +635,301 @@ func (l deviceLib) setTimeSlice(uuids []string, timeSlice int) error {
 	return nil
 }
 
+// setComputeMode sets the compute mode of the given GPUs by name, as
+// returned by getComputeMode.
 func (l deviceLib) setComputeMode(uuids []string, mode string) error {
-	for _, uuid := range uuids {
-		cmd := exec.Command(
-			l.nvidiaSMIPath,
-			"-i", uuid,
-			"-c", mode)
+	computeMode, exists := computeModes[mode]
+	if !exists {
+		return fmt.Errorf("unknown compute mode: %v", mode)
+	}
 
-		// In order for nvidia-smi to run, we need update LD_PRELOAD to include the path to libnvidia-ml.so.1.
-		cmd.Env = setOrOverrideEnvvar(os.Environ(), "LD_PRELOAD", prependPathListEnvvar("LD_PRELOAD", l.driverLibraryPath))
+	if err := l.Init(); err != nil {
+		return err
+	}
+	defer l.alwaysShutdown()
 
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			klog.Errorf("\n%v", string(output))
-			return fmt.Errorf("error running nvidia-smi: %w", err)
+	for _, uuid := range uuids {
+		device, ret := l.nvmllib.DeviceGetHandleByUUID(uuid)
+		if ret != nvml.SUCCESS {
+			return &NvmlError{Op: "getting device handle", UUID: uuid, Return: ret}
+		}
+		if ret := device.SetComputeMode(computeMode); ret != nvml.SUCCESS {
+			return &NvmlError{Op: "setting compute mode", UUID: uuid, Return: ret}
 		}
 	}
 	return nil
 }
 
-// TODO: Reenable dynamic MIG functionality once it is supported in Kubernetes 1.32
-//
-// func (l deviceLib) createMigDevice(gpu *GpuInfo, profile nvdev.MigProfile, placement *nvml.GpuInstancePlacement) (*MigDeviceInfo, error) {
-// 	if err := l.Init(); err != nil {
-// 		return nil, err
-// 	}
-// 	defer l.alwaysShutdown()
-//
-// 	profileInfo := profile.GetInfo()
-//
-// 	device, ret := l.nvmllib.DeviceGetHandleByUUID(gpu.UUID)
-// 	if ret != nvml.SUCCESS {
-// 		return nil, fmt.Errorf("error getting GPU device handle: %v", ret)
-// 	}
-//
-// 	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(profileInfo.GIProfileID)
-// 	if ret != nvml.SUCCESS {
-// 		return nil, fmt.Errorf("error getting GPU instance profile info for '%v': %v", profile, ret)
-// 	}
-//
-// 	gi, ret := device.CreateGpuInstanceWithPlacement(&giProfileInfo, placement)
-// 	if ret != nvml.SUCCESS {
-// 		return nil, fmt.Errorf("error creating GPU instance for '%v': %v", profile, ret)
-// 	}
-//
-// 	giInfo, ret := gi.GetInfo()
-// 	if ret != nvml.SUCCESS {
-// 		return nil, fmt.Errorf("error getting GPU instance info for '%v': %v", profile, ret)
-// 	}
-//
-// 	ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(profileInfo.CIProfileID, profileInfo.CIEngProfileID)
-// 	if ret != nvml.SUCCESS {
-// 		return nil, fmt.Errorf("error getting Compute instance profile info for '%v': %v", profile, ret)
-// 	}
-//
-// 	ci, ret := gi.CreateComputeInstance(&ciProfileInfo)
-// 	if ret != nvml.SUCCESS {
-// 		return nil, fmt.Errorf("error creating Compute instance for '%v': %v", profile, ret)
-// 	}
-//
-// 	ciInfo, ret := ci.GetInfo()
-// 	if ret != nvml.SUCCESS {
-// 		return nil, fmt.Errorf("error getting GPU instance info for '%v': %v", profile, ret)
-// 	}
-//
-// 	uuid := ""
-// 	err := walkMigDevices(device, func(i int, migDevice nvml.Device) error {
-// 		giID, ret := migDevice.GetGpuInstanceId()
-// 		if ret != nvml.SUCCESS {
-// 			return fmt.Errorf("error getting GPU instance ID for MIG device: %v", ret)
-// 		}
-// 		ciID, ret := migDevice.GetComputeInstanceId()
-// 		if ret != nvml.SUCCESS {
-// 			return fmt.Errorf("error getting Compute instance ID for MIG device: %v", ret)
-// 		}
-// 		if giID != int(giInfo.Id) || ciID != int(ciInfo.Id) {
-// 			return nil
-// 		}
-// 		uuid, ret = migDevice.GetUUID()
-// 		if ret != nvml.SUCCESS {
-// 			return fmt.Errorf("error getting UUID for MIG device: %v", ret)
-// 		}
-// 		return nil
-// 	})
-// 	if err != nil {
-// 		return nil, fmt.Errorf("error processing MIG device for GI and CI just created: %w", err)
-// 	}
-// 	if uuid == "" {
-// 		return nil, fmt.Errorf("unable to find MIG device for GI and CI just created")
-// 	}
-//
-// 	migInfo := &MigDeviceInfo{
-// 		UUID:    uuid,
-// 		parent:  gpu,
-// 		profile: profile,
-// 		giInfo:  &giInfo,
-// 		ciInfo:  &ciInfo,
-// 	}
-//
-// 	return migInfo, nil
-// }
-//
-// func (l deviceLib) deleteMigDevice(mig *MigDeviceInfo) error {
-// 	if err := l.Init(); err != nil {
-// 		return err
-// 	}
-// 	defer l.alwaysShutdown()
+// getComputeMode returns the compute mode of a GPU, named as accepted by
+// setComputeMode.
+func (l deviceLib) getComputeMode(uuid string) (string, error) {
+	if err := l.Init(); err != nil {
+		return "", err
+	}
+	defer l.alwaysShutdown()
+
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(uuid)
+	if ret != nvml.SUCCESS {
+		return "", &NvmlError{Op: "getting device handle", UUID: uuid, Return: ret}
+	}
+	computeMode, ret := device.GetComputeMode()
+	if ret != nvml.SUCCESS {
+		return "", &NvmlError{Op: "getting compute mode", UUID: uuid, Return: ret}
+	}
+
+	for mode, m := range computeModes {
+		if m == computeMode {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unexpected compute mode: %v", computeMode)
+}
+
+// getMigPlacements returns a MIG device for every possible placement of every
+// MIG profile supported by a GPU. None of these devices exist until they are
+// created by createMigDevice.
 //
-// 	parent, ret := l.nvmllib.DeviceGetHandleByUUID(mig.parent.UUID)
-// 	if ret != nvml.SUCCESS {
-// 		return fmt.Errorf("error getting device from UUID '%v': %v", mig.parent.UUID, ret)
-// 	}
-// 	gi, ret := parent.GetGpuInstanceById(int(mig.giInfo.Id))
-// 	if ret != nvml.SUCCESS {
-// 		return fmt.Errorf("error getting GPU instance ID for MIG device: %v", ret)
-// 	}
-// 	ci, ret := gi.GetComputeInstanceById(int(mig.ciInfo.Id))
-// 	if ret != nvml.SUCCESS {
-// 		return fmt.Errorf("error getting Compute instance ID for MIG device: %v", ret)
-// 	}
-// 	ret = ci.Destroy()
-// 	if ret != nvml.SUCCESS {
-// 		return fmt.Errorf("error destroying Compute Instance: %v", ret)
-// 	}
-// 	ret = gi.Destroy()
-// 	if ret != nvml.SUCCESS {
-// 		return fmt.Errorf("error destroying GPU Instance: %v", ret)
-// 	}
-// 	return nil
-// }
+// Overlapping placements are all published, each advertising the memory
+// slices it occupies. If overlapping placements end up being allocated to
+// different claims, creating the second one fails when its claim is prepared.
+func (l deviceLib) getMigPlacements(gpuInfo *GpuInfo) (map[string]*MigDeviceInfo, error) {
+	if !gpuInfo.migEnabled {
+		return nil, nil
+	}
+
+	if err := l.Init(); err != nil {
+		return nil, err
+	}
+	defer l.alwaysShutdown()
+
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(gpuInfo.UUID)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("error getting GPU device handle: %v", ret)
+	}
+
+	migInfos := make(map[string]*MigDeviceInfo)
+	for _, profile := range gpuInfo.migProfiles {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(profile.profile.GetInfo().GIProfileID)
+		if ret != nvml.SUCCESS {
+			return nil, fmt.Errorf("error getting GPU instance profile info for '%v': %v", profile, ret)
+		}
+		for _, placement := range profile.placements {
+			migInfo := &MigDeviceInfo{
+				profile:       profile.String(),
+				parent:        gpuInfo,
+				placement:     placement,
+				giProfileInfo: &giProfileInfo,
+				profileInfo:   profile,
+				dynamic:       true,
+			}
+			migInfos[migInfo.CanonicalName()] = migInfo
+		}
+	}
+
+	return migInfos, nil
+}
+
+// createMigDevice creates the GPU and compute instance backing a dynamic MIG
+// device at its placement.
+func (l deviceLib) createMigDevice(mig *MigDeviceInfo) (*MigDeviceInfo, error) {
+	if err := l.Init(); err != nil {
+		return nil, err
+	}
+	defer l.alwaysShutdown()
+
+	profile := mig.profileInfo.profile
+	profileInfo := profile.GetInfo()
+
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(mig.parent.UUID)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("error getting GPU device handle: %v", ret)
+	}
+
+	giProfileInfo, ret := device.GetGpuInstanceProfileInfo(profileInfo.GIProfileID)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("error getting GPU instance profile info for '%v': %v", profile, ret)
+	}
+
+	gi, ret := device.CreateGpuInstanceWithPlacement(&giProfileInfo, &mig.placement.GpuInstancePlacement)
+	if ret != nvml.SUCCESS {
+		return nil, fmt.Errorf("error creating GPU instance for '%v': %v", profile, ret)
+	}
+
+	giInfo, ret := gi.GetInfo()
+	if ret != nvml.SUCCESS {
+		_ = gi.Destroy()
+		return nil, fmt.Errorf("error getting GPU instance info for '%v': %v", profile, ret)
+	}
+
+	ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(profileInfo.CIProfileID, profileInfo.CIEngProfileID)
+	if ret != nvml.SUCCESS {
+		_ = gi.Destroy()
+		return nil, fmt.Errorf("error getting Compute instance profile info for '%v': %v", profile, ret)
+	}
+
+	ci, ret := gi.CreateComputeInstance(&ciProfileInfo)
+	if ret != nvml.SUCCESS {
+		_ = gi.Destroy()
+		return nil, fmt.Errorf("error creating Compute instance for '%v': %v", profile, ret)
+	}
+
+	ciInfo, ret := ci.GetInfo()
+	if ret != nvml.SUCCESS {
+		_ = ci.Destroy()
+		_ = gi.Destroy()
+		return nil, fmt.Errorf("error getting Compute instance info for '%v': %v", profile, ret)
+	}
+
+	uuid := ""
+	index := -1
+	err := walkMigDevices(device, func(i int, migDevice nvml.Device) error {
+		giID, ret := migDevice.GetGpuInstanceId()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting GPU instance ID for MIG device: %v", ret)
+		}
+		ciID, ret := migDevice.GetComputeInstanceId()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting Compute instance ID for MIG device: %v", ret)
+		}
+		if giID != int(giInfo.Id) || ciID != int(ciInfo.Id) {
+			return nil
+		}
+		uuid, ret = migDevice.GetUUID()
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting UUID for MIG device: %v", ret)
+		}
+		index = i
+		return nil
+	})
+	if err == nil && uuid == "" {
+		err = fmt.Errorf("unable to find MIG device for GI and CI just created")
+	}
+	if err != nil {
+		_ = ci.Destroy()
+		_ = gi.Destroy()
+		return nil, fmt.Errorf("error processing MIG device for GI and CI just created: %w", err)
+	}
+
+	migInfo := &MigDeviceInfo{
+		UUID:          uuid,
+		index:         index,
+		profile:       mig.profile,
+		parent:        mig.parent,
+		placement:     mig.placement,
+		giProfileInfo: &giProfileInfo,
+		giInfo:        &giInfo,
+		ciProfileInfo: &ciProfileInfo,
+		ciInfo:        &ciInfo,
+		profileInfo:   mig.profileInfo,
+		dynamic:       true,
+	}
+
+	return migInfo, nil
+}
+
+// deleteMigDevice destroys the compute and GPU instance of a dynamic MIG
+// device. Instances that no longer exist are ignored, so that a partially
+// completed deletion can be retried.
+func (l deviceLib) deleteMigDevice(instance *MigInstance) error {
+	if err := l.Init(); err != nil {
+		return err
+	}
+	defer l.alwaysShutdown()
+
+	parent, ret := l.nvmllib.DeviceGetHandleByUUID(instance.ParentUUID)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("error getting device from UUID '%v': %v", instance.ParentUUID, ret)
+	}
+	gi, ret := parent.GetGpuInstanceById(instance.GpuInstanceID)
+	if ret == nvml.ERROR_NOT_FOUND || ret == nvml.ERROR_INVALID_ARGUMENT {
+		return nil
+	}
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("error getting GPU instance for MIG device: %v", ret)
+	}
+	ci, ret := gi.GetComputeInstanceById(instance.ComputeInstanceID)
+	switch ret {
+	case nvml.SUCCESS:
+		if ret := ci.Destroy(); ret != nvml.SUCCESS {
+			return fmt.Errorf("error destroying Compute Instance: %v", ret)
+		}
+	case nvml.ERROR_NOT_FOUND, nvml.ERROR_INVALID_ARGUMENT:
+	default:
+		return fmt.Errorf("error getting Compute instance for MIG device: %v", ret)
+	}
+	if ret := gi.Destroy(); ret != nvml.SUCCESS {
+		return fmt.Errorf("error destroying GPU Instance: %v", ret)
+	}
+	return nil
+}
+
+// deleteUnknownMigDevices destroys all GPU instances, and the compute
+// instances within them, on a GPU whose IDs are not in the known set. It is
+// used to clean up MIG devices left behind by a crash between creating them
+// and recording them in the checkpoint.
+func (l deviceLib) deleteUnknownMigDevices(gpuInfo *GpuInfo, known sets.Set[int]) error {
+	if err := l.Init(); err != nil {
+		return err
+	}
+	defer l.alwaysShutdown()
+
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(gpuInfo.UUID)
+	if ret != nvml.SUCCESS {
+		return fmt.Errorf("error getting GPU device handle: %v", ret)
+	}
+
+	for i := 0; i < nvml.GPU_INSTANCE_PROFILE_COUNT; i++ {
+		giProfileInfo, ret := device.GetGpuInstanceProfileInfo(i)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error retrieving GpuInstanceProfileInfo for profile %d on GPU %v: %v", i, gpuInfo.UUID, ret)
+		}
+		gis, ret := device.GetGpuInstances(&giProfileInfo)
+		if ret != nvml.SUCCESS {
+			return fmt.Errorf("error getting GPU instances for profile %d on GPU %v: %v", i, gpuInfo.UUID, ret)
+		}
+		for _, gi := range gis {
+			giInfo, ret := gi.GetInfo()
+			if ret != nvml.SUCCESS {
+				return fmt.Errorf("error getting GPU instance info: %v", ret)
+			}
+			if known.Has(int(giInfo.Id)) {
+				continue
+			}
+			if err := destroyComputeInstances(gi); err != nil {
+				return fmt.Errorf("error destroying Compute instances of GPU instance %d on GPU %v: %w", giInfo.Id, gpuInfo.UUID, err)
+			}
+			if ret := gi.Destroy(); ret != nvml.SUCCESS {
+				return fmt.Errorf("error destroying GPU instance %d on GPU %v: %v", giInfo.Id, gpuInfo.UUID, ret)
+			}
+			klog.Infof("Deleted unknown MIG GPU instance %d on GPU %v", giInfo.Id, gpuInfo.UUID)
+		}
+	}
+	return nil
+}
+
+func destroyComputeInstances(gi nvml.GpuInstance) error {
+	for j := 0; j < nvml.COMPUTE_INSTANCE_PROFILE_COUNT; j++ {
+		for k := 0; k < nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_COUNT; k++ {
+			ciProfileInfo, ret := gi.GetComputeInstanceProfileInfo(j, k)
+			if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+				continue
+			}
+			if ret != nvml.SUCCESS {
+				return fmt.Errorf("error getting Compute instance profile info for profile %d: %v", j, ret)
+			}
+			cis, ret := gi.GetComputeInstances(&ciProfileInfo)
+			if ret != nvml.SUCCESS {
+				return fmt.Errorf("error getting Compute instances for profile %d: %v", j, ret)
+			}
+			for _, ci := range cis {
+				if ret := ci.Destroy(); ret != nvml.SUCCESS {
+					return fmt.Errorf("error destroying Compute instance: %v", ret)
+				}
+			}
+		}
+	}
+	return nil
+}
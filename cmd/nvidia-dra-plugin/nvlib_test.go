@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNvml struct {
+	nvml.Interface
+	devices map[string]*fakeNvmlDevice
+}
+
+type fakeNvmlDevice struct {
+	nvml.Device
+	computeMode nvml.ComputeMode
+	setReturn   nvml.Return
+}
+
+func (f *fakeNvml) Init() nvml.Return {
+	return nvml.SUCCESS
+}
+
+func (f *fakeNvml) Shutdown() nvml.Return {
+	return nvml.SUCCESS
+}
+
+func (f *fakeNvml) DeviceGetHandleByUUID(uuid string) (nvml.Device, nvml.Return) {
+	device, exists := f.devices[uuid]
+	if !exists {
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+	return device, nvml.SUCCESS
+}
+
+func (d *fakeNvmlDevice) GetComputeMode() (nvml.ComputeMode, nvml.Return) {
+	return d.computeMode, nvml.SUCCESS
+}
+
+func (d *fakeNvmlDevice) SetComputeMode(mode nvml.ComputeMode) nvml.Return {
+	if d.setReturn != nvml.SUCCESS {
+		return d.setReturn
+	}
+	d.computeMode = mode
+	return nvml.SUCCESS
+}
+
+func TestComputeMode(t *testing.T) {
+	testCases := []struct {
+		description    string
+		uuids          []string
+		mode           string
+		setReturn      nvml.Return
+		expectedMode   string
+		expectedReturn nvml.Return
+		expectedError  bool
+	}{
+		{
+			description:  "set exclusive process",
+			uuids:        []string{"GPU-0"},
+			mode:         "EXCLUSIVE_PROCESS",
+			expectedMode: "EXCLUSIVE_PROCESS",
+		},
+		{
+			description:   "unknown mode",
+			uuids:         []string{"GPU-0"},
+			mode:          "EXCLUSIVE_THREAD",
+			expectedMode:  "DEFAULT",
+			expectedError: true,
+		},
+		{
+			description:    "unknown GPU",
+			uuids:          []string{"GPU-1"},
+			mode:           "PROHIBITED",
+			expectedMode:   "DEFAULT",
+			expectedReturn: nvml.ERROR_NOT_FOUND,
+			expectedError:  true,
+		},
+		{
+			description:    "insufficient permissions",
+			uuids:          []string{"GPU-0"},
+			mode:           "PROHIBITED",
+			setReturn:      nvml.ERROR_NO_PERMISSION,
+			expectedMode:   "DEFAULT",
+			expectedReturn: nvml.ERROR_NO_PERMISSION,
+			expectedError:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			l := deviceLib{
+				nvmllib: &fakeNvml{
+					devices: map[string]*fakeNvmlDevice{
+						"GPU-0": {computeMode: nvml.COMPUTEMODE_DEFAULT, setReturn: tc.setReturn},
+					},
+				},
+			}
+
+			err := l.setComputeMode(tc.uuids, tc.mode)
+			if tc.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			var nvmlErr *NvmlError
+			if tc.expectedReturn != nvml.SUCCESS {
+				require.True(t, errors.As(err, &nvmlErr))
+				require.Equal(t, tc.expectedReturn, nvmlErr.Return)
+			}
+
+			mode, err := l.getComputeMode("GPU-0")
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedMode, mode)
+		})
+	}
+}
+
+func TestSetTimeSliceWithoutNvidiaSMI(t *testing.T) {
+	err := deviceLib{}.setTimeSlice([]string{"GPU-0"}, 0)
+	require.ErrorIs(t, err, ErrTimeSliceNotSupported)
+}
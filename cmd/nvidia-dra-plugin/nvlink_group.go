@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+)
+
+// NvlinkGroupInfo is a set of full GPUs that are all connected to each other
+// over NVLink, allocatable as a single device.
+type NvlinkGroupInfo struct {
+	Gpus []*GpuInfo `json:"gpus"`
+}
+
+func (d *NvlinkGroupInfo) CanonicalName() string {
+	return fmt.Sprintf("nvlink-group-%d-%d", d.Gpus[0].index, d.Gpus[len(d.Gpus)-1].index)
+}
+
+// CanonicalIndex returns the indexes of the member GPUs, separated by commas.
+func (d *NvlinkGroupInfo) CanonicalIndex() string {
+	var indexes []string
+	for _, gpu := range d.Gpus {
+		indexes = append(indexes, gpu.CanonicalIndex())
+	}
+	return strings.Join(indexes, ",")
+}
+
+// UUIDs returns the sorted UUIDs of the member GPUs.
+func (d *NvlinkGroupInfo) UUIDs() []string {
+	var uuids []string
+	for _, gpu := range d.Gpus {
+		uuids = append(uuids, gpu.UUID)
+	}
+	slices.Sort(uuids)
+	return uuids
+}
+
+// GetDevice returns the device of an NVLink group.
+//
+// Until devices can consume counters shared with other devices, the
+// scheduler has no way of knowing that a group overlaps its member GPUs and
+// the groups sharing them. It may allocate both to different claims, which
+// are then rejected when they are prepared. For this reason NVLink groups
+// are only published when the nvlink-group device class is enabled.
+func (d *NvlinkGroupInfo) GetDevice() resourceapi.Device {
+	first := d.Gpus[0]
+	var memoryBytes uint64
+	for _, gpu := range d.Gpus {
+		memoryBytes += gpu.memoryBytes
+	}
+
+	device := resourceapi.Device{
+		Name: d.CanonicalName(),
+		Basic: &resourceapi.BasicDevice{
+			Attributes: map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+				"type": {
+					StringValue: ptr.To(NvlinkGroupDeviceType),
+				},
+				"gpuCount": {
+					IntValue: ptr.To(int64(len(d.Gpus))),
+				},
+				"productName": {
+					StringValue: &first.productName,
+				},
+				"brand": {
+					StringValue: &first.brand,
+				},
+				"architecture": {
+					StringValue: &first.architecture,
+				},
+				"cudaComputeCapability": {
+					VersionValue: ptr.To(semver.MustParse(first.cudaComputeCapability).String()),
+				},
+				"driverVersion": {
+					VersionValue: ptr.To(semver.MustParse(first.driverVersion).String()),
+				},
+				"cudaDriverVersion": {
+					VersionValue: ptr.To(semver.MustParse(first.cudaDriverVersion).String()),
+				},
+			},
+			Capacity: map[resourceapi.QualifiedName]resourceapi.DeviceCapacity{
+				"memory": {
+					Value: *resource.NewQuantity(int64(memoryBytes), resource.BinarySI),
+				},
+			},
+		},
+	}
+	return device
+}
+
+// getNvlinkGroups returns the groups of full GPUs that are all connected to
+// each other over NVLink. Like MIG placements, groups are aligned: a group of
+// n GPUs, with n a power of two, spans the GPUs with indexes k*n to k*n+n-1
+// for some k. All members must be full GPUs of the same product.
+func (l deviceLib) getNvlinkGroups(gpus []*GpuInfo) ([]*NvlinkGroupInfo, error) {
+	byIndex := make(map[int]*GpuInfo)
+	maxIndex := -1
+	for _, gpu := range gpus {
+		byIndex[gpu.index] = gpu
+		maxIndex = max(maxIndex, gpu.index)
+	}
+
+	connected := make(map[[2]int]bool)
+	isConnected := func(a, b *GpuInfo) (bool, error) {
+		key := [2]int{a.index, b.index}
+		if c, exists := connected[key]; exists {
+			return c, nil
+		}
+		c, err := l.isNvlinkConnected(a.UUID, b.UUID)
+		if err != nil {
+			return false, err
+		}
+		connected[key] = c
+		return c, nil
+	}
+
+	var groups []*NvlinkGroupInfo
+	for size := 2; size <= maxIndex+1; size *= 2 {
+		for start := 0; start+size <= maxIndex+1; start += size {
+			group := &NvlinkGroupInfo{}
+			for i := start; i < start+size; i++ {
+				gpu := byIndex[i]
+				if gpu == nil || gpu.migEnabled || gpu.productName != byIndex[start].productName {
+					group = nil
+					break
+				}
+				group.Gpus = append(group.Gpus, gpu)
+			}
+			if group == nil {
+				continue
+			}
+			allConnected, err := allPairs(group.Gpus, isConnected)
+			if err != nil {
+				return nil, err
+			}
+			if allConnected {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// allPairs reports whether f holds for every pair of distinct GPUs.
+func allPairs(gpus []*GpuInfo, f func(a, b *GpuInfo) (bool, error)) (bool, error) {
+	for i := range gpus {
+		for j := i + 1; j < len(gpus); j++ {
+			ok, err := f(gpus[i], gpus[j])
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// isNvlinkConnected reports whether two GPUs can access each other's memory
+// over NVLink.
+func (l deviceLib) isNvlinkConnected(uuid1, uuid2 string) (bool, error) {
+	device1, ret := l.nvmllib.DeviceGetHandleByUUID(uuid1)
+	if ret != nvml.SUCCESS {
+		return false, fmt.Errorf("error getting device handle for GPU %v: %v", uuid1, ret)
+	}
+	device2, ret := l.nvmllib.DeviceGetHandleByUUID(uuid2)
+	if ret != nvml.SUCCESS {
+		return false, fmt.Errorf("error getting device handle for GPU %v: %v", uuid2, ret)
+	}
+	status, ret := device1.GetP2PStatus(device2, nvml.P2P_CAPS_INDEX_NVLINK)
+	if ret == nvml.ERROR_NOT_SUPPORTED {
+		return false, nil
+	}
+	if ret != nvml.SUCCESS {
+		return false, fmt.Errorf("error getting NVLink P2P status between GPUs %v and %v: %v", uuid1, uuid2, ret)
+	}
+	return status == nvml.P2P_STATUS_OK, nil
+}
@@ -0,0 +1,78 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestNvlinkGroups(t *testing.T) {
+	dir := t.TempDir()
+	topologyPath := filepath.Join(dir, "topology.yaml")
+	topology := `
+gpus:
+- model: A100-SXM4-80GB
+  count: 4
+  nvlink: true
+- model: A100-SXM4-80GB
+  count: 2
+  nvlink: true
+  migEnabled: true
+- model: A100-SXM4-80GB
+  count: 2
+`
+	require.NoError(t, os.WriteFile(topologyPath, []byte(topology), 0644))
+
+	l, err := newMockDeviceLib(root(filepath.Join(dir, "driver")), topologyPath)
+	require.NoError(t, err)
+
+	config := &Config{
+		flags: &Flags{
+			deviceClasses: sets.New(GpuDeviceType, NvlinkGroupDeviceType),
+		},
+	}
+	allocatable, err := l.enumerateAllPossibleDevices(config)
+	require.NoError(t, err)
+
+	var groups []string
+	for name, device := range allocatable {
+		if device.Type() == NvlinkGroupDeviceType {
+			groups = append(groups, name)
+		}
+	}
+	// GPUs 4 and 5 are in MIG mode and GPUs 6 and 7 have no NVLinks.
+	require.ElementsMatch(t, []string{
+		"nvlink-group-0-1",
+		"nvlink-group-2-3",
+		"nvlink-group-0-3",
+	}, groups)
+
+	group := allocatable["nvlink-group-0-3"]
+	require.Equal(t, "0,1,2,3", group.CanonicalIndex())
+	require.Len(t, group.NvlinkGroup.UUIDs(), 4)
+	require.Subset(t, allocatable.GpuUUIDs(), group.NvlinkGroup.UUIDs())
+
+	device := group.GetDevice()
+	require.Equal(t, int64(4), *device.Basic.Attributes["gpuCount"].IntValue)
+}
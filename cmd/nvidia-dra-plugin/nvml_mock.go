@@ -0,0 +1,782 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/google/uuid"
+)
+
+// mockUUIDNamespace is the namespace of the UUIDs given to mock devices, so
+// that they are stable across restarts of the plugin.
+var mockUUIDNamespace = uuid.MustParse("8e2e0a8c-3c4a-4b5e-9b1f-6d0a6c1e2f10")
+
+// mockMaxMigDevices is the maximum number of MIG devices on a mock GPU.
+const mockMaxMigDevices = 7
+
+// The power limits, in milliwatts, and clocks, in MHz, of all mock GPUs.
+const (
+	mockMinPowerLimit        = 100000
+	mockMaxPowerLimit        = 400000
+	mockMaxGraphicsClock     = 1980
+	mockMaxMemoryClock       = 1593
+	mockDefaultGraphicsClock = 1410
+)
+
+// mockNvml is an in-memory implementation of the subset of NVML used by the
+// plugin, serving the GPUs of a MockTopology. Calls outside that subset
+// panic.
+type mockNvml struct {
+	nvml.Interface
+	driverVersion     string
+	cudaDriverVersion int
+	devices           []*mockDevice
+}
+
+type mockDevice struct {
+	nvml.Device
+	sync.Mutex
+	index       int
+	uuid        string
+	model       mockGpuModel
+	migEnabled  bool
+	computeMode nvml.ComputeMode
+	// powerLimit is in milliwatts, clocks are in MHz.
+	powerLimit           uint32
+	applicationClocks    [2]uint32
+	lockedGraphicsClocks [2]uint32
+	// processes are the PIDs of the compute processes running on the GPU
+	// outside of its MIG devices.
+	processes []uint32
+	// nvlinkDomain is the GPU group whose GPUs are all connected to each
+	// other over NVLink, or -1 if the GPU has no NVLinks.
+	nvlinkDomain int
+	gpuInstances map[uint32]*mockGpuInstance
+	migDevices   [mockMaxMigDevices]*mockMigDevice
+}
+
+type mockGpuInstance struct {
+	nvml.GpuInstance
+	device           *mockDevice
+	info             nvml.GpuInstanceInfo
+	profile          mockGpuInstanceProfile
+	computeInstances map[uint32]*mockComputeInstance
+}
+
+type mockComputeInstance struct {
+	nvml.ComputeInstance
+	gpuInstance *mockGpuInstance
+	info        nvml.ComputeInstanceInfo
+}
+
+// mockMigDevice is the device handle of a compute instance.
+type mockMigDevice struct {
+	nvml.Device
+	uuid            string
+	computeInstance *mockComputeInstance
+	// processes is guarded by the lock of the parent device.
+	processes []uint32
+}
+
+type mockEventSet struct {
+	nvml.EventSet
+}
+
+type mockExtendedInterface struct {
+	nvml.ExtendedInterface
+}
+
+var _ nvml.Interface = (*mockNvml)(nil)
+var _ nvml.Device = (*mockDevice)(nil)
+var _ nvml.Device = (*mockMigDevice)(nil)
+var _ nvml.GpuInstance = (*mockGpuInstance)(nil)
+var _ nvml.ComputeInstance = (*mockComputeInstance)(nil)
+
+// newMockNvml builds a mock NVML library serving the GPUs of a topology, with
+// the MIG devices listed in the topology already created.
+func newMockNvml(topology *MockTopology) (*mockNvml, error) {
+	cudaDriverVersion, err := topology.cudaDriverVersion()
+	if err != nil {
+		return nil, err
+	}
+	m := &mockNvml{
+		driverVersion:     topology.driverVersion(),
+		cudaDriverVersion: cudaDriverVersion,
+	}
+	for g, group := range topology.Gpus {
+		for i := 0; i < group.count(); i++ {
+			index := len(m.devices)
+			gpuUUID := "GPU-" + uuid.NewSHA1(mockUUIDNamespace, []byte(fmt.Sprintf("gpu-%d", index))).String()
+			device := newMockDevice(index, gpuUUID, mockGpuModels[group.Model], group.migEnabled())
+			if group.NvLink {
+				device.nvlinkDomain = g
+			}
+			for _, profile := range group.MigDevices {
+				if _, err := device.createMigDevice(profile); err != nil {
+					return nil, fmt.Errorf("error creating MIG device on GPU %d: %w", index, err)
+				}
+			}
+			m.devices = append(m.devices, device)
+		}
+	}
+	return m, nil
+}
+
+func newMockDevice(index int, uuid string, model mockGpuModel, migEnabled bool) *mockDevice {
+	return &mockDevice{
+		index:       index,
+		uuid:        uuid,
+		model:       model,
+		migEnabled:  migEnabled,
+		computeMode: nvml.COMPUTEMODE_DEFAULT,
+		powerLimit:  mockMaxPowerLimit,
+		applicationClocks: [2]uint32{
+			mockMaxMemoryClock,
+			mockDefaultGraphicsClock,
+		},
+		nvlinkDomain: -1,
+		gpuInstances: make(map[uint32]*mockGpuInstance),
+	}
+}
+
+func (m *mockNvml) Init() nvml.Return {
+	return nvml.SUCCESS
+}
+
+func (m *mockNvml) Shutdown() nvml.Return {
+	return nvml.SUCCESS
+}
+
+func (m *mockNvml) Extensions() nvml.ExtendedInterface {
+	return mockExtendedInterface{}
+}
+
+func (m *mockNvml) SystemGetDriverVersion() (string, nvml.Return) {
+	return m.driverVersion, nvml.SUCCESS
+}
+
+func (m *mockNvml) SystemGetCudaDriverVersion() (int, nvml.Return) {
+	return m.cudaDriverVersion, nvml.SUCCESS
+}
+
+func (m *mockNvml) DeviceGetCount() (int, nvml.Return) {
+	return len(m.devices), nvml.SUCCESS
+}
+
+func (m *mockNvml) DeviceGetHandleByIndex(index int) (nvml.Device, nvml.Return) {
+	if index < 0 || index >= len(m.devices) {
+		return nil, nvml.ERROR_INVALID_ARGUMENT
+	}
+	return m.devices[index], nvml.SUCCESS
+}
+
+func (m *mockNvml) DeviceGetHandleByUUID(uuid string) (nvml.Device, nvml.Return) {
+	for _, device := range m.devices {
+		if device.uuid == uuid {
+			return device, nvml.SUCCESS
+		}
+		device.Lock()
+		for _, mig := range device.migDevices {
+			if mig != nil && mig.uuid == uuid {
+				device.Unlock()
+				return mig, nvml.SUCCESS
+			}
+		}
+		device.Unlock()
+	}
+	return nil, nvml.ERROR_NOT_FOUND
+}
+
+// startProcess adds a compute process to the GPU or MIG device with the
+// given UUID.
+func (m *mockNvml) startProcess(uuid string, pid uint32) nvml.Return {
+	device, ret := m.DeviceGetHandleByUUID(uuid)
+	if ret != nvml.SUCCESS {
+		return ret
+	}
+	switch device := device.(type) {
+	case *mockDevice:
+		device.Lock()
+		defer device.Unlock()
+		device.processes = append(device.processes, pid)
+	case *mockMigDevice:
+		parent := device.computeInstance.gpuInstance.device
+		parent.Lock()
+		defer parent.Unlock()
+		device.processes = append(device.processes, pid)
+	}
+	return nvml.SUCCESS
+}
+
+// killProcess removes a compute process from all GPUs and MIG devices.
+func (m *mockNvml) killProcess(pid uint32) error {
+	isPid := func(p uint32) bool { return p == pid }
+	for _, device := range m.devices {
+		device.Lock()
+		device.processes = slices.DeleteFunc(device.processes, isPid)
+		for _, mig := range device.migDevices {
+			if mig != nil {
+				mig.processes = slices.DeleteFunc(mig.processes, isPid)
+			}
+		}
+		device.Unlock()
+	}
+	return nil
+}
+
+func (m *mockNvml) EventSetCreate() (nvml.EventSet, nvml.Return) {
+	return mockEventSet{}, nvml.SUCCESS
+}
+
+func (m *mockNvml) ErrorString(ret nvml.Return) string {
+	return fmt.Sprintf("mock NVML error %d", int32(ret))
+}
+
+// LookupSymbol reports every symbol as present, as the mock does not depend
+// on the version of a driver library.
+func (e mockExtendedInterface) LookupSymbol(string) error {
+	return nil
+}
+
+// Wait never returns any events, as mock devices do not support any.
+func (e mockEventSet) Wait(timeout uint32) (nvml.EventData, nvml.Return) {
+	time.Sleep(time.Duration(timeout) * time.Millisecond)
+	return nvml.EventData{}, nvml.ERROR_TIMEOUT
+}
+
+func (e mockEventSet) Free() nvml.Return {
+	return nvml.SUCCESS
+}
+
+func (d *mockDevice) GetIndex() (int, nvml.Return) {
+	return d.index, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetMinorNumber() (int, nvml.Return) {
+	return d.index, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetUUID() (string, nvml.Return) {
+	return d.uuid, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetName() (string, nvml.Return) {
+	return d.model.productName, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetBrand() (nvml.BrandType, nvml.Return) {
+	return d.model.brand, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetArchitecture() (nvml.DeviceArchitecture, nvml.Return) {
+	return d.model.architecture, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetCudaComputeCapability() (int, int, nvml.Return) {
+	return d.model.cudaComputeCapability[0], d.model.cudaComputeCapability[1], nvml.SUCCESS
+}
+
+func (d *mockDevice) GetMemoryInfo() (nvml.Memory, nvml.Return) {
+	total := d.model.memoryMiB * 1024 * 1024
+	return nvml.Memory{Total: total, Free: total}, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetPciInfo() (nvml.PciInfo, nvml.Return) {
+	busID := fmt.Sprintf("00000000:%02x:00.0", d.index+1)
+	info := nvml.PciInfo{
+		Bus:         uint32(d.index + 1),
+		PciDeviceId: 0x20b010de,
+	}
+	for i, c := range busID {
+		info.BusId[i] = int8(c)
+	}
+	return info, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetP2PStatus(other nvml.Device, index nvml.GpuP2PCapsIndex) (nvml.GpuP2PStatus, nvml.Return) {
+	peer, ok := other.(*mockDevice)
+	if !ok {
+		return 0, nvml.ERROR_INVALID_ARGUMENT
+	}
+	if index != nvml.P2P_CAPS_INDEX_NVLINK {
+		return nvml.P2P_STATUS_OK, nvml.SUCCESS
+	}
+	if d.nvlinkDomain < 0 || d.nvlinkDomain != peer.nvlinkDomain {
+		return nvml.P2P_STATUS_NOT_SUPPORTED, nvml.SUCCESS
+	}
+	return nvml.P2P_STATUS_OK, nvml.SUCCESS
+}
+
+func (d *mockDevice) IsMigDeviceHandle() (bool, nvml.Return) {
+	return false, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetMigMode() (int, int, nvml.Return) {
+	if !d.model.migCapable {
+		return 0, 0, nvml.ERROR_NOT_SUPPORTED
+	}
+	if d.migEnabled {
+		return nvml.DEVICE_MIG_ENABLE, nvml.DEVICE_MIG_ENABLE, nvml.SUCCESS
+	}
+	return nvml.DEVICE_MIG_DISABLE, nvml.DEVICE_MIG_DISABLE, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetComputeMode() (nvml.ComputeMode, nvml.Return) {
+	d.Lock()
+	defer d.Unlock()
+	return d.computeMode, nvml.SUCCESS
+}
+
+func (d *mockDevice) SetComputeMode(mode nvml.ComputeMode) nvml.Return {
+	d.Lock()
+	defer d.Unlock()
+	d.computeMode = mode
+	return nvml.SUCCESS
+}
+
+func (d *mockDevice) GetPowerManagementLimit() (uint32, nvml.Return) {
+	d.Lock()
+	defer d.Unlock()
+	return d.powerLimit, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetPowerManagementLimitConstraints() (uint32, uint32, nvml.Return) {
+	return mockMinPowerLimit, mockMaxPowerLimit, nvml.SUCCESS
+}
+
+func (d *mockDevice) SetPowerManagementLimit(limit uint32) nvml.Return {
+	if limit < mockMinPowerLimit || limit > mockMaxPowerLimit {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.powerLimit = limit
+	return nvml.SUCCESS
+}
+
+func (d *mockDevice) GetMaxClockInfo(clockType nvml.ClockType) (uint32, nvml.Return) {
+	switch clockType {
+	case nvml.CLOCK_GRAPHICS, nvml.CLOCK_SM:
+		return mockMaxGraphicsClock, nvml.SUCCESS
+	case nvml.CLOCK_MEM:
+		return mockMaxMemoryClock, nvml.SUCCESS
+	}
+	return 0, nvml.ERROR_INVALID_ARGUMENT
+}
+
+func (d *mockDevice) GetApplicationsClock(clockType nvml.ClockType) (uint32, nvml.Return) {
+	d.Lock()
+	defer d.Unlock()
+	switch clockType {
+	case nvml.CLOCK_MEM:
+		return d.applicationClocks[0], nvml.SUCCESS
+	case nvml.CLOCK_GRAPHICS:
+		return d.applicationClocks[1], nvml.SUCCESS
+	}
+	return 0, nvml.ERROR_INVALID_ARGUMENT
+}
+
+func (d *mockDevice) SetApplicationsClocks(memoryClock uint32, graphicsClock uint32) nvml.Return {
+	if memoryClock > mockMaxMemoryClock || graphicsClock > mockMaxGraphicsClock {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.applicationClocks = [2]uint32{memoryClock, graphicsClock}
+	return nvml.SUCCESS
+}
+
+func (d *mockDevice) SetGpuLockedClocks(minClock uint32, maxClock uint32) nvml.Return {
+	if minClock > maxClock || maxClock > mockMaxGraphicsClock {
+		return nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.lockedGraphicsClocks = [2]uint32{minClock, maxClock}
+	return nvml.SUCCESS
+}
+
+func (d *mockDevice) ResetGpuLockedClocks() nvml.Return {
+	d.Lock()
+	defer d.Unlock()
+	d.lockedGraphicsClocks = [2]uint32{}
+	return nvml.SUCCESS
+}
+
+// GetComputeRunningProcesses returns the processes running on the GPU,
+// including those on its MIG devices.
+func (d *mockDevice) GetComputeRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	d.Lock()
+	defer d.Unlock()
+	var processes []nvml.ProcessInfo
+	for _, pid := range d.processes {
+		processes = append(processes, nvml.ProcessInfo{Pid: pid})
+	}
+	for _, mig := range d.migDevices {
+		if mig == nil {
+			continue
+		}
+		for _, pid := range mig.processes {
+			processes = append(processes, nvml.ProcessInfo{Pid: pid})
+		}
+	}
+	return processes, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetSupportedEventTypes() (uint64, nvml.Return) {
+	return 0, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetMaxMigDeviceCount() (int, nvml.Return) {
+	if !d.migEnabled {
+		return 0, nvml.SUCCESS
+	}
+	return mockMaxMigDevices, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetMigDeviceHandleByIndex(index int) (nvml.Device, nvml.Return) {
+	if index < 0 || index >= mockMaxMigDevices {
+		return nil, nvml.ERROR_INVALID_ARGUMENT
+	}
+	d.Lock()
+	defer d.Unlock()
+	if d.migDevices[index] == nil {
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+	return d.migDevices[index], nvml.SUCCESS
+}
+
+func (d *mockDevice) GetGpuInstanceProfileInfo(id int) (nvml.GpuInstanceProfileInfo, nvml.Return) {
+	if id < 0 || id >= nvml.GPU_INSTANCE_PROFILE_COUNT {
+		return nvml.GpuInstanceProfileInfo{}, nvml.ERROR_INVALID_ARGUMENT
+	}
+	profile, exists := getMockGpuInstanceProfile(id)
+	if !d.migEnabled || !exists {
+		return nvml.GpuInstanceProfileInfo{}, nvml.ERROR_NOT_SUPPORTED
+	}
+	return d.gpuInstanceProfileInfo(profile), nvml.SUCCESS
+}
+
+func (d *mockDevice) gpuInstanceProfileInfo(profile mockGpuInstanceProfile) nvml.GpuInstanceProfileInfo {
+	info := nvml.GpuInstanceProfileInfo{
+		Id:                  uint32(profile.id),
+		SliceCount:          profile.slices,
+		InstanceCount:       profile.instanceCount,
+		MultiprocessorCount: profile.slices * 14,
+		CopyEngineCount:     profile.slices,
+		MemorySizeMB:        d.model.memoryMiB * uint64(profile.memorySlices) / 8,
+	}
+	if profile.media {
+		info.DecoderCount = 1
+		info.JpegCount = 1
+		info.OfaCount = 1
+	}
+	return info
+}
+
+func (d *mockDevice) GetGpuInstancePossiblePlacements(info *nvml.GpuInstanceProfileInfo) ([]nvml.GpuInstancePlacement, nvml.Return) {
+	profile, exists := getMockGpuInstanceProfile(int(info.Id))
+	if !d.migEnabled || !exists {
+		return nil, nvml.ERROR_NOT_SUPPORTED
+	}
+	var placements []nvml.GpuInstancePlacement
+	for _, start := range profile.placementStarts {
+		placements = append(placements, nvml.GpuInstancePlacement{Start: start, Size: profile.memorySlices})
+	}
+	return placements, nvml.SUCCESS
+}
+
+func (d *mockDevice) CreateGpuInstanceWithPlacement(info *nvml.GpuInstanceProfileInfo, placement *nvml.GpuInstancePlacement) (nvml.GpuInstance, nvml.Return) {
+	profile, exists := getMockGpuInstanceProfile(int(info.Id))
+	if !d.migEnabled || !exists {
+		return nil, nvml.ERROR_NOT_SUPPORTED
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	if !slices.Contains(profile.placementStarts, placement.Start) || placement.Size != profile.memorySlices {
+		return nil, nvml.ERROR_INVALID_ARGUMENT
+	}
+	count := uint32(0)
+	for _, gi := range d.gpuInstances {
+		if gi.profile.id == profile.id {
+			count++
+		}
+		existing := gi.info.Placement
+		if placement.Start < existing.Start+existing.Size && existing.Start < placement.Start+placement.Size {
+			return nil, nvml.ERROR_INSUFFICIENT_RESOURCES
+		}
+	}
+	if count >= profile.instanceCount {
+		return nil, nvml.ERROR_INSUFFICIENT_RESOURCES
+	}
+
+	id := uint32(1)
+	for d.gpuInstances[id] != nil {
+		id++
+	}
+	gi := &mockGpuInstance{
+		device:           d,
+		profile:          profile,
+		computeInstances: make(map[uint32]*mockComputeInstance),
+	}
+	gi.info = nvml.GpuInstanceInfo{
+		Device:    d,
+		Id:        id,
+		ProfileId: uint32(profile.id),
+		Placement: *placement,
+	}
+	d.gpuInstances[id] = gi
+	return gi, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetGpuInstanceById(id int) (nvml.GpuInstance, nvml.Return) {
+	d.Lock()
+	defer d.Unlock()
+	gi, exists := d.gpuInstances[uint32(id)]
+	if !exists {
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+	return gi, nvml.SUCCESS
+}
+
+func (d *mockDevice) GetGpuInstances(info *nvml.GpuInstanceProfileInfo) ([]nvml.GpuInstance, nvml.Return) {
+	d.Lock()
+	defer d.Unlock()
+	var gis []nvml.GpuInstance
+	for _, gi := range d.gpuInstances {
+		if gi.info.ProfileId == info.Id {
+			gis = append(gis, gi)
+		}
+	}
+	return gis, nvml.SUCCESS
+}
+
+// createMigDevice creates a MIG device of the named profile, e.g. 3g.40gb, at
+// the first free placement, with a single compute instance spanning it.
+func (d *mockDevice) createMigDevice(name string) (*mockMigDevice, error) {
+	for _, profile := range mockGpuInstanceProfiles {
+		if d.migProfileName(profile) != name {
+			continue
+		}
+		info := d.gpuInstanceProfileInfo(profile)
+		for _, start := range profile.placementStarts {
+			placement := nvml.GpuInstancePlacement{Start: start, Size: profile.memorySlices}
+			gi, ret := d.CreateGpuInstanceWithPlacement(&info, &placement)
+			if ret == nvml.ERROR_INSUFFICIENT_RESOURCES {
+				continue
+			}
+			if ret != nvml.SUCCESS {
+				return nil, fmt.Errorf("error creating GPU instance for %v: %v", name, ret)
+			}
+			ciInfo, ret := gi.GetComputeInstanceProfileInfo(mockComputeInstanceProfiles[profile.slices], nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED)
+			if ret != nvml.SUCCESS {
+				return nil, fmt.Errorf("error getting compute instance profile for %v: %v", name, ret)
+			}
+			ci, ret := gi.CreateComputeInstance(&ciInfo)
+			if ret != nvml.SUCCESS {
+				return nil, fmt.Errorf("error creating compute instance for %v: %v", name, ret)
+			}
+			return ci.(*mockComputeInstance).migDevice(), nil
+		}
+		return nil, fmt.Errorf("no free placement for MIG device %v", name)
+	}
+	return nil, fmt.Errorf("unknown MIG profile %q", name)
+}
+
+// migProfileName returns the name of a GPU instance profile with a compute
+// instance spanning it, e.g. 3g.40gb.
+func (d *mockDevice) migProfileName(profile mockGpuInstanceProfile) string {
+	memoryGB := (d.model.memoryMiB + 1023) / 1024 * uint64(profile.memorySlices) / 8
+	name := fmt.Sprintf("%dg.%dgb", profile.slices, memoryGB)
+	if profile.media {
+		name += "+me"
+	}
+	return name
+}
+
+func (gi *mockGpuInstance) GetInfo() (nvml.GpuInstanceInfo, nvml.Return) {
+	return gi.info, nvml.SUCCESS
+}
+
+func (gi *mockGpuInstance) GetComputeInstanceProfileInfo(id int, engineID int) (nvml.ComputeInstanceProfileInfo, nvml.Return) {
+	if id < 0 || id >= nvml.COMPUTE_INSTANCE_PROFILE_COUNT {
+		return nvml.ComputeInstanceProfileInfo{}, nvml.ERROR_INVALID_ARGUMENT
+	}
+	if engineID != nvml.COMPUTE_INSTANCE_ENGINE_PROFILE_SHARED {
+		return nvml.ComputeInstanceProfileInfo{}, nvml.ERROR_NOT_SUPPORTED
+	}
+	for sliceCount, profileID := range mockComputeInstanceProfiles {
+		if profileID != id || sliceCount > gi.profile.slices || (sliceCount == 3 && gi.profile.slices == 4) {
+			continue
+		}
+		return nvml.ComputeInstanceProfileInfo{
+			Id:                    uint32(id),
+			SliceCount:            sliceCount,
+			InstanceCount:         gi.profile.slices / sliceCount,
+			MultiprocessorCount:   sliceCount * 14,
+			SharedCopyEngineCount: gi.profile.slices,
+		}, nvml.SUCCESS
+	}
+	return nvml.ComputeInstanceProfileInfo{}, nvml.ERROR_NOT_SUPPORTED
+}
+
+func (gi *mockGpuInstance) CreateComputeInstance(info *nvml.ComputeInstanceProfileInfo) (nvml.ComputeInstance, nvml.Return) {
+	d := gi.device
+	d.Lock()
+	defer d.Unlock()
+
+	used := uint32(0)
+	for _, ci := range gi.computeInstances {
+		used += ci.info.Placement.Size
+	}
+	if used+info.SliceCount > gi.profile.slices {
+		return nil, nvml.ERROR_INSUFFICIENT_RESOURCES
+	}
+	slot := slices.Index(d.migDevices[:], nil)
+	if slot < 0 {
+		return nil, nvml.ERROR_INSUFFICIENT_RESOURCES
+	}
+
+	id := uint32(0)
+	for gi.computeInstances[id] != nil {
+		id++
+	}
+	ci := &mockComputeInstance{gpuInstance: gi}
+	ci.info = nvml.ComputeInstanceInfo{
+		Device:      d,
+		GpuInstance: gi,
+		Id:          id,
+		ProfileId:   info.Id,
+		Placement:   nvml.ComputeInstancePlacement{Start: used, Size: info.SliceCount},
+	}
+	gi.computeInstances[id] = ci
+
+	name := fmt.Sprintf("%s/%d/%d/%d", d.uuid, gi.info.ProfileId, gi.info.Placement.Start, id)
+	d.migDevices[slot] = &mockMigDevice{
+		uuid:            "MIG-" + uuid.NewSHA1(mockUUIDNamespace, []byte(name)).String(),
+		computeInstance: ci,
+	}
+	return ci, nvml.SUCCESS
+}
+
+func (gi *mockGpuInstance) GetComputeInstanceById(id int) (nvml.ComputeInstance, nvml.Return) {
+	gi.device.Lock()
+	defer gi.device.Unlock()
+	ci, exists := gi.computeInstances[uint32(id)]
+	if !exists {
+		return nil, nvml.ERROR_NOT_FOUND
+	}
+	return ci, nvml.SUCCESS
+}
+
+func (gi *mockGpuInstance) GetComputeInstances(info *nvml.ComputeInstanceProfileInfo) ([]nvml.ComputeInstance, nvml.Return) {
+	gi.device.Lock()
+	defer gi.device.Unlock()
+	var cis []nvml.ComputeInstance
+	for _, ci := range gi.computeInstances {
+		if ci.info.ProfileId == info.Id {
+			cis = append(cis, ci)
+		}
+	}
+	return cis, nvml.SUCCESS
+}
+
+func (gi *mockGpuInstance) Destroy() nvml.Return {
+	d := gi.device
+	d.Lock()
+	defer d.Unlock()
+	if len(gi.computeInstances) > 0 {
+		return nvml.ERROR_IN_USE
+	}
+	delete(d.gpuInstances, gi.info.Id)
+	return nvml.SUCCESS
+}
+
+func (ci *mockComputeInstance) GetInfo() (nvml.ComputeInstanceInfo, nvml.Return) {
+	return ci.info, nvml.SUCCESS
+}
+
+func (ci *mockComputeInstance) Destroy() nvml.Return {
+	d := ci.gpuInstance.device
+	d.Lock()
+	defer d.Unlock()
+	delete(ci.gpuInstance.computeInstances, ci.info.Id)
+	for i, mig := range d.migDevices {
+		if mig != nil && mig.computeInstance == ci {
+			d.migDevices[i] = nil
+		}
+	}
+	return nvml.SUCCESS
+}
+
+// migDevice returns the device handle of a compute instance.
+func (ci *mockComputeInstance) migDevice() *mockMigDevice {
+	d := ci.gpuInstance.device
+	d.Lock()
+	defer d.Unlock()
+	for _, mig := range d.migDevices {
+		if mig != nil && mig.computeInstance == ci {
+			return mig
+		}
+	}
+	return nil
+}
+
+func (m *mockMigDevice) GetUUID() (string, nvml.Return) {
+	return m.uuid, nvml.SUCCESS
+}
+
+func (m *mockMigDevice) GetComputeRunningProcesses() ([]nvml.ProcessInfo, nvml.Return) {
+	d := m.computeInstance.gpuInstance.device
+	d.Lock()
+	defer d.Unlock()
+	var processes []nvml.ProcessInfo
+	for _, pid := range m.processes {
+		processes = append(processes, nvml.ProcessInfo{Pid: pid})
+	}
+	return processes, nvml.SUCCESS
+}
+
+func (m *mockMigDevice) IsMigDeviceHandle() (bool, nvml.Return) {
+	return true, nvml.SUCCESS
+}
+
+func (m *mockMigDevice) GetDeviceHandleFromMigDeviceHandle() (nvml.Device, nvml.Return) {
+	return m.computeInstance.gpuInstance.device, nvml.SUCCESS
+}
+
+func (m *mockMigDevice) GetGpuInstanceId() (int, nvml.Return) {
+	return int(m.computeInstance.gpuInstance.info.Id), nvml.SUCCESS
+}
+
+func (m *mockMigDevice) GetComputeInstanceId() (int, nvml.Return) {
+	return int(m.computeInstance.info.Id), nvml.SUCCESS
+}
+
+func (m *mockMigDevice) GetMemoryInfo() (nvml.Memory, nvml.Return) {
+	gi := m.computeInstance.gpuInstance
+	total := gi.device.gpuInstanceProfileInfo(gi.profile).MemorySizeMB * 1024 * 1024
+	return nvml.Memory{Total: total, Free: total}, nvml.SUCCESS
+}
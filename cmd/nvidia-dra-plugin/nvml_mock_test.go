@@ -0,0 +1,168 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestMockTopologyValidate(t *testing.T) {
+	testCases := []struct {
+		description   string
+		topology      MockTopology
+		expectedError bool
+	}{
+		{
+			description: "full GPUs and MIG devices",
+			topology: MockTopology{
+				Gpus: []MockGpuGroup{
+					{Model: "A100-SXM4-80GB", Count: 4, MigDevices: []string{"3g.40gb", "3g.40gb"}},
+					{Model: "A100-SXM4-80GB", Count: 4},
+				},
+			},
+		},
+		{
+			description:   "no GPUs",
+			topology:      MockTopology{},
+			expectedError: true,
+		},
+		{
+			description: "unknown model",
+			topology: MockTopology{
+				Gpus: []MockGpuGroup{{Model: "B200"}},
+			},
+			expectedError: true,
+		},
+		{
+			description: "MIG on model without MIG support",
+			topology: MockTopology{
+				Gpus: []MockGpuGroup{{Model: "L4", MigEnabled: true}},
+			},
+			expectedError: true,
+		},
+		{
+			description: "unknown MIG profile",
+			topology: MockTopology{
+				Gpus: []MockGpuGroup{{Model: "A100-SXM4-40GB", MigDevices: []string{"3g.40gb"}}},
+			},
+			expectedError: true,
+		},
+		{
+			description: "MIG devices do not fit",
+			topology: MockTopology{
+				Gpus: []MockGpuGroup{{Model: "A100-SXM4-80GB", MigDevices: []string{"4g.40gb", "4g.40gb"}}},
+			},
+			expectedError: true,
+		},
+		{
+			description: "invalid CUDA driver version",
+			topology: MockTopology{
+				CudaDriverVersion: "12",
+				Gpus:              []MockGpuGroup{{Model: "T4"}},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			err := tc.topology.Validate()
+			if tc.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMockDeviceLib(t *testing.T) {
+	dir := t.TempDir()
+	topologyPath := filepath.Join(dir, "topology.yaml")
+	topology := `
+gpus:
+- model: A100-SXM4-80GB
+  count: 2
+  migDevices: [3g.40gb, 3g.40gb]
+- model: A100-SXM4-40GB
+  migEnabled: true
+- model: L4
+`
+	require.NoError(t, os.WriteFile(topologyPath, []byte(topology), 0644))
+
+	l, err := newMockDeviceLib(root(filepath.Join(dir, "driver")), topologyPath)
+	require.NoError(t, err)
+
+	config := &Config{
+		flags: &Flags{
+			deviceClasses: sets.New(GpuDeviceType, MigDeviceType),
+		},
+	}
+	allocatable, err := l.enumerateAllPossibleDevices(config)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		"gpu-0-mig-2-0-4",
+		"gpu-0-mig-2-4-4",
+		"gpu-1-mig-2-0-4",
+		"gpu-1-mig-2-4-4",
+		"gpu-3",
+	}, slices.Collect(maps.Keys(allocatable)))
+	require.Equal(t, "3g.40gb", allocatable["gpu-0-mig-2-0-4"].Mig.profile)
+
+	// MIG devices are created and deleted on demand on GPUs in MIG mode.
+	config.flags.dynamicMig = true
+	allocatable, err = l.enumerateAllPossibleDevices(config)
+	require.NoError(t, err)
+	placement := allocatable["gpu-2-mig-2-4-4"]
+	require.NotNil(t, placement)
+	require.Equal(t, "3g.20gb", placement.Mig.profile)
+
+	mig, err := l.createMigDevice(placement.Mig)
+	require.NoError(t, err)
+	_, err = l.createMigDevice(placement.Mig)
+	require.Error(t, err)
+
+	cdi, err := NewCDIHandler(append(
+		mockCDIOptions(l),
+		WithNvml(l.nvmllib),
+		WithDeviceLib(l),
+		WithDriverRoot(filepath.Join(dir, "driver")),
+		WithCDIRoot(dir),
+	)...)
+	require.NoError(t, err)
+	edits, err := cdi.getMigDeviceEdits(mig.UUID)
+	require.NoError(t, err)
+	require.Equal(t, "/dev/nvidia2", edits.DeviceNodes[0].Path)
+
+	instance := &MigInstance{
+		ParentUUID:        mig.parent.UUID,
+		GpuInstanceID:     int(mig.giInfo.Id),
+		ComputeInstanceID: int(mig.ciInfo.Id),
+	}
+	require.NoError(t, l.deleteMigDevice(instance))
+	_, err = l.createMigDevice(placement.Mig)
+	require.NoError(t, err)
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const DefaultSysfsRoot = "/sys"
+
+// PciTopology describes where a PCI device sits in the PCIe hierarchy of a
+// node, as read from sysfs.
+type PciTopology struct {
+	// numaNode is -1 if the platform does not report a NUMA node.
+	numaNode    int
+	pcieRoot    string
+	cpuAffinity string
+	// nearestNic is the name of the network interface closest to the device.
+	nearestNic string
+}
+
+// pciBusIDToString converts the NUL-terminated bus ID of an nvml.PciInfo to
+// a string.
+func pciBusIDToString(busID [32]int8) string {
+	var b strings.Builder
+	for _, c := range busID {
+		if c == 0 {
+			break
+		}
+		b.WriteByte(byte(c))
+	}
+	return b.String()
+}
+
+// normalizePciBusID converts a PCI bus ID as reported by NVML, e.g.
+// 00000000:3B:00.0, to the form used in sysfs, e.g. 0000:3b:00.0.
+func normalizePciBusID(busID string) string {
+	busID = strings.ToLower(busID)
+	domain, rest, found := strings.Cut(busID, ":")
+	if !found {
+		return busID
+	}
+	if len(domain) > 4 {
+		domain = domain[len(domain)-4:]
+	}
+	return domain + ":" + rest
+}
+
+// getPciTopology reads the topology of the PCI device with the given bus ID
+// from the sysfs tree at sysfsRoot. Attributes the platform does not report
+// are left empty.
+func getPciTopology(sysfsRoot string, busID string) (*PciTopology, error) {
+	// Device paths are resolved, so the root must be too for them to be
+	// compared against it.
+	sysfsRoot, err := filepath.EvalSymlinks(sysfsRoot)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving sysfs root: %w", err)
+	}
+	devicePath, err := resolvePciDevicePath(sysfsRoot, busID)
+	if err != nil {
+		return nil, err
+	}
+
+	topology := &PciTopology{
+		numaNode: -1,
+		pcieRoot: getPcieRoot(sysfsRoot, devicePath),
+	}
+
+	numaNode, err := readSysfsFile(filepath.Join(devicePath, "numa_node"))
+	if err != nil {
+		return nil, err
+	}
+	if numaNode != "" {
+		topology.numaNode, err = strconv.Atoi(numaNode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NUMA node of PCI device %v: %w", busID, err)
+		}
+	}
+
+	topology.cpuAffinity, err = readSysfsFile(filepath.Join(devicePath, "local_cpulist"))
+	if err != nil {
+		return nil, err
+	}
+
+	topology.nearestNic, err = getNearestNic(sysfsRoot, devicePath)
+	if err != nil {
+		return nil, fmt.Errorf("error finding nearest NIC of PCI device %v: %w", busID, err)
+	}
+
+	return topology, nil
+}
+
+// resolvePciDevicePath returns the path of a PCI device below
+// <sysfsRoot>/devices, which reflects its position in the PCIe hierarchy.
+func resolvePciDevicePath(sysfsRoot string, busID string) (string, error) {
+	path, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "bus", "pci", "devices", busID))
+	if err != nil {
+		return "", fmt.Errorf("error resolving sysfs path of PCI device %v: %w", busID, err)
+	}
+	return path, nil
+}
+
+// getPcieRoot returns the name of the PCIe root complex a device is below,
+// e.g. pci0000:00, or an empty string if the device path has none.
+func getPcieRoot(sysfsRoot string, devicePath string) string {
+	rel, err := filepath.Rel(filepath.Join(sysfsRoot, "devices"), devicePath)
+	if err != nil {
+		return ""
+	}
+	root, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	if !strings.HasPrefix(root, "pci") {
+		return ""
+	}
+	return root
+}
+
+// getNearestNic returns the network interface whose PCI device shares the
+// deepest common ancestor with the given device below the same PCIe root.
+// Interfaces are compared by name when equally close. An empty string is
+// returned if no interface is below the same root.
+func getNearestNic(sysfsRoot string, devicePath string) (string, error) {
+	pcieRoot := getPcieRoot(sysfsRoot, devicePath)
+	if pcieRoot == "" {
+		return "", nil
+	}
+
+	netPath := filepath.Join(sysfsRoot, "class", "net")
+	entries, err := os.ReadDir(netPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error listing network interfaces: %w", err)
+	}
+
+	var nearest string
+	nearestDepth := 0
+	for _, entry := range entries {
+		// Virtual interfaces have no device link.
+		nicPath, err := filepath.EvalSymlinks(filepath.Join(netPath, entry.Name(), "device"))
+		if err != nil {
+			continue
+		}
+		if getPcieRoot(sysfsRoot, nicPath) != pcieRoot {
+			continue
+		}
+		depth := commonPathDepth(devicePath, nicPath)
+		if depth > nearestDepth || (depth == nearestDepth && entry.Name() < nearest) {
+			nearest = entry.Name()
+			nearestDepth = depth
+		}
+	}
+	return nearest, nil
+}
+
+// commonPathDepth returns the number of leading path elements a and b share.
+func commonPathDepth(a, b string) int {
+	as := strings.Split(filepath.Clean(a), string(filepath.Separator))
+	bs := strings.Split(filepath.Clean(b), string(filepath.Separator))
+	depth := 0
+	for depth < len(as) && depth < len(bs) && as[depth] == bs[depth] {
+		depth++
+	}
+	return depth
+}
+
+// readSysfsFile returns the trimmed contents of a sysfs attribute, or an
+// empty string if it does not exist.
+func readSysfsFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading %v: %w", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1beta1"
+)
+
+// createFakeSysfs creates a sysfs tree with two PCIe roots. The first has a
+// GPU and an InfiniBand NIC below the same switch and an Ethernet NIC on
+// another root port. The second has a GPU without NUMA affinity and no NIC.
+func createFakeSysfs(t *testing.T) string {
+	sysfs := t.TempDir()
+	devices := map[string]map[string]string{
+		"pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:00.0/0000:03:00.0": {"numa_node": "0\n", "local_cpulist": "0-23,48-71\n"},
+		"pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0/0000:04:00.0": {"numa_node": "0\n"},
+		"pci0000:00/0000:00:02.0/0000:05:00.0":                           {"numa_node": "0\n"},
+		"pci0000:80/0000:80:01.0/0000:81:00.0":                           {"numa_node": "-1\n"},
+	}
+	nics := map[string]string{
+		"ib0":  "0000:04:00.0",
+		"eth0": "0000:05:00.0",
+	}
+
+	busDir := filepath.Join(sysfs, "bus", "pci", "devices")
+	require.NoError(t, os.MkdirAll(busDir, 0755))
+	devicePaths := make(map[string]string)
+	for path, files := range devices {
+		dir := filepath.Join(sysfs, "devices", path)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		for name, content := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		}
+		busID := filepath.Base(path)
+		devicePaths[busID] = dir
+		require.NoError(t, os.Symlink(dir, filepath.Join(busDir, busID)))
+	}
+
+	netDir := filepath.Join(sysfs, "class", "net")
+	for name, busID := range nics {
+		require.NoError(t, os.MkdirAll(filepath.Join(netDir, name), 0755))
+		require.NoError(t, os.Symlink(devicePaths[busID], filepath.Join(netDir, name, "device")))
+	}
+	// Virtual interfaces have no device.
+	require.NoError(t, os.MkdirAll(filepath.Join(netDir, "lo"), 0755))
+
+	return sysfs
+}
+
+func TestGetPciTopology(t *testing.T) {
+	sysfs := createFakeSysfs(t)
+
+	testCases := []struct {
+		description   string
+		busID         string
+		expected      *PciTopology
+		expectedError bool
+	}{
+		{
+			description: "NIC below the same switch is nearest",
+			busID:       normalizePciBusID("00000000:03:00.0"),
+			expected: &PciTopology{
+				numaNode:    0,
+				pcieRoot:    "pci0000:00",
+				cpuAffinity: "0-23,48-71",
+				nearestNic:  "ib0",
+			},
+		},
+		{
+			description: "no NUMA node and no NIC below the same root",
+			busID:       normalizePciBusID("00000000:81:00.0"),
+			expected: &PciTopology{
+				numaNode: -1,
+				pcieRoot: "pci0000:80",
+			},
+		},
+		{
+			description:   "unknown device",
+			busID:         "0000:82:00.0",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			topology, err := getPciTopology(sysfs, tc.busID)
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, topology)
+		})
+	}
+}
+
+func TestMigDeviceAttributeLimit(t *testing.T) {
+	gpu := &GpuInfo{
+		UUID:                  "GPU-0",
+		productName:           "NVIDIA A100-SXM4-80GB",
+		brand:                 "Nvidia",
+		architecture:          "Ampere",
+		cudaComputeCapability: "8.0",
+		driverVersion:         "550.54.15",
+		cudaDriverVersion:     "12.4",
+		pciBusID:              "0000:03:00.0",
+		pciTopology: &PciTopology{
+			numaNode:    0,
+			pcieRoot:    "pci0000:00",
+			cpuAffinity: "0-23,48-71",
+			nearestNic:  "ib0",
+		},
+	}
+	mig := &MigDeviceInfo{
+		UUID:          "MIG-0",
+		profile:       "7g.80gb",
+		parent:        gpu,
+		placement:     &MigDevicePlacement{nvml.GpuInstancePlacement{Start: 0, Size: 8}},
+		giProfileInfo: &nvml.GpuInstanceProfileInfo{},
+	}
+
+	device := mig.GetDevice()
+	require.Equal(t, "pci0000:00", *device.Basic.Attributes["pcieRoot"].StringValue)
+	require.LessOrEqual(t,
+		len(device.Basic.Attributes)+len(device.Basic.Capacity),
+		resourceapi.ResourceSliceMaxAttributesAndCapacitiesPerDevice)
+}
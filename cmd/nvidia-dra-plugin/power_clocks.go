@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+// getSupportedGpuSettings returns the ranges of the power limit and clocks
+// supported by a GPU. Only the maximum clocks are known, as the NVML bindings
+// cannot list the supported clocks.
+func (l deviceLib) getSupportedGpuSettings(uuid string) (configapi.SupportedGpuSettings, error) {
+	var supported configapi.SupportedGpuSettings
+
+	if err := l.Init(); err != nil {
+		return supported, err
+	}
+	defer l.alwaysShutdown()
+
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(uuid)
+	if ret != nvml.SUCCESS {
+		return supported, &NvmlError{Op: "getting device handle", UUID: uuid, Return: ret}
+	}
+
+	minLimit, maxLimit, ret := device.GetPowerManagementLimitConstraints()
+	switch ret {
+	case nvml.SUCCESS:
+		supported.MinPowerLimit = int(minLimit / 1000)
+		supported.MaxPowerLimit = int(maxLimit / 1000)
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return supported, &NvmlError{Op: "getting power limit constraints", UUID: uuid, Return: ret}
+	}
+
+	graphicsClock, ret := device.GetMaxClockInfo(nvml.CLOCK_GRAPHICS)
+	if ret != nvml.SUCCESS {
+		return supported, &NvmlError{Op: "getting maximum graphics clock", UUID: uuid, Return: ret}
+	}
+	supported.MaxGraphicsClock = int(graphicsClock)
+
+	memoryClock, ret := device.GetMaxClockInfo(nvml.CLOCK_MEM)
+	if ret != nvml.SUCCESS {
+		return supported, &NvmlError{Op: "getting maximum memory clock", UUID: uuid, Return: ret}
+	}
+	supported.MaxMemoryClock = int(memoryClock)
+
+	return supported, nil
+}
+
+// getPowerAndClocks returns the power limit in milliwatts and the
+// application clocks of a GPU. Either is nil if the GPU does not support
+// changing it.
+func (l deviceLib) getPowerAndClocks(uuid string) (*uint32, *configapi.ApplicationClocks, error) {
+	if err := l.Init(); err != nil {
+		return nil, nil, err
+	}
+	defer l.alwaysShutdown()
+
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(uuid)
+	if ret != nvml.SUCCESS {
+		return nil, nil, &NvmlError{Op: "getting device handle", UUID: uuid, Return: ret}
+	}
+
+	var powerLimit *uint32
+	limit, ret := device.GetPowerManagementLimit()
+	switch ret {
+	case nvml.SUCCESS:
+		powerLimit = &limit
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return nil, nil, &NvmlError{Op: "getting power limit", UUID: uuid, Return: ret}
+	}
+
+	var applicationClocks *configapi.ApplicationClocks
+	memoryClock, ret := device.GetApplicationsClock(nvml.CLOCK_MEM)
+	switch ret {
+	case nvml.SUCCESS:
+		graphicsClock, ret := device.GetApplicationsClock(nvml.CLOCK_GRAPHICS)
+		if ret != nvml.SUCCESS {
+			return nil, nil, &NvmlError{Op: "getting application graphics clock", UUID: uuid, Return: ret}
+		}
+		applicationClocks = &configapi.ApplicationClocks{
+			Memory:   int(memoryClock),
+			Graphics: int(graphicsClock),
+		}
+	case nvml.ERROR_NOT_SUPPORTED:
+	default:
+		return nil, nil, &NvmlError{Op: "getting application memory clock", UUID: uuid, Return: ret}
+	}
+
+	return powerLimit, applicationClocks, nil
+}
+
+// setPowerAndClocks applies the power limit and clocks of a GpuConfig to a
+// GPU. Settings not included in the config are left unchanged.
+func (l deviceLib) setPowerAndClocks(uuid string, config *configapi.GpuConfig) error {
+	if err := l.Init(); err != nil {
+		return err
+	}
+	defer l.alwaysShutdown()
+
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(uuid)
+	if ret != nvml.SUCCESS {
+		return &NvmlError{Op: "getting device handle", UUID: uuid, Return: ret}
+	}
+
+	if config.PowerLimit != nil {
+		if ret := device.SetPowerManagementLimit(uint32(*config.PowerLimit * 1000)); ret != nvml.SUCCESS {
+			return &NvmlError{Op: "setting power limit", UUID: uuid, Return: ret}
+		}
+	}
+	if c := config.LockedGraphicsClocks; c != nil {
+		if ret := device.SetGpuLockedClocks(uint32(c.Min), uint32(c.Max)); ret != nvml.SUCCESS {
+			return &NvmlError{Op: "locking graphics clocks", UUID: uuid, Return: ret}
+		}
+	}
+	if c := config.ApplicationClocks; c != nil {
+		if ret := device.SetApplicationsClocks(uint32(c.Memory), uint32(c.Graphics)); ret != nvml.SUCCESS {
+			return &NvmlError{Op: "setting application clocks", UUID: uuid, Return: ret}
+		}
+	}
+	return nil
+}
+
+// restorePowerAndClocks restores the power limit and clocks of a GPU from
+// the settings recorded before they were changed.
+func (l deviceLib) restorePowerAndClocks(uuid string, settings *GpuSettings) error {
+	if settings.PowerLimit == nil && settings.ApplicationClocks == nil && !settings.ResetLockedClocks {
+		return nil
+	}
+
+	if err := l.Init(); err != nil {
+		return err
+	}
+	defer l.alwaysShutdown()
+
+	device, ret := l.nvmllib.DeviceGetHandleByUUID(uuid)
+	if ret != nvml.SUCCESS {
+		return &NvmlError{Op: "getting device handle", UUID: uuid, Return: ret}
+	}
+
+	if settings.PowerLimit != nil {
+		if ret := device.SetPowerManagementLimit(*settings.PowerLimit); ret != nvml.SUCCESS {
+			return &NvmlError{Op: "restoring power limit", UUID: uuid, Return: ret}
+		}
+	}
+	if settings.ResetLockedClocks {
+		if ret := device.ResetGpuLockedClocks(); ret != nvml.SUCCESS {
+			return &NvmlError{Op: "resetting locked graphics clocks", UUID: uuid, Return: ret}
+		}
+	}
+	if c := settings.ApplicationClocks; c != nil {
+		if ret := device.SetApplicationsClocks(uint32(c.Memory), uint32(c.Graphics)); ret != nvml.SUCCESS {
+			return &NvmlError{Op: "restoring application clocks", UUID: uuid, Return: ret}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestPowerAndClocks(t *testing.T) {
+	dir := t.TempDir()
+	topologyPath := filepath.Join(dir, "topology.yaml")
+	require.NoError(t, os.WriteFile(topologyPath, []byte("gpus:\n- model: A100-SXM4-80GB\n"), 0644))
+
+	l, err := newMockDeviceLib(root(filepath.Join(dir, "driver")), topologyPath)
+	require.NoError(t, err)
+	device := l.nvmllib.(*mockNvml).devices[0]
+	uuid := device.uuid
+
+	supported, err := l.getSupportedGpuSettings(uuid)
+	require.NoError(t, err)
+	require.Equal(t, configapi.SupportedGpuSettings{
+		MinPowerLimit:    100,
+		MaxPowerLimit:    400,
+		MaxGraphicsClock: 1980,
+		MaxMemoryClock:   1593,
+	}, supported)
+
+	powerLimit, applicationClocks, err := l.getPowerAndClocks(uuid)
+	require.NoError(t, err)
+	settings := &GpuSettings{
+		PowerLimit:        powerLimit,
+		ApplicationClocks: applicationClocks,
+		ResetLockedClocks: true,
+	}
+
+	config := &configapi.GpuConfig{
+		PowerLimit:           ptr.To(250),
+		LockedGraphicsClocks: &configapi.ClockRange{Min: 1000, Max: 1500},
+		ApplicationClocks:    &configapi.ApplicationClocks{Memory: 1593, Graphics: 1200},
+	}
+	require.NoError(t, config.ValidateSupported(supported))
+	require.NoError(t, l.setPowerAndClocks(uuid, config))
+	require.Equal(t, uint32(250000), device.powerLimit)
+	require.Equal(t, [2]uint32{1000, 1500}, device.lockedGraphicsClocks)
+	require.Equal(t, [2]uint32{1593, 1200}, device.applicationClocks)
+
+	require.NoError(t, l.restorePowerAndClocks(uuid, settings))
+	require.Equal(t, uint32(400000), device.powerLimit)
+	require.Equal(t, [2]uint32{}, device.lockedGraphicsClocks)
+	require.Equal(t, [2]uint32{1593, 1410}, device.applicationClocks)
+}
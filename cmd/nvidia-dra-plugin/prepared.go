@@ -30,6 +30,8 @@ type PreparedDevice struct {
 	Gpu         *PreparedGpu         `json:"gpu"`
 	Mig         *PreparedMigDevice   `json:"mig"`
 	ImexChannel *PreparedImexChannel `json:"imexChannel"`
+	VGpu        *PreparedVGpu        `json:"vgpu"`
+	NvlinkGroup *PreparedNvlinkGroup `json:"nvlinkGroup,omitempty"`
 }
 
 type PreparedGpu struct {
@@ -38,8 +40,9 @@ type PreparedGpu struct {
 }
 
 type PreparedMigDevice struct {
-	Info   *MigDeviceInfo  `json:"info"`
-	Device *drapbv1.Device `json:"device"`
+	Info     *MigDeviceInfo  `json:"info"`
+	Instance *MigInstance    `json:"instance,omitempty"`
+	Device   *drapbv1.Device `json:"device"`
 }
 
 type PreparedImexChannel struct {
@@ -47,6 +50,17 @@ type PreparedImexChannel struct {
 	Device *drapbv1.Device  `json:"device"`
 }
 
+type PreparedVGpu struct {
+	Info   *VGpuInfo       `json:"info"`
+	Limits *VGpuLimits     `json:"limits"`
+	Device *drapbv1.Device `json:"device"`
+}
+
+type PreparedNvlinkGroup struct {
+	Info   *NvlinkGroupInfo `json:"info"`
+	Device *drapbv1.Device  `json:"device"`
+}
+
 type PreparedDeviceGroup struct {
 	Devices     PreparedDeviceList `json:"devices"`
 	ConfigState DeviceConfigState  `json:"configState"`
@@ -62,6 +76,12 @@ func (d PreparedDevice) Type() string {
 	if d.ImexChannel != nil {
 		return ImexChannelType
 	}
+	if d.VGpu != nil {
+		return VGpuDeviceType
+	}
+	if d.NvlinkGroup != nil {
+		return NvlinkGroupDeviceType
+	}
 	return UnknownDeviceType
 }
 
@@ -73,6 +93,10 @@ func (d *PreparedDevice) CanonicalName() string {
 		return d.Mig.Info.CanonicalName()
 	case ImexChannelType:
 		return d.ImexChannel.Info.CanonicalName()
+	case VGpuDeviceType:
+		return d.VGpu.Info.CanonicalName()
+	case NvlinkGroupDeviceType:
+		return d.NvlinkGroup.Info.CanonicalName()
 	}
 	panic("unexpected type for AllocatableDevice")
 }
@@ -85,10 +109,30 @@ func (d *PreparedDevice) CanonicalIndex() string {
 		return d.Mig.Info.CanonicalIndex()
 	case ImexChannelType:
 		return d.ImexChannel.Info.CanonicalIndex()
+	case VGpuDeviceType:
+		return d.VGpu.Info.CanonicalIndex()
+	case NvlinkGroupDeviceType:
+		return d.NvlinkGroup.Info.CanonicalIndex()
 	}
 	panic("unexpected type for AllocatableDevice")
 }
 
+func (d *PreparedDevice) Device() *drapbv1.Device {
+	switch d.Type() {
+	case GpuDeviceType:
+		return d.Gpu.Device
+	case MigDeviceType:
+		return d.Mig.Device
+	case ImexChannelType:
+		return d.ImexChannel.Device
+	case VGpuDeviceType:
+		return d.VGpu.Device
+	case NvlinkGroupDeviceType:
+		return d.NvlinkGroup.Device
+	}
+	return nil
+}
+
 func (l PreparedDeviceList) Gpus() PreparedDeviceList {
 	var devices PreparedDeviceList
 	for _, device := range l {
@@ -99,6 +143,16 @@ func (l PreparedDeviceList) Gpus() PreparedDeviceList {
 	return devices
 }
 
+func (l PreparedDeviceList) NvlinkGroups() PreparedDeviceList {
+	var devices PreparedDeviceList
+	for _, device := range l {
+		if device.Type() == NvlinkGroupDeviceType {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
 func (l PreparedDeviceList) MigDevices() PreparedDeviceList {
 	var devices PreparedDeviceList
 	for _, device := range l {
@@ -119,6 +173,32 @@ func (l PreparedDeviceList) ImexChannels() PreparedDeviceList {
 	return devices
 }
 
+func (l PreparedDeviceList) VGpus() PreparedDeviceList {
+	var devices PreparedDeviceList
+	for _, device := range l {
+		if device.Type() == VGpuDeviceType {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+func (d PreparedDevices) MigDevices() PreparedDeviceList {
+	var devices PreparedDeviceList
+	for _, group := range d {
+		devices = append(devices, group.Devices.MigDevices()...)
+	}
+	return devices
+}
+
+func (d PreparedDevices) VGpus() PreparedDeviceList {
+	var devices PreparedDeviceList
+	for _, group := range d {
+		devices = append(devices, group.Devices.VGpus()...)
+	}
+	return devices
+}
+
 func (d PreparedDevices) GetDevices() []*drapbv1.Device {
 	var devices []*drapbv1.Device
 	for _, group := range d {
@@ -130,13 +210,8 @@ func (d PreparedDevices) GetDevices() []*drapbv1.Device {
 func (g *PreparedDeviceGroup) GetDevices() []*drapbv1.Device {
 	var devices []*drapbv1.Device
 	for _, device := range g.Devices {
-		switch device.Type() {
-		case GpuDeviceType:
-			devices = append(devices, device.Gpu.Device)
-		case MigDeviceType:
-			devices = append(devices, device.Mig.Device)
-		case ImexChannelType:
-			devices = append(devices, device.ImexChannel.Device)
+		if d := device.Device(); d != nil {
+			devices = append(devices, d)
 		}
 	}
 	return devices
@@ -144,33 +219,50 @@ func (g *PreparedDeviceGroup) GetDevices() []*drapbv1.Device {
 
 func (l PreparedDeviceList) UUIDs() []string {
 	uuids := append(l.GpuUUIDs(), l.MigDeviceUUIDs()...)
+	uuids = append(uuids, l.VGpuParents().UUIDs()...)
 	slices.Sort(uuids)
 	return uuids
 }
 
 func (g *PreparedDeviceGroup) UUIDs() []string {
 	uuids := append(g.GpuUUIDs(), g.MigDeviceUUIDs()...)
+	uuids = append(uuids, g.Devices.VGpuParents().UUIDs()...)
 	slices.Sort(uuids)
 	return uuids
 }
 
 func (d PreparedDevices) UUIDs() []string {
 	uuids := append(d.GpuUUIDs(), d.MigDeviceUUIDs()...)
+	uuids = append(uuids, d.VGpus().VGpuParents().UUIDs()...)
 	slices.Sort(uuids)
 	return uuids
 }
 
+// VGpuParents returns the devices backing the vGPUs in the list.
+func (l PreparedDeviceList) VGpuParents() VGpuParentDevices {
+	var parents VGpuParentDevices
+	for _, device := range l.VGpus() {
+		parents = append(parents, device.VGpu.Info)
+	}
+	return parents
+}
+
+// GpuUUIDs returns the UUIDs of the full GPUs in the list, including the
+// members of NVLink groups.
 func (l PreparedDeviceList) GpuUUIDs() []string {
 	var uuids []string
 	for _, device := range l.Gpus() {
 		uuids = append(uuids, device.Gpu.Info.UUID)
 	}
+	for _, device := range l.NvlinkGroups() {
+		uuids = append(uuids, device.NvlinkGroup.Info.UUIDs()...)
+	}
 	slices.Sort(uuids)
-	return uuids
+	return slices.Compact(uuids)
 }
 
 func (g *PreparedDeviceGroup) GpuUUIDs() []string {
-	return g.Devices.Gpus().UUIDs()
+	return g.Devices.GpuUUIDs()
 }
 
 func (d PreparedDevices) GpuUUIDs() []string {
@@ -182,6 +274,18 @@ func (d PreparedDevices) GpuUUIDs() []string {
 	return uuids
 }
 
+// FullGpuUUIDs returns the UUIDs of the full GPUs in the set, including
+// those backing vGPUs.
+func (d PreparedDevices) FullGpuUUIDs() []string {
+	var uuids []string
+	for _, group := range d {
+		uuids = append(uuids, group.Devices.GpuUUIDs()...)
+		uuids = append(uuids, group.Devices.VGpuParents().GpuUUIDs()...)
+	}
+	slices.Sort(uuids)
+	return slices.Compact(uuids)
+}
+
 func (l PreparedDeviceList) MigDeviceUUIDs() []string {
 	var uuids []string
 	for _, device := range l.MigDevices() {
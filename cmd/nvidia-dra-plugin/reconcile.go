@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// reconcilePreparedClaims compares the claims recorded as prepared in the
+// checkpoint against the ResourceClaims allocated to this node. Claims that
+// were deleted or deallocated while the plugin was not running are
+// unprepared, while the MPS control daemons of the remaining claims are
+// re-adopted and their CDI spec files regenerated if missing.
+func (s *DeviceState) reconcilePreparedClaims(ctx context.Context) error {
+	checkpoint, err := s.getCheckpoint()
+	if err != nil {
+		return err
+	}
+	if len(checkpoint.V2.PreparedClaims) == 0 {
+		return nil
+	}
+
+	allocated, err := s.getAllocatedClaimUIDs(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting claims allocated to node: %w", err)
+	}
+
+	claimSpecs, err := s.cdi.ListClaimSpecFiles()
+	if err != nil {
+		return err
+	}
+	specs := sets.New(claimSpecs...)
+
+	for claimUID, devices := range checkpoint.V2.PreparedClaims {
+		if allocated.Has(claimUID) {
+			s.adoptMpsControlDaemons(ctx, claimUID, devices)
+			if !specs.Has(claimUID) {
+				s.regenerateClaimSpecFile(claimUID, devices)
+			}
+			continue
+		}
+
+		klog.Infof("Unpreparing claim %v as it is no longer allocated to this node", claimUID)
+		if err := s.Unprepare(ctx, claimUID); err != nil {
+			klog.Errorf("Error unpreparing claim %v: %v", claimUID, err)
+		}
+	}
+
+	return nil
+}
+
+// getAllocatedClaimUIDs returns the UIDs of all ResourceClaims with devices
+// from this driver on this node allocated to them.
+func (s *DeviceState) getAllocatedClaimUIDs(ctx context.Context) (sets.Set[string], error) {
+	claims, err := s.config.clientsets.Core.ResourceV1beta1().ResourceClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing resource claims: %w", err)
+	}
+
+	uids := sets.New[string]()
+	for _, claim := range claims.Items {
+		if claim.Status.Allocation == nil {
+			continue
+		}
+		for _, result := range claim.Status.Allocation.Devices.Results {
+			if result.Driver == DriverName && result.Pool == s.config.flags.nodeName {
+				uids.Insert(string(claim.UID))
+				break
+			}
+		}
+	}
+
+	return uids, nil
+}
+
+// adoptMpsControlDaemons checks that the MPS control daemons started for a
+// prepared claim by a previous instance of the plugin are still in place, so
+// that they continue to be used rather than being started again.
+func (s *DeviceState) adoptMpsControlDaemons(ctx context.Context, claimUID string, devices PreparedDevices) {
+	for _, group := range devices {
+		if group.ConfigState.MpsControlDaemonID == "" {
+			continue
+		}
+		mpsControlDaemon := s.mpsManager.NewMpsControlDaemon(claimUID, group)
+		if err := mpsControlDaemon.Adopt(ctx); err != nil {
+			klog.Warningf("Unable to re-adopt MPS control daemon %v for claim %v: %v", mpsControlDaemon.GetID(), claimUID, err)
+			continue
+		}
+		klog.Infof("Re-adopted MPS control daemon %v for claim %v", mpsControlDaemon.GetID(), claimUID)
+	}
+}
+
+// regenerateClaimSpecFile recreates the lost CDI spec file of a prepared
+// claim from the checkpoint. This is only possible for claims prepared since
+// the checkpoint recorded the full state of each device group.
+func (s *DeviceState) regenerateClaimSpecFile(claimUID string, devices PreparedDevices) {
+	for _, group := range devices {
+		if group.ConfigState.Config == nil {
+			klog.Warningf("Unable to regenerate CDI spec file for claim %v: not recorded in checkpoint", claimUID)
+			return
+		}
+	}
+	devices, err := resolvePreparedDevices(devices, s.Allocatable())
+	if err != nil {
+		klog.Warningf("Unable to regenerate CDI spec file for claim %v: %v", claimUID, err)
+		return
+	}
+	if err := s.cdi.CreateClaimSpecFile(claimUID, devices); err != nil {
+		klog.Errorf("Error regenerating CDI spec file for claim %v: %v", claimUID, err)
+		return
+	}
+	klog.Infof("Regenerated CDI spec file for claim %v", claimUID)
+}
+
+// resolvePreparedDevices returns a copy of prepared devices read from the
+// checkpoint with their info taken from the allocatable devices of the same
+// name. The checkpoint only records the exported fields of the info, which
+// are not enough to e.g. name the devices in a CDI spec.
+func resolvePreparedDevices(devices PreparedDevices, allocatable AllocatableDevices) (PreparedDevices, error) {
+	var resolved PreparedDevices
+	for _, group := range devices {
+		resolvedGroup := &PreparedDeviceGroup{
+			ConfigState: group.ConfigState,
+		}
+		for _, device := range group.Devices {
+			name := device.Device().GetDeviceName()
+			info, exists := allocatable[name]
+			if !exists {
+				return nil, fmt.Errorf("device %v is not allocatable", name)
+			}
+			if info.Type() != device.Type() {
+				return nil, fmt.Errorf("device %v is of type %v rather than %v", name, info.Type(), device.Type())
+			}
+
+			switch device.Type() {
+			case GpuDeviceType:
+				gpu := *device.Gpu
+				gpu.Info = info.Gpu
+				device.Gpu = &gpu
+			case MigDeviceType:
+				// MIG devices created on demand have a UUID of their own,
+				// which the allocatable device they were created from lacks.
+				mig := *device.Mig
+				migInfo := *info.Mig
+				migInfo.UUID = device.Mig.Info.UUID
+				mig.Info = &migInfo
+				device.Mig = &mig
+			case VGpuDeviceType:
+				vgpu := *device.VGpu
+				vgpu.Info = info.VGPU
+				device.VGpu = &vgpu
+			case NvlinkGroupDeviceType:
+				nvlinkGroup := *device.NvlinkGroup
+				nvlinkGroup.Info = info.NvlinkGroup
+				device.NvlinkGroup = &nvlinkGroup
+			}
+			resolvedGroup.Devices = append(resolvedGroup.Devices, device)
+		}
+		resolved = append(resolved, resolvedGroup)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,193 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	drapbv1 "k8s.io/kubelet/pkg/apis/dra/v1beta1"
+	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
+)
+
+func TestRegenerateClaimSpecFile(t *testing.T) {
+	dir := t.TempDir()
+	driverRoot := filepath.Join(dir, "driver")
+	cdiRoot := filepath.Join(dir, "cdi")
+	topologyPath := filepath.Join(dir, "topology.yaml")
+	topology := `
+gpus:
+- model: A100-SXM4-40GB
+  migEnabled: true
+- model: L4
+  count: 2
+`
+	require.NoError(t, os.WriteFile(topologyPath, []byte(topology), 0644))
+
+	l, err := newMockDeviceLib(root(driverRoot), topologyPath)
+	require.NoError(t, err)
+
+	config := &Config{
+		flags: &Flags{
+			deviceClasses:  sets.New(GpuDeviceType, MigDeviceType, VGpuDeviceType),
+			dynamicMig:     true,
+			vgpuSplitCount: 2,
+		},
+	}
+	allocatable, err := l.enumerateAllPossibleDevices(config)
+	require.NoError(t, err)
+
+	mig, err := l.createMigDevice(allocatable["gpu-0-mig-2-4-4"].Mig)
+	require.NoError(t, err)
+
+	device := func(name string) *drapbv1.Device {
+		return &drapbv1.Device{RequestNames: []string{"request"}, DeviceName: name}
+	}
+	devices := PreparedDevices{
+		{
+			Devices: PreparedDeviceList{
+				{
+					Mig: &PreparedMigDevice{
+						Info:     mig,
+						Instance: mig.Instance(),
+						Device:   device("gpu-0-mig-2-4-4"),
+					},
+				},
+				{
+					VGpu: &PreparedVGpu{
+						Info:   allocatable["vgpu-1-0"].VGPU,
+						Device: device("vgpu-1-0"),
+					},
+				},
+				{
+					Gpu: &PreparedGpu{
+						Info:   allocatable["gpu-2"].Gpu,
+						Device: device("gpu-2"),
+					},
+				},
+			},
+			ConfigState: DeviceConfigState{
+				Config: &runtime.RawExtension{Raw: []byte(`{}`)},
+				ContainerEdits: &cdiapi.ContainerEdits{
+					ContainerEdits: &cdispec.ContainerEdits{Env: []string{"FOO=bar"}},
+				},
+			},
+		},
+	}
+
+	// The devices are read back from a checkpoint, as on startup.
+	checkpoint := newCheckpoint()
+	checkpoint.V2.PreparedClaims["claim"] = devices
+	data, err := checkpoint.MarshalCheckpoint()
+	require.NoError(t, err)
+	checkpoint = newCheckpoint()
+	require.NoError(t, checkpoint.UnmarshalCheckpoint(data))
+
+	cdi, err := NewCDIHandler(append(
+		mockCDIOptions(l),
+		WithNvml(l.nvmllib),
+		WithDeviceLib(l),
+		WithDriverRoot(driverRoot),
+		WithCDIRoot(cdiRoot),
+	)...)
+	require.NoError(t, err)
+	state := &DeviceState{allocatable: allocatable, cdi: cdi}
+	state.regenerateClaimSpecFile("claim", checkpoint.V2.PreparedClaims["claim"])
+
+	paths, err := filepath.Glob(filepath.Join(cdiRoot, "*claim*"))
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	spec, err := cdiapi.ReadSpec(paths[0], 0)
+	require.NoError(t, err)
+
+	var names []string
+	for _, device := range spec.Devices {
+		names = append(names, device.Name)
+	}
+	require.ElementsMatch(t, []string{
+		"claim-gpu-0-mig-2-4-4",
+		"claim-vgpu-1-0",
+		"claim-gpu-2",
+	}, names)
+}
+
+func TestReconcilePreparedClaims(t *testing.T) {
+	ctx := context.Background()
+	state := newTestDeviceState(t, "gpus:\n- model: L4\n  count: 4\n", &Flags{})
+	claims := state.client.ResourceV1beta1().ResourceClaims("default")
+
+	kept := state.createClaim(t, "kept", nil, "gpu-0")
+	deleted := state.createClaim(t, "deleted", nil, "gpu-1")
+	moved := state.createClaim(t, "moved", nil, "gpu-2")
+	for _, claim := range []*resourceapi.ResourceClaim{kept, deleted, moved} {
+		_, err := state.Prepare(ctx, claim)
+		require.NoError(t, err)
+	}
+
+	// A claim whose MPS control daemon was started by a previous instance
+	// of the plugin.
+	mps := state.createClaim(t, "mps", nil, "gpu-3")
+	group := &PreparedDeviceGroup{
+		Devices: PreparedDeviceList{
+			{
+				Gpu: &PreparedGpu{
+					Info:   state.allocatable["gpu-3"].Gpu,
+					Device: &drapbv1.Device{RequestNames: []string{"gpu-3"}, PoolName: testNodeName, DeviceName: "gpu-3"},
+				},
+			},
+		},
+	}
+	daemonID := state.mpsManager.GetMpsControlDaemonID(string(mps.UID), group)
+	group.ConfigState.MpsControlDaemonID = daemonID
+	require.NoError(t, os.MkdirAll(filepath.Join(state.mpsManager.controlFilesRoot, daemonID), 0755))
+	require.NoError(t, state.updateCheckpoint(func(checkpoint *Checkpoint) {
+		checkpoint.V2.PreparedClaims[string(mps.UID)] = PreparedDevices{group}
+	}))
+
+	// While the plugin is not running, one claim is deleted and another
+	// one is deallocated and allocated on another node.
+	require.NoError(t, claims.Delete(ctx, deleted.Name, metav1.DeleteOptions{}))
+	moved.Status.Allocation.Devices.Results[0].Pool = "other-node"
+	_, err := claims.UpdateStatus(ctx, moved, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, state.reconcilePreparedClaims(ctx))
+
+	checkpoint, err := state.getCheckpoint()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{string(kept.UID), string(mps.UID)}, slices.Collect(maps.Keys(checkpoint.V2.PreparedClaims)))
+	specs, err := state.cdi.ListClaimSpecFiles()
+	require.NoError(t, err)
+	require.NotContains(t, specs, string(deleted.UID))
+	require.NotContains(t, specs, string(moved.UID))
+	require.Contains(t, specs, string(kept.UID))
+
+	require.True(t, state.mps.adopted.Has(daemonID))
+	require.Zero(t, state.mps.starts)
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	DefaultRediscoveryInterval = 5 * time.Minute
+)
+
+// DeviceRediscoverer re-enumerates the allocatable devices and republishes
+// them whenever they may have changed, e.g. after MIG devices have been
+// reconfigured or a GPU has been reset or removed. Re-enumeration is
+// triggered periodically, on SIGHUP, on NVML MIG configuration change events
+// and when the unhealthy marks of devices are cleared.
+type DeviceRediscoverer struct {
+	state    *DeviceState
+	interval time.Duration
+	publish  func(ctx context.Context) error
+	trigger  chan struct{}
+}
+
+func NewDeviceRediscoverer(config *Config, state *DeviceState, publish func(ctx context.Context) error) *DeviceRediscoverer {
+	return &DeviceRediscoverer{
+		state:    state,
+		interval: config.flags.rediscoveryInterval,
+		publish:  publish,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Trigger requests a re-enumeration without waiting for it to happen.
+func (r *DeviceRediscoverer) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run re-enumerates devices whenever triggered until the context is done.
+func (r *DeviceRediscoverer) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	go r.watchMigConfigChanges(ctx)
+
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			klog.Info("Received SIGHUP, re-enumerating devices")
+		case <-r.trigger:
+		case <-tick:
+		case <-r.state.healthCleared:
+			klog.Info("Devices are no longer marked unhealthy, republishing devices")
+			r.rediscover(ctx, true)
+			continue
+		}
+		r.rediscover(ctx, false)
+	}
+}
+
+// rediscover re-enumerates the devices and republishes them if they have
+// changed or if force is set.
+func (r *DeviceRediscoverer) rediscover(ctx context.Context, force bool) {
+	changed, err := r.state.UpdateAllocatable()
+	if err != nil {
+		klog.Errorf("Error re-enumerating devices: %v", err)
+		return
+	}
+	if !changed && !force {
+		return
+	}
+	if err := r.publish(ctx); err != nil {
+		klog.Errorf("Error publishing re-enumerated devices: %v", err)
+	}
+}
+
+// watchMigConfigChanges triggers a re-enumeration whenever NVML reports that
+// the MIG configuration of a GPU has changed.
+func (r *DeviceRediscoverer) watchMigConfigChanges(ctx context.Context) {
+	nvdevlib := r.state.nvdevlib
+	if err := nvdevlib.Init(); err != nil {
+		klog.Errorf("Not watching for MIG configuration changes: %v", err)
+		return
+	}
+	defer nvdevlib.alwaysShutdown()
+
+	eventSet, ret := nvdevlib.nvmllib.EventSetCreate()
+	if ret != nvml.SUCCESS {
+		klog.Errorf("Not watching for MIG configuration changes: error creating event set: %v", ret)
+		return
+	}
+	defer func() {
+		if ret := eventSet.Free(); ret != nvml.SUCCESS {
+			klog.Warningf("Error freeing event set: %v", ret)
+		}
+	}()
+
+	registered := sets.New[string]()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// Pick up GPUs that appeared since the last re-enumeration.
+		for _, uuid := range r.state.Allocatable().GpuParentUUIDs() {
+			if registered.Has(uuid) {
+				continue
+			}
+			if err := nvdevlib.registerEvents(eventSet, uuid, nvml.EventMigConfigChange); err != nil {
+				klog.V(4).Infof("Not watching GPU %v for MIG configuration changes: %v", uuid, err)
+			}
+			registered.Insert(uuid)
+		}
+
+		_, ret := eventSet.Wait(nvmlEventTimeoutMs)
+		if ret == nvml.ERROR_TIMEOUT {
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			klog.Warningf("Error waiting for MIG configuration change events: %v", ret)
+			continue
+		}
+		klog.Info("MIG configuration changed, re-enumerating devices")
+		r.Trigger()
+	}
+}
+
+// Allocatable returns the current set of allocatable devices. The returned
+// set is never modified, but replaced as a whole when devices are
+// re-enumerated.
+func (s *DeviceState) Allocatable() AllocatableDevices {
+	s.allocatableLock.RLock()
+	defer s.allocatableLock.RUnlock()
+	return s.allocatable
+}
+
+// UpdateAllocatable re-enumerates the allocatable devices and, if they have
+// changed, regenerates the base CDI spec for them. Devices that are used by
+// prepared claims are retained even if they are no longer present, so that
+// they are not withdrawn from under the claims. It reports whether the set of
+// allocatable devices has changed.
+func (s *DeviceState) UpdateAllocatable() (bool, error) {
+	// Block claims from being prepared or unprepared, as they rely on the
+	// allocatable devices remaining stable while in flux.
+	s.prepareLock.Lock()
+	defer s.prepareLock.Unlock()
+
+	allocatable, err := s.nvdevlib.enumerateAllPossibleDevices(s.config)
+	if err != nil {
+		return false, fmt.Errorf("error enumerating all possible devices: %w", err)
+	}
+
+	checkpoint, err := s.getCheckpoint()
+	if err != nil {
+		return false, err
+	}
+	for _, devices := range checkpoint.V2.PreparedClaims {
+		for _, device := range devices.GetDevices() {
+			if _, exists := allocatable[device.DeviceName]; exists {
+				continue
+			}
+			if previous, exists := s.allocatable[device.DeviceName]; exists {
+				klog.Warningf("Retaining device %v until the claims using it are unprepared", device.DeviceName)
+				allocatable[device.DeviceName] = previous
+			}
+		}
+	}
+
+	added, removed, changed := s.allocatable.Diff(allocatable)
+	if len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
+		return false, nil
+	}
+	klog.Infof("Allocatable devices changed: added %v, removed %v, changed %v", added, removed, changed)
+
+	if err := s.cdi.CreateStandardDeviceSpecFile(allocatable); err != nil {
+		return false, fmt.Errorf("unable to create base CDI spec file: %w", err)
+	}
+
+	s.allocatableLock.Lock()
+	s.allocatable = allocatable
+	s.allocatableLock.Unlock()
+
+	// Devices that have come back or changed are given a fresh start, e.g.
+	// after a failed GPU has been reset or replaced.
+	if cleared := s.ClearUnhealthy(slices.Concat(added, removed, changed)...); len(cleared) > 0 {
+		klog.Infof("Clearing unhealthy marks of re-enumerated devices: %v", cleared)
+	}
+
+	s.vgpuLedger.SetCapacity(allocatable, s.config.flags.vgpuScaling)
+	if err := s.vgpuManager.SetActivePriorities(s.vgpuLedger.ActivePriorities()); err != nil {
+		return true, fmt.Errorf("unable to set active vGPU priorities: %w", err)
+	}
+
+	return true, nil
+}
+
+// Diff returns the names of the devices only present in other, of those
+// only present in d, and of those present in both but advertised differently.
+func (d AllocatableDevices) Diff(other AllocatableDevices) (added, removed, changed []string) {
+	for name, device := range other {
+		previous, exists := d[name]
+		switch {
+		case !exists:
+			added = append(added, name)
+		case !equality.Semantic.DeepEqual(previous.GetDevice(), device.GetDevice()):
+			changed = append(changed, name)
+		}
+	}
+	for name := range d {
+		if _, exists := other[name]; !exists {
+			removed = append(removed, name)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(changed)
+	return added, removed, changed
+}
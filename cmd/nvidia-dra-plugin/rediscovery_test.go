@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllocatableDevicesDiff(t *testing.T) {
+	newGpu := func(index int, uuid string) *AllocatableDevice {
+		return &AllocatableDevice{
+			Gpu: &GpuInfo{
+				UUID:                  uuid,
+				index:                 index,
+				cudaComputeCapability: "8.0",
+				driverVersion:         "550.54.15",
+				cudaDriverVersion:     "12.4",
+			},
+		}
+	}
+	current := AllocatableDevices{
+		"gpu-0": newGpu(0, "GPU-0"),
+		"gpu-1": newGpu(1, "GPU-1"),
+	}
+
+	testCases := []struct {
+		description     string
+		updated         AllocatableDevices
+		expectedAdded   []string
+		expectedRemoved []string
+		expectedChanged []string
+	}{
+		{
+			description: "unchanged",
+			updated: AllocatableDevices{
+				"gpu-0": newGpu(0, "GPU-0"),
+				"gpu-1": newGpu(1, "GPU-1"),
+			},
+		},
+		{
+			description: "gpu removed",
+			updated: AllocatableDevices{
+				"gpu-0": newGpu(0, "GPU-0"),
+			},
+			expectedRemoved: []string{"gpu-1"},
+		},
+		{
+			description: "gpu added",
+			updated: AllocatableDevices{
+				"gpu-0": newGpu(0, "GPU-0"),
+				"gpu-1": newGpu(1, "GPU-1"),
+				"gpu-2": newGpu(2, "GPU-2"),
+			},
+			expectedAdded: []string{"gpu-2"},
+		},
+		{
+			description: "gpu replaced",
+			updated: AllocatableDevices{
+				"gpu-0": newGpu(0, "GPU-0"),
+				"gpu-1": newGpu(1, "GPU-3"),
+			},
+			expectedChanged: []string{"gpu-1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			added, removed, changed := current.Diff(tc.updated)
+			require.Equal(t, tc.expectedAdded, added)
+			require.Equal(t, tc.expectedRemoved, removed)
+			require.Equal(t, tc.expectedChanged, changed)
+		})
+	}
+}
@@ -61,6 +61,24 @@ func (r root) getNvidiaSMIPath() (string, error) {
 	return binaryPath, nil
 }
 
+// getNvidiaCudaMpsControlPath returns path to the `nvidia-cuda-mps-control`
+// executable in the driver root.
+func (r root) getNvidiaCudaMpsControlPath() (string, error) {
+	binarySearchPaths := []string{
+		"/usr/bin",
+		"/usr/sbin",
+		"/bin",
+		"/sbin",
+	}
+
+	binaryPath, err := r.findFile("nvidia-cuda-mps-control", binarySearchPaths...)
+	if err != nil {
+		return "", err
+	}
+
+	return binaryPath, nil
+}
+
 // isDevRoot checks whether the specified root is a dev root.
 // A dev root is defined as a root containing a /dev folder.
 func (r root) isDevRoot() bool {
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+const (
+	// leftoverProcessTimeout is how long processes left on a device are
+	// given to exit, or to die once killed, before scrubbing fails.
+	leftoverProcessTimeout      = 10 * time.Second
+	leftoverProcessPollInterval = 500 * time.Millisecond
+)
+
+// ErrGpuResetNotSupported is returned when a GPU is to be reset without
+// nvidia-smi being available.
+var ErrGpuResetNotSupported = errors.New("resetting a GPU requires nvidia-smi")
+
+// killProcess kills a process on the host. The plugin must share the PID
+// namespace of the host, as NVML reports host PIDs.
+func killProcess(pid uint32) error {
+	err := unix.Kill(int(pid), unix.SIGKILL)
+	if errors.Is(err, unix.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// getComputeProcesses returns the PIDs of the compute processes running on
+// the given GPUs or MIG devices.
+func (l deviceLib) getComputeProcesses(uuids []string) ([]uint32, error) {
+	if err := l.Init(); err != nil {
+		return nil, err
+	}
+	defer l.alwaysShutdown()
+
+	var pids []uint32
+	for _, uuid := range uuids {
+		device, ret := l.nvmllib.DeviceGetHandleByUUID(uuid)
+		if ret != nvml.SUCCESS {
+			return nil, &NvmlError{Op: "getting device handle", UUID: uuid, Return: ret}
+		}
+		processes, ret := device.GetComputeRunningProcesses()
+		if ret != nvml.SUCCESS {
+			return nil, &NvmlError{Op: "getting compute processes", UUID: uuid, Return: ret}
+		}
+		for _, process := range processes {
+			pids = append(pids, process.Pid)
+		}
+	}
+	slices.Sort(pids)
+	return slices.Compact(pids), nil
+}
+
+// stopLeftoverProcesses waits for the compute processes left on the given
+// GPUs or MIG devices to exit, killing them if the action says so. An error
+// is returned if any are still running once leftoverProcessTimeout has
+// passed.
+func (l deviceLib) stopLeftoverProcesses(ctx context.Context, uuids []string, action configapi.LeftoverProcessAction) error {
+	deadline := time.Now().Add(leftoverProcessTimeout)
+	for {
+		pids, err := l.getComputeProcesses(uuids)
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("processes %v are still running on devices %v", pids, uuids)
+		}
+
+		if action == configapi.KillLeftoverProcesses {
+			for _, pid := range pids {
+				klog.Infof("Killing leftover process %d on devices %v", pid, uuids)
+				if err := l.killProcess(pid); err != nil {
+					return fmt.Errorf("error killing leftover process %d: %w", pid, err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("processes %v are still running on devices %v: %w", pids, uuids, ctx.Err())
+		case <-time.After(leftoverProcessPollInterval):
+		}
+	}
+}
+
+// resetGpu resets a GPU, which also clears its memory. As with time-slice
+// intervals, NVML does not expose this, so it is done through nvidia-smi. If
+// nvidia-smi is not available, ErrGpuResetNotSupported is returned.
+func (l deviceLib) resetGpu(uuid string) error {
+	if l.nvidiaSMIPath == "" {
+		return ErrGpuResetNotSupported
+	}
+	cmd := exec.Command(l.nvidiaSMIPath, "--gpu-reset", "-i", uuid)
+
+	// In order for nvidia-smi to run, we need update LD_PRELOAD to include the path to libnvidia-ml.so.1.
+	cmd.Env = setOrOverrideEnvvar(os.Environ(), "LD_PRELOAD", prependPathListEnvvar("LD_PRELOAD", l.driverLibraryPath))
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		klog.Errorf("\n%v", string(output))
+		return fmt.Errorf("error running nvidia-smi: %w", err)
+	}
+	return nil
+}
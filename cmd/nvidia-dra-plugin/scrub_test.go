@@ -0,0 +1,79 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/require"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestStopLeftoverProcesses(t *testing.T) {
+	dir := t.TempDir()
+	topologyPath := filepath.Join(dir, "topology.yaml")
+	topology := `
+gpus:
+- model: A100-SXM4-80GB
+  migDevices: [3g.40gb]
+- model: A100-SXM4-80GB
+`
+	require.NoError(t, os.WriteFile(topologyPath, []byte(topology), 0644))
+
+	l, err := newMockDeviceLib(root(filepath.Join(dir, "driver")), topologyPath)
+	require.NoError(t, err)
+	mock := l.nvmllib.(*mockNvml)
+	gpu := mock.devices[1].uuid
+	var mig string
+	for _, device := range mock.devices[0].migDevices {
+		if device != nil {
+			mig = device.uuid
+		}
+	}
+	require.NotEmpty(t, mig)
+
+	// Nothing to do without processes.
+	require.NoError(t, l.stopLeftoverProcesses(context.Background(), []string{gpu, mig}, configapi.FailOnLeftoverProcesses))
+
+	require.Equal(t, nvml.SUCCESS, mock.startProcess(gpu, 100))
+	require.Equal(t, nvml.SUCCESS, mock.startProcess(mig, 200))
+	pids, err := l.getComputeProcesses([]string{gpu, mig})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{100, 200}, pids)
+
+	// Processes on the parent of a MIG device are reported for it too.
+	pids, err = l.getComputeProcesses([]string{mock.devices[0].uuid})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{200}, pids)
+
+	// Processes that do not exit fail scrubbing.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, l.stopLeftoverProcesses(ctx, []string{gpu, mig}, configapi.FailOnLeftoverProcesses))
+
+	require.NoError(t, l.stopLeftoverProcesses(context.Background(), []string{gpu, mig}, configapi.KillLeftoverProcesses))
+	pids, err = l.getComputeProcesses([]string{gpu, mig})
+	require.NoError(t, err)
+	require.Empty(t, pids)
+}
@@ -18,28 +18,20 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	goerrors "errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"slices"
 	"strconv"
 	"strings"
-	"text/template"
-	"time"
-
-	appsv1 "k8s.io/api/apps/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/apimachinery/pkg/util/yaml"
-	"k8s.io/client-go/util/retry"
+	"sync"
+
+	resourceapi "k8s.io/api/resource/v1beta1"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 
@@ -55,19 +47,65 @@ const (
 	MpsControlDaemonNameFmt      = "mps-control-daemon-%v" // Fill with ClaimUID
 )
 
+// These constants name the backends running MPS control daemons.
+const (
+	MpsDeploymentBackendName = "deployment"
+	MpsProcessBackendName    = "process"
+)
+
 type TimeSlicingManager struct {
 	nvdevlib *deviceLib
+
+	// timeSlices are the time-slice intervals last set on each GPU. The
+	// interval cannot be queried from the driver, so GPUs not set since the
+	// plugin started are assumed to use the default interval.
+	mutex      sync.Mutex
+	timeSlices map[string]configapi.TimeSliceInterval
+}
+
+// GpuSettings are the device-wide settings of a GPU that applying a GPU or
+// sharing config may change.
+type GpuSettings struct {
+	ComputeMode string                       `json:"computeMode"`
+	TimeSlice   *configapi.TimeSliceInterval `json:"timeSlice,omitempty"`
+	// PowerLimit is in milliwatts. It and ApplicationClocks are nil if the
+	// GPU does not support changing them.
+	PowerLimit        *uint32                      `json:"powerLimit,omitempty"`
+	ApplicationClocks *configapi.ApplicationClocks `json:"applicationClocks,omitempty"`
+	// ResetLockedClocks is set once a claim has locked the graphics clocks
+	// of the GPU, as locked clocks cannot be read back to be restored.
+	ResetLockedClocks bool `json:"resetLockedClocks,omitempty"`
 }
 
 type MpsManager struct {
 	config           *Config
 	controlFilesRoot string
-	hostDriverRoot   string
-	templatePath     string
+	backend          MpsControlDaemonBackend
 
 	nvdevlib *deviceLib
 }
 
+// MpsControlDaemonBackend runs the MPS control daemons of an MpsManager. The
+// manager creates the directories of a control daemon and sets the compute
+// mode of its GPUs before starting it, and removes the directories once it
+// has been deleted.
+type MpsControlDaemonBackend interface {
+	// IsStarted reports whether the control daemon with the given ID has
+	// been started.
+	IsStarted(ctx context.Context, id string) (bool, error)
+	// Start starts a control daemon with the given settings.
+	Start(ctx context.Context, daemon *MpsControlDaemon, settings *MpsControlDaemonTemplateData) error
+	// AssertReady waits for a started control daemon to accept clients.
+	AssertReady(ctx context.Context, daemon *MpsControlDaemon) error
+	// Adopt takes over a control daemon started by a previous instance of
+	// the plugin.
+	Adopt(ctx context.Context, daemon *MpsControlDaemon) error
+	// Delete stops the control daemon with the given ID, if started.
+	Delete(ctx context.Context, id string) error
+	// List returns the IDs of all control daemons started on this node.
+	List(ctx context.Context) ([]string, error)
+}
+
 type MpsControlDaemon struct {
 	id        string
 	nodeName  string
@@ -96,8 +134,36 @@ type MpsControlDaemonTemplateData struct {
 
 func NewTimeSlicingManager(deviceLib *deviceLib) *TimeSlicingManager {
 	return &TimeSlicingManager{
-		nvdevlib: deviceLib,
+		nvdevlib:   deviceLib,
+		timeSlices: make(map[string]configapi.TimeSliceInterval),
+	}
+}
+
+// GetGpuSettings returns the current settings of the given GPUs by UUID.
+func (t *TimeSlicingManager) GetGpuSettings(uuids []string) (map[string]*GpuSettings, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	settings := make(map[string]*GpuSettings)
+	for _, uuid := range uuids {
+		mode, err := t.nvdevlib.getComputeMode(uuid)
+		if err != nil {
+			return nil, fmt.Errorf("error getting compute mode of GPU %v: %w", uuid, err)
+		}
+		powerLimit, applicationClocks, err := t.nvdevlib.getPowerAndClocks(uuid)
+		if err != nil {
+			return nil, fmt.Errorf("error getting power limit and clocks of GPU %v: %w", uuid, err)
+		}
+		settings[uuid] = &GpuSettings{
+			ComputeMode:       mode,
+			PowerLimit:        powerLimit,
+			ApplicationClocks: applicationClocks,
+		}
+		if interval, exists := t.timeSlices[uuid]; exists {
+			settings[uuid].TimeSlice = &interval
+		}
 	}
+	return settings, nil
 }
 
 func (t *TimeSlicingManager) SetTimeSlice(devices UUIDProvider, config *configapi.TimeSlicingConfig) error {
@@ -112,20 +178,61 @@ func (t *TimeSlicingManager) SetTimeSlice(devices UUIDProvider, config *configap
 		return fmt.Errorf("error setting compute mode: %w", err)
 	}
 
-	// Set the time slice based on the config provided.
+	// Set the time slice based on the config provided. GPUs are left at the
+	// default interval if it cannot be set.
 	err = t.nvdevlib.setTimeSlice(devices.UUIDs(), config.Interval.Int())
-	if err != nil {
+	switch {
+	case goerrors.Is(err, ErrTimeSliceNotSupported) && *config.Interval == configapi.DefaultTimeSlice:
+		klog.V(4).Infof("Not setting default time slice: %v", err)
+	case err != nil:
 		return fmt.Errorf("error setting time slice: %w", err)
 	}
 
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, uuid := range devices.UUIDs() {
+		t.timeSlices[uuid] = *config.Interval
+	}
+
+	return nil
+}
+
+// RestoreGpuSettings applies previously recorded settings to the given GPUs.
+// GPUs recorded without a time-slice interval get the default interval.
+func (t *TimeSlicingManager) RestoreGpuSettings(settings map[string]*GpuSettings) error {
+	for _, uuid := range slices.Sorted(maps.Keys(settings)) {
+		interval := configapi.DefaultTimeSlice
+		if settings[uuid].TimeSlice != nil {
+			interval = *settings[uuid].TimeSlice
+		}
+
+		err := t.nvdevlib.setTimeSlice([]string{uuid}, interval.Int())
+		switch {
+		case goerrors.Is(err, ErrTimeSliceNotSupported) && interval == configapi.DefaultTimeSlice:
+			klog.V(4).Infof("Not restoring default time slice: %v", err)
+		case err != nil:
+			return fmt.Errorf("error restoring time slice of GPU %v: %w", uuid, err)
+		}
+
+		if err := t.nvdevlib.setComputeMode([]string{uuid}, settings[uuid].ComputeMode); err != nil {
+			return fmt.Errorf("error restoring compute mode of GPU %v: %w", uuid, err)
+		}
+
+		if err := t.nvdevlib.restorePowerAndClocks(uuid, settings[uuid]); err != nil {
+			return fmt.Errorf("error restoring power limit and clocks of GPU %v: %w", uuid, err)
+		}
+
+		t.mutex.Lock()
+		t.timeSlices[uuid] = interval
+		t.mutex.Unlock()
+	}
 	return nil
 }
 
-func NewMpsManager(config *Config, deviceLib *deviceLib, controlFilesRoot, hostDriverRoot, templatePath string) *MpsManager {
+func NewMpsManager(config *Config, deviceLib *deviceLib, controlFilesRoot string, backend MpsControlDaemonBackend) *MpsManager {
 	return &MpsManager{
 		controlFilesRoot: controlFilesRoot,
-		hostDriverRoot:   hostDriverRoot,
-		templatePath:     templatePath,
+		backend:          backend,
 		config:           config,
 		nvdevlib:         deviceLib,
 	}
@@ -155,27 +262,7 @@ func (m *MpsManager) GetMpsControlDaemonID(claimUID string, devices UUIDProvider
 }
 
 func (m *MpsManager) IsControlDaemonStarted(ctx context.Context, id string) (bool, error) {
-	name := fmt.Sprintf(MpsControlDaemonNameFmt, id)
-	_, err := m.config.clientsets.Core.AppsV1().Deployments(m.config.flags.namespace).Get(ctx, name, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		return false, nil
-	}
-	if err != nil {
-		return false, fmt.Errorf("failed to get deployment: %w", err)
-	}
-	return true, nil
-}
-
-func (m *MpsManager) IsControlDaemonStopped(ctx context.Context, id string) (bool, error) {
-	name := fmt.Sprintf(MpsControlDaemonNameFmt, id)
-	_, err := m.config.clientsets.Core.AppsV1().Deployments(m.config.flags.namespace).Get(ctx, name, metav1.GetOptions{})
-	if errors.IsNotFound(err) {
-		return true, nil
-	}
-	if err != nil {
-		return false, fmt.Errorf("failed to get deployment: %w", err)
-	}
-	return false, nil
+	return m.backend.IsStarted(ctx, id)
 }
 
 func (m *MpsControlDaemon) GetID() string {
@@ -202,7 +289,6 @@ func (m *MpsControlDaemon) Start(ctx context.Context, config *configapi.MpsConfi
 		CUDA_VISIBLE_DEVICES:            strings.Join(deviceUUIDs, ","),
 		DefaultActiveThreadPercentage:   "",
 		DefaultPinnedDeviceMemoryLimits: nil,
-		NvidiaDriverRoot:                m.manager.hostDriverRoot,
 		MpsShmDirectory:                 m.shmDir,
 		MpsPipeDirectory:                m.pipeDir,
 		MpsLogDirectory:                 m.logDir,
@@ -220,28 +306,6 @@ func (m *MpsControlDaemon) Start(ctx context.Context, config *configapi.MpsConfi
 		templateData.DefaultPinnedDeviceMemoryLimits = limits
 	}
 
-	tmpl, err := template.ParseFiles(m.manager.templatePath)
-	if err != nil {
-		return fmt.Errorf("failed to parse template file: %w", err)
-	}
-
-	var deploymentYaml bytes.Buffer
-	if err := tmpl.Execute(&deploymentYaml, templateData); err != nil {
-		return fmt.Errorf("failed to execute template: %w", err)
-	}
-
-	var unstructuredObj unstructured.Unstructured
-	err = yaml.Unmarshal(deploymentYaml.Bytes(), &unstructuredObj)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal yaml: %w", err)
-	}
-
-	var deployment appsv1.Deployment
-	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredObj.UnstructuredContent(), &deployment)
-	if err != nil {
-		return fmt.Errorf("failed to convert unstructured data to typed object: %w", err)
-	}
-
 	err = os.MkdirAll(m.shmDir, 0755)
 	if err != nil {
 		return fmt.Errorf("error creating directory %v: %w", m.shmDir, err)
@@ -275,72 +339,11 @@ func (m *MpsControlDaemon) Start(ctx context.Context, config *configapi.MpsConfi
 		return fmt.Errorf("error setting compute mode: %w", err)
 	}
 
-	_, err = m.manager.config.clientsets.Core.AppsV1().Deployments(m.namespace).Create(ctx, &deployment, metav1.CreateOptions{})
-	if errors.IsAlreadyExists(err) {
-		return nil
-	}
-	if err != nil {
-		return fmt.Errorf("failed to create deployment: %w", err)
-	}
-
-	return nil
+	return m.manager.backend.Start(ctx, m, &templateData)
 }
 
 func (m *MpsControlDaemon) AssertReady(ctx context.Context) error {
-	backoff := wait.Backoff{
-		Duration: time.Second,
-		Factor:   2,
-		Jitter:   1,
-		Steps:    4,
-		Cap:      10 * time.Second,
-	}
-
-	return retry.OnError(
-		backoff,
-		func(error) bool {
-			return true
-		},
-		func() error {
-			deployment, err := m.manager.config.clientsets.Core.AppsV1().Deployments(m.namespace).Get(
-				ctx,
-				m.name,
-				metav1.GetOptions{},
-			)
-			if err != nil {
-				return fmt.Errorf("failed to get deployment: %w", err)
-			}
-
-			if deployment.Status.ReadyReplicas != 1 {
-				return fmt.Errorf("waiting for MPS control daemon to come online")
-			}
-
-			selector := deployment.Spec.Selector.MatchLabels
-
-			pods, err := m.manager.config.clientsets.Core.CoreV1().Pods(m.namespace).List(
-				ctx,
-				metav1.ListOptions{
-					LabelSelector: labels.Set(selector).AsSelector().String(),
-				},
-			)
-			if err != nil {
-				return fmt.Errorf("error listing pods from deployment")
-			}
-
-			if len(pods.Items) != 1 {
-				return fmt.Errorf("unexpected number of pods in deployment: %v", len(pods.Items))
-			}
-
-			if len(pods.Items[0].Status.ContainerStatuses) != 1 {
-				return fmt.Errorf("unexpected number of container statuses in pod")
-			}
-
-			if !pods.Items[0].Status.ContainerStatuses[0].Ready {
-				return fmt.Errorf("control daemon not yet ready")
-			}
-
-			return nil
-		},
-	)
+	return m.manager.backend.AssertReady(ctx, m)
 }
 
 func (m *MpsControlDaemon) GetCDIContainerEdits() *cdiapi.ContainerEdits {
@@ -365,6 +368,63 @@ func (m *MpsControlDaemon) GetCDIContainerEdits() *cdiapi.ContainerEdits {
 	}
 }
 
+// getMpsClientContainerEdits returns the edits that pass the client settings
+// of an MPS config to containers using the devices allocated for each request.
+// Client configs of requests without devices in the results are skipped, as
+// they belong to devices that the config is applied to elsewhere, if at all.
+func getMpsClientContainerEdits(config *configapi.MpsConfig, results []*resourceapi.DeviceRequestAllocationResult) (map[string]*cdiapi.ContainerEdits, error) {
+	if config == nil || len(config.ClientConfigs) == 0 {
+		return nil, nil
+	}
+
+	numDevices := make(map[string]int)
+	for _, r := range results {
+		numDevices[r.Request]++
+	}
+
+	edits := make(map[string]*cdiapi.ContainerEdits)
+	for request, client := range config.ClientConfigs {
+		if numDevices[request] == 0 {
+			continue
+		}
+
+		var env []string
+		if client.ActiveThreadPercentage != nil {
+			env = append(env, fmt.Sprintf("CUDA_MPS_ACTIVE_THREAD_PERCENTAGE=%d", *client.ActiveThreadPercentage))
+		}
+		limit, err := client.PinnedDeviceMemoryLimitString(numDevices[request])
+		if err != nil {
+			return nil, fmt.Errorf("error getting pinned device memory limit for request '%v': %w", request, err)
+		}
+		if limit != "" {
+			env = append(env, fmt.Sprintf("CUDA_MPS_PINNED_DEVICE_MEM_LIMIT=%s", limit))
+		}
+		if client.Priority != nil {
+			env = append(env, fmt.Sprintf("CUDA_MPS_CLIENT_PRIORITY=%d", *client.Priority))
+		}
+		if len(env) == 0 {
+			continue
+		}
+
+		edits[request] = &cdiapi.ContainerEdits{
+			ContainerEdits: &cdispec.ContainerEdits{
+				Env: env,
+			},
+		}
+	}
+	return edits, nil
+}
+
+// Adopt verifies that a control daemon started by a previous instance of the
+// plugin still exists, so that it can be used as is.
+func (m *MpsControlDaemon) Adopt(ctx context.Context) error {
+	if _, err := os.Stat(m.rootDir); err != nil {
+		return fmt.Errorf("error checking root directory %v: %w", m.rootDir, err)
+	}
+
+	return m.manager.backend.Adopt(ctx, m)
+}
+
 func (m *MpsControlDaemon) Stop(ctx context.Context) error {
 	_, err := os.Stat(m.rootDir)
 	if os.IsNotExist(err) {
@@ -373,15 +433,49 @@ func (m *MpsControlDaemon) Stop(ctx context.Context) error {
 
 	klog.Infof("Stopping MPS control daemon for '%v'", m.id)
 
-	deletePolicy := metav1.DeletePropagationForeground
-	deleteOptions := metav1.DeleteOptions{
-		PropagationPolicy: &deletePolicy,
+	if err := m.manager.DeleteControlDaemon(ctx, m.id); err != nil {
+		return err
+	}
+
+	return m.manager.RemoveControlDaemonRootDir(m.id)
+}
+
+// ListControlDaemons returns the IDs of all MPS control daemons run on this
+// node by the backend.
+func (m *MpsManager) ListControlDaemons(ctx context.Context) ([]string, error) {
+	return m.backend.List(ctx)
+}
+
+// ListControlDaemonRootDirs returns the IDs of all MPS control daemons with a
+// root directory on this node.
+func (m *MpsManager) ListControlDaemonRootDirs() ([]string, error) {
+	entries, err := os.ReadDir(m.controlFilesRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %v: %w", m.controlFilesRoot, err)
 	}
 
-	err = m.manager.config.clientsets.Core.AppsV1().Deployments(m.namespace).Delete(ctx, m.name, deleteOptions)
-	if err != nil && !errors.IsNotFound(err) {
-		return fmt.Errorf("failed to delete deployment: %w", err)
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
 	}
+	return ids, nil
+}
+
+// DeleteControlDaemon stops an MPS control daemon run by the backend.
+func (m *MpsManager) DeleteControlDaemon(ctx context.Context, id string) error {
+	return m.backend.Delete(ctx, id)
+}
+
+// RemoveControlDaemonRootDir unmounts the tmpfs of an MPS control daemon and
+// removes its root directory.
+func (m *MpsManager) RemoveControlDaemonRootDir(id string) error {
+	rootDir := fmt.Sprintf("%s/%s", m.controlFilesRoot, id)
+	shmDir := fmt.Sprintf("%s/%s/%s", m.controlFilesRoot, id, "shm")
 
 	mountExecutable, err := exec.LookPath("mount")
 	if err != nil {
@@ -389,14 +483,14 @@ func (m *MpsControlDaemon) Stop(ctx context.Context) error {
 	}
 
 	mounter := mount.New(mountExecutable)
-	err = mount.CleanupMountPoint(m.shmDir, mounter, true)
+	err = mount.CleanupMountPoint(shmDir, mounter, true)
 	if err != nil {
-		return fmt.Errorf("error unmounting %v: %w", m.shmDir, err)
+		return fmt.Errorf("error unmounting %v: %w", shmDir, err)
 	}
 
-	err = os.RemoveAll(m.rootDir)
+	err = os.RemoveAll(rootDir)
 	if err != nil {
-		return fmt.Errorf("error removing directory %v: %w", m.rootDir, err)
+		return fmt.Errorf("error removing directory %v: %w", rootDir, err)
 	}
 
 	return nil
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestRestoreGpuSettings(t *testing.T) {
+	long := configapi.LongTimeSlice
+
+	testCases := []struct {
+		description   string
+		settings      map[string]*GpuSettings
+		expectedMode  string
+		expectedError bool
+	}{
+		{
+			description: "restore compute mode",
+			settings: map[string]*GpuSettings{
+				"GPU-0": {ComputeMode: "PROHIBITED"},
+			},
+			expectedMode: "PROHIBITED",
+		},
+		{
+			description: "time slice cannot be restored",
+			settings: map[string]*GpuSettings{
+				"GPU-0": {ComputeMode: "PROHIBITED", TimeSlice: &long},
+			},
+			expectedMode:  "EXCLUSIVE_PROCESS",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tsManager := NewTimeSlicingManager(&deviceLib{
+				nvmllib: &fakeNvml{
+					devices: map[string]*fakeNvmlDevice{
+						"GPU-0": {computeMode: nvml.COMPUTEMODE_EXCLUSIVE_PROCESS},
+					},
+				},
+			})
+
+			err := tsManager.RestoreGpuSettings(tc.settings)
+			if tc.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			mode, err := tsManager.nvdevlib.getComputeMode("GPU-0")
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedMode, mode)
+		})
+	}
+}
+
+func TestGetMpsClientContainerEdits(t *testing.T) {
+	results := []*resourceapi.DeviceRequestAllocationResult{
+		{Request: "training", Device: "gpu-0"},
+		{Request: "training", Device: "gpu-1"},
+		{Request: "inference", Device: "gpu-2"},
+	}
+
+	testCases := []struct {
+		description   string
+		config        *configapi.MpsConfig
+		expectedEnv   map[string][]string
+		expectedError bool
+	}{
+		{
+			description: "no client configs",
+			config:      &configapi.MpsConfig{},
+			expectedEnv: map[string][]string{},
+		},
+		{
+			description: "client configs per request",
+			config: &configapi.MpsConfig{
+				ClientConfigs: map[string]configapi.MpsClientConfig{
+					"training": {
+						ActiveThreadPercentage:  ptr.To(80),
+						PinnedDeviceMemoryLimit: ptr.To(resource.MustParse("2Gi")),
+					},
+					"inference": {
+						ActiveThreadPercentage: ptr.To(20),
+						Priority:               ptr.To(1),
+					},
+				},
+			},
+			expectedEnv: map[string][]string{
+				"training": {
+					"CUDA_MPS_ACTIVE_THREAD_PERCENTAGE=80",
+					"CUDA_MPS_PINNED_DEVICE_MEM_LIMIT=0=2048M,1=2048M",
+				},
+				"inference": {
+					"CUDA_MPS_ACTIVE_THREAD_PERCENTAGE=20",
+					"CUDA_MPS_CLIENT_PRIORITY=1",
+				},
+			},
+		},
+		{
+			description: "client config for unknown request",
+			config: &configapi.MpsConfig{
+				ClientConfigs: map[string]configapi.MpsClientConfig{
+					"other": {Priority: ptr.To(0)},
+				},
+			},
+			expectedEnv: map[string][]string{},
+		},
+		{
+			description: "pinned device memory limit too low",
+			config: &configapi.MpsConfig{
+				ClientConfigs: map[string]configapi.MpsClientConfig{
+					"training": {PinnedDeviceMemoryLimit: ptr.To(resource.MustParse("1Ki"))},
+				},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			edits, err := getMpsClientContainerEdits(tc.config, results)
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			env := make(map[string][]string)
+			for request, e := range edits {
+				env[request] = e.Env
+			}
+			require.Equal(t, tc.expectedEnv, env)
+		})
+	}
+}
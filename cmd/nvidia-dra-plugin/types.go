@@ -17,11 +17,12 @@
 package main
 
 const (
-	GpuDeviceType     = "gpu"
-	MigDeviceType     = "mig"
-	ImexChannelType   = "imex"
-	VGpuDeviceType    = "vgpu"
-	UnknownDeviceType = "unknown"
+	GpuDeviceType         = "gpu"
+	MigDeviceType         = "mig"
+	ImexChannelType       = "imex"
+	VGpuDeviceType        = "vgpu"
+	NvlinkGroupDeviceType = "nvlink-group"
+	UnknownDeviceType     = "unknown"
 )
 
 type UUIDProvider interface {
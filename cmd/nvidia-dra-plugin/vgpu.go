@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	cdiapi "tags.cncf.io/container-device-interface/pkg/cdi"
+	cdispec "tags.cncf.io/container-device-interface/specs-go"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+const (
+	VGpuRoot                = DriverPluginPath + "/vgpu"
+	VGpuActivePriorityFile  = "active-priority"
+	DefaultVGpuSplitCount   = 10
+	DefaultVGpuLibraryPath  = "/usr/local/vgpu/libvgpu.so"
+	VGpuContainerLibraryDir = "/usr/local/vgpu"
+
+	VGpuMemoryLimitEnvvar           = "CUDA_DEVICE_MEMORY_LIMIT"
+	VGpuCoreLimitEnvvar             = "CUDA_DEVICE_SM_LIMIT"
+	VGpuThrottledCoreLimitEnvvar    = "CUDA_DEVICE_SM_LIMIT_THROTTLED"
+	VGpuPriorityEnvvar              = "CUDA_TASK_PRIORITY"
+	VGpuPriorityControlDirsEnvvar   = "CUDA_TASK_PRIORITY_CONTROL_DIRS"
+	DefaultVGpuLowPriorityCoreShare = 0
+)
+
+// VGpuManager resolves the limits of vGPUs and the CDI edits that enforce
+// them through the interception library.
+//
+// Priority is enforced cooperatively with the interception library. For each
+// physical GPU, the manager maintains a control directory holding the highest
+// priority of all vGPU claims currently prepared on it. A container whose own
+// priority is lower than the active priority of its GPU throttles itself to
+// its reduced core share, or suspends entirely if that share is 0.
+type VGpuManager struct {
+	// priorityLock serializes updates of the active priority files, which
+	// may be triggered by claims prepared concurrently.
+	priorityLock sync.Mutex
+
+	libraryPath          string
+	controlFilesRoot     string
+	lowPriorityCoreShare int64
+}
+
+// VGpuParentDevices is a UUIDProvider for the full GPUs and MIG devices
+// backing a set of vGPUs, used to apply device-wide settings such as
+// time-slicing and MPS.
+type VGpuParentDevices []*VGpuInfo
+
+// VGpuLimits holds the limits resolved from a VGpuConfig for a single vGPU.
+type VGpuLimits struct {
+	// Core is the percentage of the GPU's multiprocessors the vGPU may use.
+	Core int64 `json:"core"`
+	// MemoryBytes is the amount of device memory the vGPU may allocate.
+	MemoryBytes uint64 `json:"memoryBytes"`
+	// Priority is the scheduling priority of the vGPU on its physical GPU.
+	Priority configapi.Priority `json:"priority"`
+}
+
+func NewVGpuManager(config *Config, controlFilesRoot string) *VGpuManager {
+	return &VGpuManager{
+		libraryPath:          config.flags.vgpuLibraryPath,
+		controlFilesRoot:     controlFilesRoot,
+		lowPriorityCoreShare: int64(config.flags.vgpuLowPriorityCoreShare),
+	}
+}
+
+// GetLimits resolves the limits a VGpuConfig imposes on a given vGPU.
+//
+// The memory limit is the smaller of the absolute Memory setting and the
+// MemoryPercentage of the device's total memory. An unset MemoryPercentage
+// caps the memory limit to the device's total memory.
+func (m *VGpuManager) GetLimits(info *VGpuInfo, config *configapi.VGpuConfig) *VGpuLimits {
+	memoryPercentage := config.MemoryPercentage
+	if memoryPercentage <= 0 {
+		memoryPercentage = configapi.DefaultMemoryPercentage
+	}
+	memoryBytes := uint64(config.Memory) * 1024 * 1024
+	if percentageBytes := info.parentMemoryBytes() * uint64(memoryPercentage) / 100; percentageBytes < memoryBytes {
+		memoryBytes = percentageBytes
+	}
+	return &VGpuLimits{
+		Core:        config.Core,
+		MemoryBytes: memoryBytes,
+		Priority:    config.Priority,
+	}
+}
+
+// GetCDIContainerEdits returns the edits that inject the interception library
+// into a container along with the environment it reads its limits from. When
+// a group spans multiple vGPUs, the most restrictive limits are applied.
+func (m *VGpuManager) GetCDIContainerEdits(claimUID string, vgpus []*VGpuInfo, limits []*VGpuLimits) *cdiapi.ContainerEdits {
+	if len(limits) == 0 {
+		return nil
+	}
+
+	core := limits[0].Core
+	memoryBytes := limits[0].MemoryBytes
+	priority := limits[0].Priority
+	for _, l := range limits[1:] {
+		core = min(core, l.Core)
+		memoryBytes = min(memoryBytes, l.MemoryBytes)
+		priority = min(priority, l.Priority)
+	}
+
+	containerLibraryPath := filepath.Join(VGpuContainerLibraryDir, filepath.Base(m.libraryPath))
+	containerClaimDir := filepath.Join(VGpuContainerLibraryDir, "claim")
+
+	edits := &cdiapi.ContainerEdits{
+		ContainerEdits: &cdispec.ContainerEdits{
+			Env: []string{
+				fmt.Sprintf("%s=%dm", VGpuMemoryLimitEnvvar, memoryBytes/1024/1024),
+				fmt.Sprintf("%s=%d", VGpuCoreLimitEnvvar, core),
+				fmt.Sprintf("%s=%d", VGpuThrottledCoreLimitEnvvar, core*m.lowPriorityCoreShare/100),
+				fmt.Sprintf("%s=%d", VGpuPriorityEnvvar, priority),
+				fmt.Sprintf("%s=%s", VGpuUsageFileEnvvar, filepath.Join(containerClaimDir, VGpuUsageFile)),
+				fmt.Sprintf("LD_PRELOAD=%s", containerLibraryPath),
+			},
+			Mounts: []*cdispec.Mount{
+				{
+					ContainerPath: containerLibraryPath,
+					HostPath:      m.libraryPath,
+					Options:       []string{"ro", "nosuid", "nodev", "bind"},
+				},
+				{
+					ContainerPath: containerClaimDir,
+					HostPath:      m.claimControlDir(claimUID),
+					Options:       []string{"rw", "nosuid", "nodev", "bind"},
+				},
+			},
+		},
+	}
+
+	// Mount the priority control directory of each distinct parent GPU.
+	var controlDirs []string
+	for _, vgpu := range vgpus {
+		containerPath := filepath.Join(VGpuContainerLibraryDir, "gpus", vgpu.ParentUUID)
+		if slices.Contains(controlDirs, containerPath) {
+			continue
+		}
+		controlDirs = append(controlDirs, containerPath)
+		edits.Mounts = append(edits.Mounts, &cdispec.Mount{
+			ContainerPath: containerPath,
+			HostPath:      m.gpuControlDir(vgpu.ParentUUID),
+			Options:       []string{"ro", "nosuid", "nodev", "bind"},
+		})
+	}
+	edits.Env = append(edits.Env, fmt.Sprintf("%s=%s", VGpuPriorityControlDirsEnvvar, strings.Join(controlDirs, ":")))
+
+	return edits
+}
+
+// SetActivePriorities records the highest priority of the vGPU claims
+// currently prepared on each physical GPU in the GPU's control directory.
+func (m *VGpuManager) SetActivePriorities(priorities map[string]configapi.Priority) error {
+	m.priorityLock.Lock()
+	defer m.priorityLock.Unlock()
+
+	for uuid, priority := range priorities {
+		dir := m.gpuControlDir(uuid)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating directory %v: %w", dir, err)
+		}
+
+		// Write to a temporary file and rename it into place so that readers
+		// never observe a partially written value.
+		path := filepath.Join(dir, VGpuActivePriorityFile)
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d\n", priority)), 0644); err != nil {
+			return fmt.Errorf("error writing %v: %w", tmp, err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("error renaming %v to %v: %w", tmp, path, err)
+		}
+	}
+	return nil
+}
+
+func (m *VGpuManager) gpuControlDir(uuid string) string {
+	return filepath.Join(m.controlFilesRoot, "gpus", uuid)
+}
+
+func (p VGpuParentDevices) UUIDs() []string {
+	uuids := append(p.GpuUUIDs(), p.MigDeviceUUIDs()...)
+	slices.Sort(uuids)
+	return uuids
+}
+
+func (p VGpuParentDevices) GpuUUIDs() []string {
+	var uuids []string
+	for _, vgpu := range p {
+		if !vgpu.IsMigBacked() {
+			uuids = append(uuids, vgpu.ParentUUID)
+		}
+	}
+	slices.Sort(uuids)
+	return slices.Compact(uuids)
+}
+
+func (p VGpuParentDevices) MigDeviceUUIDs() []string {
+	var uuids []string
+	for _, vgpu := range p {
+		if vgpu.IsMigBacked() {
+			uuids = append(uuids, vgpu.ParentUUID)
+		}
+	}
+	slices.Sort(uuids)
+	return slices.Compact(uuids)
+}
@@ -0,0 +1,147 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestCheckVGpuConflicts(t *testing.T) {
+	dir := t.TempDir()
+	topologyPath := filepath.Join(dir, "topology.yaml")
+	topology := `
+gpus:
+- model: A100-SXM4-80GB
+  count: 2
+- model: A100-SXM4-80GB
+  migDevices: [3g.40gb, 3g.40gb]
+`
+	require.NoError(t, os.WriteFile(topologyPath, []byte(topology), 0644))
+
+	l, err := newMockDeviceLib(root(filepath.Join(dir, "driver")), topologyPath)
+	require.NoError(t, err)
+
+	config := &Config{
+		flags: &Flags{
+			deviceClasses:  sets.New(GpuDeviceType, MigDeviceType, VGpuDeviceType),
+			vgpuSplitCount: 2,
+		},
+	}
+	allocatable, err := l.enumerateAllPossibleDevices(config)
+	require.NoError(t, err)
+	state := &DeviceState{allocatable: allocatable}
+
+	prepared := func(name string) PreparedDevices {
+		var device PreparedDevice
+		switch allocatable[name].Type() {
+		case GpuDeviceType:
+			device.Gpu = &PreparedGpu{Info: allocatable[name].Gpu}
+		case MigDeviceType:
+			device.Mig = &PreparedMigDevice{Info: allocatable[name].Mig}
+		case VGpuDeviceType:
+			device.VGpu = &PreparedVGpu{Info: allocatable[name].VGPU}
+		}
+		return PreparedDevices{{Devices: PreparedDeviceList{device}}}
+	}
+	claim := func(name string) *resourceapi.ResourceClaim {
+		return &resourceapi.ResourceClaim{
+			Status: resourceapi.ResourceClaimStatus{
+				Allocation: &resourceapi.AllocationResult{
+					Devices: resourceapi.DeviceAllocationResult{
+						Results: []resourceapi.DeviceRequestAllocationResult{
+							{Driver: DriverName, Device: name},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		description   string
+		prepared      string
+		device        string
+		expectedError bool
+	}{
+		{
+			description: "vGPUs on the same GPU",
+			prepared:    "vgpu-0-0",
+			device:      "vgpu-0-1",
+		},
+		{
+			description: "full GPU without vGPUs",
+			prepared:    "vgpu-0-0",
+			device:      "gpu-1",
+		},
+		{
+			description:   "full GPU backing vGPUs",
+			prepared:      "vgpu-0-0",
+			device:        "gpu-0",
+			expectedError: true,
+		},
+		{
+			description:   "vGPU on a full GPU",
+			prepared:      "gpu-0",
+			device:        "vgpu-0-1",
+			expectedError: true,
+		},
+		{
+			description: "vGPUs on the same MIG device",
+			prepared:    "vgpu-2-mig-2-0-4-0",
+			device:      "vgpu-2-mig-2-0-4-1",
+		},
+		{
+			description: "MIG device without vGPUs",
+			prepared:    "vgpu-2-mig-2-0-4-0",
+			device:      "gpu-2-mig-2-4-4",
+		},
+		{
+			description:   "MIG device backing vGPUs",
+			prepared:      "vgpu-2-mig-2-0-4-0",
+			device:        "gpu-2-mig-2-0-4",
+			expectedError: true,
+		},
+		{
+			description:   "vGPU on a MIG device",
+			prepared:      "gpu-2-mig-2-0-4",
+			device:        "vgpu-2-mig-2-0-4-1",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			checkpoint := newCheckpoint()
+			checkpoint.V2.PreparedClaims["other"] = prepared(tc.prepared)
+
+			err := state.checkVGpuConflicts(checkpoint, claim(tc.device))
+			if tc.expectedError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -1,37 +1,144 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
 package main
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
+)
 
+// VGpuInfo represents a single vGPU slot carved out of a physical GPU or of
+// a MIG device on it.
 type VGpuInfo struct {
-	UUID                  string `json:"uuid"`
-	index                 int
-	minor                 int
-	migEnabled            bool
-	memoryBytes           uint64
-	productName           string
-	brand                 string
-	architecture          string
-	cudaComputeCapability string
-	driverVersion         string
-	cudaDriverVersion     string
-}
-
-func ConvGpuInfoToVGpuInfo(info *GpuInfo) *VGpuInfo {
-	return &VGpuInfo{
-		UUID:                  info.UUID,
-		index:                 info.index,
-		minor:                 info.minor,
-		migEnabled:            info.migEnabled,
-		memoryBytes:           info.memoryBytes,
-		productName:           info.productName,
-		brand:                 info.brand,
-		architecture:          info.architecture,
-		cudaComputeCapability: info.cudaComputeCapability,
-		driverVersion:         info.driverVersion,
-		cudaDriverVersion:     info.cudaDriverVersion,
+	ParentUUID string `json:"parentUUID"`
+	ParentType string `json:"parentType"`
+	Slot       int    `json:"slot"`
+	slotCount  int
+	parent     *GpuInfo
+	mig        *MigDeviceInfo
+}
+
+// IsMigBacked reports whether the vGPU is carved out of a MIG device rather
+// than a full GPU.
+func (d *VGpuInfo) IsMigBacked() bool {
+	return d.ParentType == MigDeviceType
+}
+
+func (d *VGpuInfo) CanonicalName() string {
+	if d.mig != nil {
+		return fmt.Sprintf("vgpu-%d-mig-%d-%d-%d-%d", d.parent.index, d.mig.giProfileInfo.Id, d.mig.placement.Start, d.mig.placement.Size, d.Slot)
+	}
+	return fmt.Sprintf("vgpu-%d-%d", d.parent.index, d.Slot)
+}
+
+// CanonicalIndex returns the index of the parent device, as a vGPU slot is
+// backed by the device nodes of the full GPU or MIG device it is carved out of.
+func (d *VGpuInfo) CanonicalIndex() string {
+	if d.mig != nil {
+		return d.mig.CanonicalIndex()
 	}
+	return d.parent.CanonicalIndex()
 }
 
-func (v *VGpuInfo) CanonicalName() string {
-	return fmt.Sprintf("gpu-%d-mig-%d-%d-%d", d.parent.index, d.giInfo.ProfileId, d.placement.Start, d.placement.Size)
+// MemoryBytes returns the share of the parent device's memory backing this slot.
+func (d *VGpuInfo) MemoryBytes() uint64 {
+	return d.parentMemoryBytes() / uint64(d.slotCount)
+}
+
+// Cores returns the percentage of the parent device's multiprocessors backing this slot.
+func (d *VGpuInfo) Cores() int64 {
+	return int64(100 / d.slotCount)
+}
+
+// parentMemoryBytes returns the total memory of the parent device.
+func (d *VGpuInfo) parentMemoryBytes() uint64 {
+	if d.mig != nil {
+		return d.mig.giProfileInfo.MemorySizeMB * 1024 * 1024
+	}
+	return d.parent.memoryBytes
+}
+
+// GetDevice returns the device of a vGPU slot.
+//
+// Until devices can consume counters shared with other devices, the
+// scheduler has no way of knowing that a slot overlaps the full GPU or MIG
+// device it is carved out of. It may allocate both to different claims,
+// which are then rejected when they are prepared. For this reason vGPU slots
+// are only published when the vgpu device class is enabled.
+func (d *VGpuInfo) GetDevice() resourceapi.Device {
+	device := resourceapi.Device{
+		Name: d.CanonicalName(),
+		Basic: &resourceapi.BasicDevice{
+			Attributes: map[resourceapi.QualifiedName]resourceapi.DeviceAttribute{
+				"type": {
+					StringValue: ptr.To(VGpuDeviceType),
+				},
+				"parentUUID": {
+					StringValue: &d.ParentUUID,
+				},
+				"parentType": {
+					StringValue: &d.ParentType,
+				},
+				"parentIndex": {
+					IntValue: ptr.To(int64(d.parent.index)),
+				},
+				"slot": {
+					IntValue: ptr.To(int64(d.Slot)),
+				},
+				"productName": {
+					StringValue: &d.parent.productName,
+				},
+				"brand": {
+					StringValue: &d.parent.brand,
+				},
+				"architecture": {
+					StringValue: &d.parent.architecture,
+				},
+				"cudaComputeCapability": {
+					VersionValue: ptr.To(semver.MustParse(d.parent.cudaComputeCapability).String()),
+				},
+				"driverVersion": {
+					VersionValue: ptr.To(semver.MustParse(d.parent.driverVersion).String()),
+				},
+				"cudaDriverVersion": {
+					VersionValue: ptr.To(semver.MustParse(d.parent.cudaDriverVersion).String()),
+				},
+			},
+			Capacity: map[resourceapi.QualifiedName]resourceapi.DeviceCapacity{
+				"memory": {
+					Value: *resource.NewQuantity(int64(d.MemoryBytes()), resource.BinarySI),
+				},
+				"cores": {
+					Value: *resource.NewQuantity(d.Cores(), resource.BinarySI),
+				},
+			},
+		},
+	}
+	if d.mig != nil {
+		device.Basic.Attributes["profile"] = resourceapi.DeviceAttribute{
+			StringValue: &d.mig.profile,
+		}
+		device.Basic.Capacity["multiprocessors"] = resourceapi.DeviceCapacity{
+			Value: *resource.NewQuantity(int64(d.mig.giProfileInfo.MultiprocessorCount)/int64(d.slotCount), resource.BinarySI),
+		}
+	}
+	return device
 }
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+// VGpuLedger tracks the vGPU core and memory committed on each full GPU or
+// MIG device backing vGPUs.
+//
+// The capacity of each device is its full set of cores and memory multiplied
+// by the configured scaling factors, allowing a device to be over-subscribed
+// by a fixed ratio.
+type VGpuLedger struct {
+	sync.Mutex
+	capacity map[string]vgpuUsage
+	claims   map[string]map[string]vgpuUsage
+}
+
+type vgpuUsage struct {
+	core        int64
+	memoryBytes uint64
+	priority    configapi.Priority
+}
+
+func NewVGpuLedger(allocatable AllocatableDevices, scaling configapi.VGpuScalingConfig) *VGpuLedger {
+	return &VGpuLedger{
+		capacity: vgpuCapacity(allocatable, scaling),
+		claims:   make(map[string]map[string]vgpuUsage),
+	}
+}
+
+// SetCapacity recomputes the capacity of each device after the allocatable
+// devices have changed. Everything already committed is retained.
+func (l *VGpuLedger) SetCapacity(allocatable AllocatableDevices, scaling configapi.VGpuScalingConfig) {
+	capacity := vgpuCapacity(allocatable, scaling)
+	l.Lock()
+	defer l.Unlock()
+	l.capacity = capacity
+}
+
+func vgpuCapacity(allocatable AllocatableDevices, scaling configapi.VGpuScalingConfig) map[string]vgpuUsage {
+	capacity := make(map[string]vgpuUsage)
+	for _, device := range allocatable {
+		if device.Type() != VGpuDeviceType {
+			continue
+		}
+		capacity[device.VGPU.ParentUUID] = vgpuUsage{
+			core:        int64(100 * scaling.CoreScaling),
+			memoryBytes: uint64(float64(device.VGPU.parentMemoryBytes()) * scaling.MemoryScaling),
+		}
+	}
+	return capacity
+}
+
+// Reserve commits the limits of a vGPU to the claim on its parent GPU. An
+// error is returned if doing so would exceed the capacity of the GPU.
+func (l *VGpuLedger) Reserve(claimUID string, info *VGpuInfo, limits *VGpuLimits) error {
+	l.Lock()
+	defer l.Unlock()
+
+	capacity, exists := l.capacity[info.ParentUUID]
+	if !exists {
+		return fmt.Errorf("no vGPU capacity known for GPU %v", info.ParentUUID)
+	}
+
+	used := l.used(info.ParentUUID)
+	if used.core+limits.Core > capacity.core || used.memoryBytes+limits.MemoryBytes > capacity.memoryBytes {
+		return fmt.Errorf(
+			"insufficient vGPU capacity on GPU %v: requested %d%% cores and %d MiB of memory, with %d%% of %d%% cores and %d of %d MiB of memory already committed",
+			info.ParentUUID,
+			limits.Core, limits.MemoryBytes/1024/1024,
+			used.core, capacity.core,
+			used.memoryBytes/1024/1024, capacity.memoryBytes/1024/1024,
+		)
+	}
+
+	l.add(claimUID, info.ParentUUID, limits)
+	return nil
+}
+
+// Restore commits the limits of an already prepared vGPU without checking
+// them against the capacity of its parent GPU.
+func (l *VGpuLedger) Restore(claimUID string, info *VGpuInfo, limits *VGpuLimits) {
+	l.Lock()
+	defer l.Unlock()
+	l.add(claimUID, info.ParentUUID, limits)
+}
+
+// Release drops everything committed on behalf of a claim.
+func (l *VGpuLedger) Release(claimUID string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.claims, claimUID)
+}
+
+// ActivePriorities returns the highest priority of the vGPUs committed on
+// each physical GPU. GPUs without any vGPUs committed report PriorityLow.
+func (l *VGpuLedger) ActivePriorities() map[string]configapi.Priority {
+	l.Lock()
+	defer l.Unlock()
+
+	priorities := make(map[string]configapi.Priority)
+	for uuid := range l.capacity {
+		priorities[uuid] = configapi.PriorityLow
+	}
+	for _, usage := range l.claims {
+		for uuid, u := range usage {
+			priorities[uuid] = max(priorities[uuid], u.priority)
+		}
+	}
+	return priorities
+}
+
+func (l *VGpuLedger) add(claimUID, parentUUID string, limits *VGpuLimits) {
+	if l.claims[claimUID] == nil {
+		l.claims[claimUID] = make(map[string]vgpuUsage)
+	}
+	usage := l.claims[claimUID][parentUUID]
+	usage.core += limits.Core
+	usage.memoryBytes += limits.MemoryBytes
+	usage.priority = max(usage.priority, limits.Priority)
+	l.claims[claimUID][parentUUID] = usage
+}
+
+func (l *VGpuLedger) used(parentUUID string) vgpuUsage {
+	var used vgpuUsage
+	for _, usage := range l.claims {
+		used.core += usage[parentUUID].core
+		used.memoryBytes += usage[parentUUID].memoryBytes
+	}
+	return used
+}
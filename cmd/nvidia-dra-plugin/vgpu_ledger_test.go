@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/require"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestVGpuLedgerReserve(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	gpu := &GpuInfo{UUID: "GPU-0", memoryBytes: 10 * gib}
+	allocatable := make(AllocatableDevices)
+	for _, vgpu := range (deviceLib{}).getVGpuSlots(gpu, 2) {
+		allocatable[vgpu.CanonicalName()] = &AllocatableDevice{VGPU: vgpu}
+	}
+	slot0 := allocatable["vgpu-0-0"].VGPU
+	slot1 := allocatable["vgpu-0-1"].VGPU
+
+	testCases := []struct {
+		description   string
+		scaling       configapi.VGpuScalingConfig
+		limits        []*VGpuLimits
+		expectedError bool
+	}{
+		{
+			description: "fits within capacity",
+			scaling:     configapi.VGpuScalingConfig{CoreScaling: 1, MemoryScaling: 1},
+			limits: []*VGpuLimits{
+				{Core: 50, MemoryBytes: 5 * gib},
+				{Core: 50, MemoryBytes: 5 * gib},
+			},
+		},
+		{
+			description: "memory over-committed",
+			scaling:     configapi.VGpuScalingConfig{CoreScaling: 1, MemoryScaling: 1},
+			limits: []*VGpuLimits{
+				{Core: 10, MemoryBytes: 8 * gib},
+				{Core: 10, MemoryBytes: 8 * gib},
+			},
+			expectedError: true,
+		},
+		{
+			description: "memory over-committed within scaling",
+			scaling:     configapi.VGpuScalingConfig{CoreScaling: 1, MemoryScaling: 2},
+			limits: []*VGpuLimits{
+				{Core: 10, MemoryBytes: 8 * gib},
+				{Core: 10, MemoryBytes: 8 * gib},
+			},
+		},
+		{
+			description: "cores over-committed",
+			scaling:     configapi.VGpuScalingConfig{CoreScaling: 1, MemoryScaling: 1},
+			limits: []*VGpuLimits{
+				{Core: 60, MemoryBytes: gib},
+				{Core: 60, MemoryBytes: gib},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ledger := NewVGpuLedger(allocatable, tc.scaling)
+
+			require.NoError(t, ledger.Reserve("claim-0", slot0, tc.limits[0]))
+			err := ledger.Reserve("claim-1", slot1, tc.limits[1])
+			if tc.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestVGpuLedgerRelease(t *testing.T) {
+	gpu := &GpuInfo{UUID: "GPU-0", memoryBytes: 1024 * 1024 * 1024}
+	allocatable := make(AllocatableDevices)
+	for _, vgpu := range (deviceLib{}).getVGpuSlots(gpu, 1) {
+		allocatable[vgpu.CanonicalName()] = &AllocatableDevice{VGPU: vgpu}
+	}
+	slot := allocatable["vgpu-0-0"].VGPU
+	limits := &VGpuLimits{Core: 100, MemoryBytes: 1024 * 1024 * 1024}
+
+	ledger := NewVGpuLedger(allocatable, configapi.VGpuScalingConfig{CoreScaling: 1, MemoryScaling: 1})
+	ledger.Restore("claim-0", slot, limits)
+	require.Error(t, ledger.Reserve("claim-1", slot, limits))
+
+	ledger.Release("claim-0")
+	require.NoError(t, ledger.Reserve("claim-1", slot, limits))
+}
+
+func TestVGpuLedgerMigCapacity(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	gpu := &GpuInfo{UUID: "GPU-0", memoryBytes: 80 * gib, migEnabled: true}
+	mig := &MigDeviceInfo{
+		UUID:          "MIG-0",
+		parent:        gpu,
+		placement:     &MigDevicePlacement{nvml.GpuInstancePlacement{Start: 4, Size: 4}},
+		giProfileInfo: &nvml.GpuInstanceProfileInfo{Id: 9, MemorySizeMB: 40 * 1024},
+	}
+	allocatable := make(AllocatableDevices)
+	for _, vgpu := range (deviceLib{}).getMigVGpuSlots(mig, 2) {
+		allocatable[vgpu.CanonicalName()] = &AllocatableDevice{VGPU: vgpu}
+	}
+	slot0 := allocatable["vgpu-0-mig-9-4-4-0"].VGPU
+	slot1 := allocatable["vgpu-0-mig-9-4-4-1"].VGPU
+	require.Equal(t, uint64(20*gib), slot0.MemoryBytes())
+
+	// The budget is the memory of the MIG device, not of the full GPU.
+	ledger := NewVGpuLedger(allocatable, configapi.VGpuScalingConfig{CoreScaling: 1, MemoryScaling: 1})
+	require.NoError(t, ledger.Reserve("claim-0", slot0, &VGpuLimits{Core: 50, MemoryBytes: 30 * gib}))
+	require.Error(t, ledger.Reserve("claim-1", slot1, &VGpuLimits{Core: 50, MemoryBytes: 30 * gib}))
+}
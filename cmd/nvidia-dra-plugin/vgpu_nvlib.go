@@ -1,7 +1,24 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
 package main
 
 import (
 	"fmt"
+
 	nvdev "github.com/NVIDIA/go-nvlib/pkg/nvlib/device"
 )
 
@@ -20,10 +37,29 @@ func (l deviceLib) enumerateVGpuDevices(config *Config) (AllocatableDevices, err
 		}
 
 		if deviceClasses.Has(VGpuDeviceType) && !gpuInfo.migEnabled {
-			deviceInfo := &AllocatableDevice{
-				VGPU: ConvGpuInfoToVGpuInfo(gpuInfo),
+			for _, vgpuInfo := range l.getVGpuSlots(gpuInfo, config.flags.vgpuSplitCount) {
+				deviceInfo := &AllocatableDevice{
+					VGPU: vgpuInfo,
+				}
+				devices[vgpuInfo.CanonicalName()] = deviceInfo
+			}
+		}
+
+		// MIG devices created on demand are not stable enough to carve vGPUs
+		// out of, so vGPUs are only backed by statically created ones.
+		if deviceClasses.Has(VGpuDeviceType) && gpuInfo.migEnabled && !config.flags.dynamicMig {
+			migs, err := l.getMigDevices(gpuInfo)
+			if err != nil {
+				return fmt.Errorf("error getting MIG devices for GPU %d: %w", i, err)
+			}
+			for _, migDeviceInfo := range migs {
+				for _, vgpuInfo := range l.getMigVGpuSlots(migDeviceInfo, config.flags.vgpuSplitCount) {
+					deviceInfo := &AllocatableDevice{
+						VGPU: vgpuInfo,
+					}
+					devices[vgpuInfo.CanonicalName()] = deviceInfo
+				}
 			}
-			devices[gpuInfo.CanonicalName()] = deviceInfo
 		}
 		return nil
 	})
@@ -33,3 +69,36 @@ func (l deviceLib) enumerateVGpuDevices(config *Config) (AllocatableDevices, err
 
 	return devices, nil
 }
+
+// getVGpuSlots splits a physical GPU into the given number of vGPU slots.
+func (l deviceLib) getVGpuSlots(gpuInfo *GpuInfo, slotCount int) []*VGpuInfo {
+	var vgpus []*VGpuInfo
+	for i := 0; i < slotCount; i++ {
+		vgpuInfo := &VGpuInfo{
+			ParentUUID: gpuInfo.UUID,
+			ParentType: GpuDeviceType,
+			Slot:       i,
+			slotCount:  slotCount,
+			parent:     gpuInfo,
+		}
+		vgpus = append(vgpus, vgpuInfo)
+	}
+	return vgpus
+}
+
+// getMigVGpuSlots splits a MIG device into the given number of vGPU slots.
+func (l deviceLib) getMigVGpuSlots(migInfo *MigDeviceInfo, slotCount int) []*VGpuInfo {
+	var vgpus []*VGpuInfo
+	for i := 0; i < slotCount; i++ {
+		vgpuInfo := &VGpuInfo{
+			ParentUUID: migInfo.UUID,
+			ParentType: MigDeviceType,
+			Slot:       i,
+			slotCount:  slotCount,
+			parent:     migInfo.parent,
+			mig:        migInfo,
+		}
+		vgpus = append(vgpus, vgpuInfo)
+	}
+	return vgpus
+}
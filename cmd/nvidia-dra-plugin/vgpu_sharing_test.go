@@ -0,0 +1,128 @@
+//go:build mock
+
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestPrepareColocatedVGpuSharing(t *testing.T) {
+	vgpuConfig := func(sharing *configapi.GpuSharing) *configapi.VGpuConfig {
+		config := configapi.DefaultVGpuConfig()
+		config.Sharing = sharing
+		return config
+	}
+	timeSlicing := func(interval configapi.TimeSliceInterval) *configapi.GpuSharing {
+		return &configapi.GpuSharing{
+			Strategy:          configapi.TimeSlicingStrategy,
+			TimeSlicingConfig: &configapi.TimeSlicingConfig{Interval: ptr.To(interval)},
+		}
+	}
+	mps := &configapi.GpuSharing{
+		Strategy:  configapi.MpsStrategy,
+		MpsConfig: &configapi.MpsConfig{},
+	}
+
+	testCases := []struct {
+		description   string
+		prepared      *configapi.GpuSharing
+		sharing       *configapi.GpuSharing
+		expectedError bool
+	}{
+		{
+			description: "no sharing",
+		},
+		{
+			description: "same time-slice interval",
+			prepared:    timeSlicing(configapi.DefaultTimeSlice),
+			sharing:     timeSlicing(configapi.DefaultTimeSlice),
+		},
+		{
+			description: "time-slicing next to no sharing",
+			prepared:    timeSlicing(configapi.ShortTimeSlice),
+		},
+		{
+			description:   "different time-slice intervals",
+			prepared:      timeSlicing(configapi.ShortTimeSlice),
+			sharing:       timeSlicing(configapi.DefaultTimeSlice),
+			expectedError: true,
+		},
+		{
+			description:   "time-slicing next to MPS",
+			prepared:      mps,
+			sharing:       timeSlicing(configapi.DefaultTimeSlice),
+			expectedError: true,
+		},
+		{
+			description:   "MPS next to MPS",
+			prepared:      mps,
+			sharing:       mps,
+			expectedError: true,
+		},
+		{
+			description:   "MPS next to no sharing",
+			sharing:       mps,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx := context.Background()
+			state := newTestDeviceState(t, "gpus:\n- model: A100-SXM4-80GB\n", &Flags{})
+
+			// The other claim is recorded as prepared on the first slot of
+			// the GPU, as MPS control daemons cannot be started in tests.
+			raw, err := json.Marshal(vgpuConfig(tc.prepared))
+			require.NoError(t, err)
+			prepared := &PreparedDeviceGroup{
+				Devices: PreparedDeviceList{
+					{VGpu: &PreparedVGpu{Info: state.allocatable["vgpu-0-0"].VGPU}},
+				},
+				ConfigState: DeviceConfigState{
+					Config: &runtime.RawExtension{Raw: raw},
+				},
+			}
+			require.NoError(t, state.updateCheckpoint(func(checkpoint *Checkpoint) {
+				checkpoint.V2.PreparedClaims["other-uid"] = PreparedDevices{prepared}
+			}))
+
+			claim := state.createClaim(t, "claim", vgpuConfig(tc.sharing), "vgpu-0-1")
+			_, err = state.Prepare(ctx, claim)
+			if !tc.expectedError {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+
+			checkpoint, err := state.getCheckpoint()
+			require.NoError(t, err)
+			require.NotContains(t, checkpoint.V2.PreparedClaims, string(claim.UID))
+			require.Zero(t, state.mps.starts)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	configapi "github.com/NVIDIA/k8s-dra-driver/api/nvidia.com/resource/gpu/v1alpha1"
+)
+
+func TestVGpuManagerGetLimits(t *testing.T) {
+	const mib = 1024 * 1024
+	info := &VGpuInfo{
+		ParentType: GpuDeviceType,
+		slotCount:  10,
+		parent:     &GpuInfo{memoryBytes: 16384 * mib},
+	}
+
+	testCases := []struct {
+		description         string
+		config              *configapi.VGpuConfig
+		expectedMemoryBytes uint64
+	}{
+		{
+			description:         "memory below percentage cap",
+			config:              &configapi.VGpuConfig{Memory: 4096, MemoryPercentage: 50},
+			expectedMemoryBytes: 4096 * mib,
+		},
+		{
+			description:         "memory above percentage cap",
+			config:              &configapi.VGpuConfig{Memory: 12288, MemoryPercentage: 50},
+			expectedMemoryBytes: 8192 * mib,
+		},
+		{
+			description:         "memory without percentage",
+			config:              &configapi.VGpuConfig{Memory: 4096},
+			expectedMemoryBytes: 4096 * mib,
+		},
+		{
+			description:         "memory above total without percentage",
+			config:              &configapi.VGpuConfig{Memory: 32768},
+			expectedMemoryBytes: 16384 * mib,
+		},
+	}
+
+	manager := &VGpuManager{}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			limits := manager.GetLimits(info, tc.config)
+			require.Equal(t, tc.expectedMemoryBytes, limits.MemoryBytes)
+
+			require.NoError(t, tc.config.Normalize())
+			limits = manager.GetLimits(info, tc.config)
+			require.Equal(t, tc.expectedMemoryBytes, limits.MemoryBytes)
+		})
+	}
+}
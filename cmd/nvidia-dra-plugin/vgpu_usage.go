@@ -0,0 +1,253 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	VGpuUsageFile       = "usage.json"
+	VGpuUsageFileEnvvar = "CUDA_DEVICE_USAGE_FILE"
+)
+
+// VGpuClaimInfo describes a prepared vGPU claim. It is written by the plugin
+// next to the claim's control directory so that usage can be attributed to
+// the claim and its pods. It is kept out of the control directory, which is
+// writable by the claim's containers, so that they cannot change it.
+type VGpuClaimInfo struct {
+	Namespace string                 `json:"namespace"`
+	Name      string                 `json:"name"`
+	Pods      []string               `json:"pods"`
+	Limits    map[string]*VGpuLimits `json:"limits"`
+}
+
+// VGpuUsage is the usage cache written by the interception library into the
+// claim's control directory. It holds one entry per physical GPU in use.
+type VGpuUsage struct {
+	Devices []VGpuDeviceUsage `json:"devices"`
+}
+
+type VGpuDeviceUsage struct {
+	UUID            string `json:"uuid"`
+	MemoryUsedBytes uint64 `json:"memoryUsedBytes"`
+	SMUtilization   uint64 `json:"smUtilization"`
+	OOMRejections   uint64 `json:"oomRejections"`
+}
+
+// CreateClaimDir creates the control directory for a claim that the
+// interception library writes its usage cache into.
+func (m *VGpuManager) CreateClaimDir(claimUID string) error {
+	dir := m.claimControlDir(claimUID)
+	if err := os.MkdirAll(dir, 0733); err != nil {
+		return fmt.Errorf("error creating directory %v: %w", dir, err)
+	}
+	// Containers may run as any user, so the directory must be writable by
+	// all. Only the plugin needs to list it.
+	if err := os.Chmod(dir, 0733); err != nil {
+		return fmt.Errorf("error setting permissions on directory %v: %w", dir, err)
+	}
+	return nil
+}
+
+// WriteClaimInfo records the metadata of a prepared vGPU claim.
+func (m *VGpuManager) WriteClaimInfo(claim *resourceapi.ResourceClaim, devices PreparedDevices) error {
+	info := VGpuClaimInfo{
+		Namespace: claim.Namespace,
+		Name:      claim.Name,
+		Limits:    make(map[string]*VGpuLimits),
+	}
+	for _, ref := range claim.Status.ReservedFor {
+		if ref.Resource == "pods" {
+			info.Pods = append(info.Pods, ref.Name)
+		}
+	}
+	for _, group := range devices {
+		for _, device := range group.Devices.VGpus() {
+			uuid := device.VGpu.Info.ParentUUID
+			if info.Limits[uuid] == nil {
+				info.Limits[uuid] = &VGpuLimits{}
+			}
+			info.Limits[uuid].Core += device.VGpu.Limits.Core
+			info.Limits[uuid].MemoryBytes += device.VGpu.Limits.MemoryBytes
+			info.Limits[uuid].Priority = max(info.Limits[uuid].Priority, device.VGpu.Limits.Priority)
+		}
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("error marshaling claim info: %w", err)
+	}
+
+	dir := filepath.Dir(m.claimInfoPath(string(claim.UID)))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating directory %v: %w", dir, err)
+	}
+	path := m.claimInfoPath(string(claim.UID))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing %v: %w", path, err)
+	}
+	return nil
+}
+
+// DeleteClaimDir removes the control directory and the metadata of a claim.
+// The metadata is removed first, so that a directory is always left behind
+// for the garbage collector to find if the plugin stops in between.
+func (m *VGpuManager) DeleteClaimDir(claimUID string) error {
+	path := m.claimInfoPath(claimUID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing %v: %w", path, err)
+	}
+	dir := m.claimControlDir(claimUID)
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("error removing directory %v: %w", dir, err)
+	}
+	return nil
+}
+
+// ListClaimDirs returns the UIDs of all claims with a control directory on
+// this node.
+func (m *VGpuManager) ListClaimDirs() ([]string, error) {
+	root := filepath.Join(m.controlFilesRoot, "claims")
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %v: %w", root, err)
+	}
+
+	var claimUIDs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			claimUIDs = append(claimUIDs, entry.Name())
+		}
+	}
+	return claimUIDs, nil
+}
+
+func (m *VGpuManager) claimControlDir(claimUID string) string {
+	return filepath.Join(m.controlFilesRoot, "claims", claimUID)
+}
+
+func (m *VGpuManager) claimInfoPath(claimUID string) string {
+	return filepath.Join(m.controlFilesRoot, "claim-info", claimUID+".json")
+}
+
+// VGpuUsageCollector exposes the usage caches of all prepared vGPU claims as
+// Prometheus metrics.
+type VGpuUsageCollector struct {
+	manager *VGpuManager
+
+	memoryUsed    *prometheus.Desc
+	memoryLimit   *prometheus.Desc
+	smUtilization *prometheus.Desc
+	oomRejections *prometheus.Desc
+}
+
+var _ prometheus.Collector = &VGpuUsageCollector{}
+
+func NewVGpuUsageCollector(manager *VGpuManager) *VGpuUsageCollector {
+	labels := []string{"namespace", "claim", "claim_uid", "pod", "uuid"}
+	return &VGpuUsageCollector{
+		manager: manager,
+		memoryUsed: prometheus.NewDesc(
+			"nvidia_dra_vgpu_memory_used_bytes",
+			"Device memory currently allocated by a vGPU claim.",
+			labels, nil,
+		),
+		memoryLimit: prometheus.NewDesc(
+			"nvidia_dra_vgpu_memory_limit_bytes",
+			"Device memory limit of a vGPU claim.",
+			labels, nil,
+		),
+		smUtilization: prometheus.NewDesc(
+			"nvidia_dra_vgpu_sm_utilization_percent",
+			"Streaming multiprocessor utilization of a vGPU claim.",
+			labels, nil,
+		),
+		oomRejections: prometheus.NewDesc(
+			"nvidia_dra_vgpu_oom_rejections_total",
+			"Number of device memory allocations of a vGPU claim rejected for exceeding its limit.",
+			labels, nil,
+		),
+	}
+}
+
+func (c *VGpuUsageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.memoryUsed
+	ch <- c.memoryLimit
+	ch <- c.smUtilization
+	ch <- c.oomRejections
+}
+
+func (c *VGpuUsageCollector) Collect(ch chan<- prometheus.Metric) {
+	claimUIDs, err := c.manager.ListClaimDirs()
+	if err != nil {
+		klog.Warningf("Error reading vGPU claim directories: %v", err)
+		return
+	}
+
+	for _, claimUID := range claimUIDs {
+		var info VGpuClaimInfo
+		if err := readJSONFile(c.manager.claimInfoPath(claimUID), &info); err != nil {
+			// The claim may still be in the process of being prepared.
+			klog.V(6).Infof("Skipping vGPU claim %v: %v", claimUID, err)
+			continue
+		}
+		pods := strings.Join(info.Pods, ",")
+
+		for uuid, limits := range info.Limits {
+			ch <- prometheus.MustNewConstMetric(c.memoryLimit, prometheus.GaugeValue, float64(limits.MemoryBytes), info.Namespace, info.Name, claimUID, pods, uuid)
+		}
+
+		var usage VGpuUsage
+		if err := readJSONFile(filepath.Join(c.manager.claimControlDir(claimUID), VGpuUsageFile), &usage); err != nil {
+			// No usage is reported until the interception library has been
+			// loaded by a process in one of the claim's containers.
+			klog.V(6).Infof("No usage for vGPU claim %v: %v", claimUID, err)
+			continue
+		}
+
+		for _, d := range usage.Devices {
+			labels := []string{info.Namespace, info.Name, claimUID, pods, d.UUID}
+			ch <- prometheus.MustNewConstMetric(c.memoryUsed, prometheus.GaugeValue, float64(d.MemoryUsedBytes), labels...)
+			ch <- prometheus.MustNewConstMetric(c.smUtilization, prometheus.GaugeValue, float64(d.SMUtilization), labels...)
+			ch <- prometheus.MustNewConstMetric(c.oomRejections, prometheus.CounterValue, float64(d.OOMRejections), labels...)
+		}
+	}
+}
+
+// readJSONFile decodes a JSON file. Symlinks are not followed, as the usage
+// caches are written by the claim's containers.
+func readJSONFile(path string, v any) error {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewDecoder(f).Decode(v)
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2024, NVIDIA CORPORATION.  All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	resourceapi "k8s.io/api/resource/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const vgpuUsageFixture = `{
+  "devices": [
+    {
+      "uuid": "GPU-0",
+      "memoryUsedBytes": 1073741824,
+      "smUtilization": 42,
+      "oomRejections": 3
+    }
+  ]
+}`
+
+func TestVGpuUsageCollector(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	manager := &VGpuManager{controlFilesRoot: t.TempDir()}
+
+	claim := &resourceapi.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "tenant",
+			Name:      "claim",
+			UID:       "claim-uid",
+		},
+		Status: resourceapi.ResourceClaimStatus{
+			ReservedFor: []resourceapi.ResourceClaimConsumerReference{
+				{Resource: "pods", Name: "pod-0"},
+			},
+		},
+	}
+	devices := PreparedDevices{
+		{
+			Devices: PreparedDeviceList{
+				{
+					VGpu: &PreparedVGpu{
+						Info:   &VGpuInfo{ParentUUID: "GPU-0"},
+						Limits: &VGpuLimits{Core: 50, MemoryBytes: 4 * gib},
+					},
+				},
+			},
+		},
+	}
+	require.NoError(t, manager.CreateClaimDir(string(claim.UID)))
+	require.NoError(t, manager.WriteClaimInfo(claim, devices))
+
+	// The claim's containers can write to the control directory, so the
+	// claim's metadata must not be kept in it.
+	dir := manager.claimControlDir(string(claim.UID))
+	stat, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0733), stat.Mode().Perm())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, VGpuUsageFile), []byte(vgpuUsageFixture), 0644))
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(NewVGpuUsageCollector(manager)))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	expectedLabels := map[string]string{
+		"namespace": "tenant",
+		"claim":     "claim",
+		"claim_uid": "claim-uid",
+		"pod":       "pod-0",
+		"uuid":      "GPU-0",
+	}
+	expectedValues := map[string]float64{
+		"nvidia_dra_vgpu_memory_used_bytes":      1 * gib,
+		"nvidia_dra_vgpu_memory_limit_bytes":     4 * gib,
+		"nvidia_dra_vgpu_sm_utilization_percent": 42,
+		"nvidia_dra_vgpu_oom_rejections_total":   3,
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		require.Len(t, family.GetMetric(), 1, family.GetName())
+		metric := family.GetMetric()[0]
+		require.Equal(t, expectedLabels, metricLabels(metric), family.GetName())
+		values[family.GetName()] = metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+	}
+	require.Equal(t, expectedValues, values)
+
+	require.NoError(t, manager.DeleteClaimDir(string(claim.UID)))
+	families, err = registry.Gather()
+	require.NoError(t, err)
+	require.Empty(t, families)
+}
+
+func metricLabels(metric *dto.Metric) map[string]string {
+	labels := make(map[string]string)
+	for _, label := range metric.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}